@@ -0,0 +1,28 @@
+package login
+
+import (
+	"fmt"
+	"time"
+)
+
+// Session is a device a User signed in from. Its access token is short-lived
+// and renewed with its refresh token, which changes on every renewal. The
+// previous refresh token is kept to detect its reuse. Both refresh tokens are
+// stored as SHA-256 digests, the access token in clear to be revoked.
+type Session struct {
+	UUID                 string    `json:"uuid" bson:"uuid"`
+	UserUUID             string    `json:"userUUID" bson:"userUUID"`
+	Device               string    `json:"device" bson:"device"`
+	IP                   string    `json:"ip" bson:"ip"`
+	AccessToken          string    `json:"-" bson:"accessToken"`
+	RefreshToken         string    `json:"-" bson:"refreshToken"`
+	PreviousRefreshToken string    `json:"-" bson:"previousRefreshToken,omitempty"`
+	CreatedAt            time.Time `json:"createdAt" bson:"createdAt"`
+	LastUsedAt           time.Time `json:"lastUsedAt" bson:"lastUsedAt"`
+}
+
+func (session *Session) String() string {
+	return fmt.Sprintf("UUID:%s UserUUID:%s Device:%s IP:%s CreatedAt:%s LastUsedAt:%s",
+		session.UUID, session.UserUUID, session.Device, session.IP,
+		session.CreatedAt.Format(time.RFC3339), session.LastUsedAt.Format(time.RFC3339))
+}
@@ -15,16 +15,33 @@ const (
 	ARCHIVED
 )
 
-var UserFullProjection = map[string]interface{}{
-	"email":               1,
-	"uuid":                1,
-	"firstName":           1,
-	"lastName":            1,
-	"accessToken":         1,
-	"initializationToken": 1,
-	"activationToken":     1,
-	"state":               1,
-	"password":            1,
+// UserField names a field of User, as stored by gateways.
+type UserField string
+
+const (
+	USER_UUID                 = UserField("uuid")
+	USER_EMAIL                = UserField("email")
+	USER_FIRST_NAME           = UserField("firstName")
+	USER_LAST_NAME            = UserField("lastName")
+	USER_ACTIVATION_TOKEN     = UserField("activationToken")
+	USER_INITIALIZATION_TOKEN = UserField("initializationToken")
+	USER_STATE                = UserField("state")
+	USER_PASSWORD             = UserField("password")
+)
+
+// UserProjection lists the fields of User a gateway returns, the others
+// being left empty. An empty projection returns every field.
+type UserProjection []UserField
+
+var UserFullProjection = UserProjection{
+	USER_UUID,
+	USER_EMAIL,
+	USER_FIRST_NAME,
+	USER_LAST_NAME,
+	USER_ACTIVATION_TOKEN,
+	USER_INITIALIZATION_TOKEN,
+	USER_STATE,
+	USER_PASSWORD,
 }
 
 type User struct {
@@ -32,9 +49,8 @@ type User struct {
 	Email               string    `json:"email" bson:"email"`
 	FirstName           string    `json:"firstName" bson:"firstName"`
 	LastName            string    `json:"lastName" bson:"lastName"`
-	AccessToken         string    `json:"access-token" bson:"accessToken"`
-	ActivationToken     string    `json:"activation-token" bson:"activationToken"`
-	InitializationToken string    `json:"initialization-token" bson:"initializationToken"`
+	ActivationToken     string    `json:"activation-token" bson:"activationToken,omitempty"`
+	InitializationToken string    `json:"initialization-token" bson:"initializationToken,omitempty"`
 	State               UserState `json:"state" bson:"state"`
 	Password            string    `json:"-" bson:"password"`
 }
@@ -44,7 +60,6 @@ func NewUser(uuid,
 	password,
 	firstName,
 	lastName,
-	accessToken,
 	activationToken,
 	initializationToken string,
 	state UserState) *User {
@@ -55,7 +70,6 @@ func NewUser(uuid,
 		Password:            password,
 		FirstName:           firstName,
 		LastName:            lastName,
-		AccessToken:         accessToken,
 		ActivationToken:     activationToken,
 		InitializationToken: initializationToken,
 		State:               state,
@@ -89,10 +103,6 @@ func (user *User) String() string {
 		str = fmt.Sprintf("%s State:%d", str, user.State)
 	}
 
-	if user.AccessToken != "" {
-		str = fmt.Sprintf("%s AccessToken:%s", str, user.AccessToken)
-	}
-
 	if user.ActivationToken != "" {
 		str = fmt.Sprintf("%s ActivationToken:%s", str, user.ActivationToken)
 	}
@@ -146,12 +156,6 @@ func (builder *userBuilder) Password(password string) *userBuilder {
 	return builder
 }
 
-func (builder *userBuilder) AccessToken(token string) *userBuilder {
-	builder.user.AccessToken = token
-
-	return builder
-}
-
 func (builder *userBuilder) InitializationToken(token string) *userBuilder {
 	builder.user.InitializationToken = token
 
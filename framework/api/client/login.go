@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	loginEntity "github.com/kukinsula/boxy/entity/login"
+	"github.com/kukinsula/boxy/usecase"
 	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 )
 
@@ -114,6 +115,7 @@ func (login *Login) Signin(
 		Body: map[string]interface{}{
 			"email":    params.Email,
 			"password": params.Password,
+			"device":   params.Device,
 		},
 	}).Decode(result)
 
@@ -128,6 +130,30 @@ func (login *Login) Signin(
 	return result, nil
 }
 
+func (login *Login) Refresh(uuid, token string) (*loginUsecase.SigninResult, error) {
+	result := &loginUsecase.SigninResult{}
+	resp, err := login.POST(&Request{
+		UUID: uuid,
+		Path: "/login/refresh",
+		Headers: map[string][]string{
+			"Encoding-Type": []string{"application/json"},
+		},
+		Body: map[string]interface{}{
+			"token": token,
+		},
+	}).Decode(result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("Refresh should return Status code 200, not %d", resp.Status)
+	}
+
+	return result, nil
+}
+
 func (login *Login) Me(uuid, token string) (*loginUsecase.SigninResult, error) {
 	result := &loginUsecase.SigninResult{}
 	resp, err := login.GET(&Request{
@@ -149,6 +175,47 @@ func (login *Login) Me(uuid, token string) (*loginUsecase.SigninResult, error) {
 	return result, nil
 }
 
+func (login *Login) Sessions(uuid, token string) ([]*loginEntity.Session, error) {
+	result := []*loginEntity.Session{}
+	resp, err := login.GET(&Request{
+		UUID: uuid,
+		Path: "/login/sessions",
+		Headers: map[string][]string{
+			"Authorization": []string{fmt.Sprintf("Bearer %s", token)},
+		},
+	}).Decode(&result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("Sessions should return Status code 200, not %d", resp.Status)
+	}
+
+	return result, nil
+}
+
+func (login *Login) RevokeSession(uuid, token, session string) error {
+	resp := login.DELETE(&Request{
+		UUID: uuid,
+		Path: fmt.Sprintf("/login/sessions/%s", session),
+		Headers: map[string][]string{
+			"Authorization": []string{fmt.Sprintf("Bearer %s", token)},
+		},
+	})
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if resp.Status != 204 {
+		return fmt.Errorf("RevokeSession should return Status code 204, not %d", resp.Status)
+	}
+
+	return nil
+}
+
 func (login *Login) Logout(uuid, token string) error {
 	resp := login.DELETE(&Request{
 		UUID: uuid,
@@ -168,3 +235,21 @@ func (login *Login) Logout(uuid, token string) error {
 
 	return nil
 }
+
+func (login *Login) JWKS(uuid string) (*usecase.JWKS, error) {
+	result := &usecase.JWKS{}
+	resp, err := login.GET(&Request{
+		UUID: uuid,
+		Path: "/.well-known/jwks.json",
+	}).Decode(result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("JWKS should return Status code 200, not %d", resp.Status)
+	}
+
+	return result, nil
+}
@@ -1,26 +1,104 @@
 package server
 
 import (
+	"errors"
+
 	"github.com/kukinsula/boxy/entity/log"
+	"github.com/kukinsula/boxy/usecase"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Authenticate(login LoginBackender, logger log.Logger) gin.HandlerFunc {
+// Authenticate verifies the access token itself and checks that it was not
+// revoked. Only the tokens whose claims do not identify their requester, such
+// as those issued before sessions, or signed by a key tokener does not know
+// yet, are sent to the Login service.
+func Authenticate(
+	login LoginBackender,
+	tokener *usecase.Tokener,
+	revocations loginUsecase.RevocationGateway,
+	logger log.Logger) gin.HandlerFunc {
+
 	return func(ctx *gin.Context) {
 		token, err := getAccessToken(ctx)
 		if err != nil {
-			ctx.JSON(401, gin.H{"error": err})
+			ctx.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
 			return
 		}
 
 		uuid := getRequestUUID(ctx)
-		result, err := login.Me(uuid, ctx, token)
+
+		claims, err := tokener.Verify(token, usecase.VerifyTokenParams{
+			Audience: loginUsecase.TOKEN_AUDIENCE,
+			Issuer:   loginUsecase.TOKEN_ISSUER,
+			Subject:  loginUsecase.ACCESS_TOKEN_SUBJECT,
+		})
+
+		if errors.Is(err, usecase.UnknownKeyErr) {
+			logger(uuid, log.DEBUG, "Authenticate.Fallback",
+				map[string]interface{}{"error": err})
+
+			fallback(ctx, login, uuid, token)
+			return
+		}
+
+		if err != nil {
+			code := loginUsecase.InvalidTokenErr.Code
+			if errors.Is(err, usecase.ExpiredTokenErr) {
+				code = loginUsecase.TokenExpiredErr.Code
+			}
+
+			ctx.AbortWithStatusJSON(401, gin.H{"error": code, "message": err.Error()})
+			return
+		}
+
+		if !sufficient(claims) {
+			logger(uuid, log.DEBUG, "Authenticate.Fallback",
+				map[string]interface{}{"id": claims.ID})
+
+			fallback(ctx, login, uuid, token)
+			return
+		}
+
+		revoked, err := revocations.IsRevoked(uuid, ctx, claims.ID)
 		if err != nil {
-			ctx.JSON(500, gin.H{"error": "ME_UNAVAILABLE"})
+			sendError(ctx, "REVOCATIONS_UNAVAILABLE", err)
+			return
+		}
+
+		if revoked {
+			ctx.AbortWithStatusJSON(401, gin.H{
+				"error":   loginUsecase.InvalidTokenErr.Code,
+				"message": "Access token revoked",
+			})
 			return
 		}
 
-		ctx.Set(REQUESTER_INFO, result)
+		ctx.Set(REQUESTER_INFO, &loginUsecase.SigninResult{
+			UUID:        claims.UUID,
+			Email:       claims.Email,
+			Session:     claims.Session,
+			AccessToken: token,
+		})
 	}
 }
+
+// fallback authenticates the requester with the Login service.
+func fallback(ctx *gin.Context, login LoginBackender, uuid, token string) {
+	result, err := login.Me(uuid, ctx, token)
+	if err != nil {
+		sendError(ctx, "ME_UNAVAILABLE", err)
+		return
+	}
+
+	ctx.Set(REQUESTER_INFO, result)
+}
+
+// sufficient tells whether claims identify the requester of an access token
+// and can be revoked.
+func sufficient(claims *usecase.Claims) bool {
+	return claims.ID != "" &&
+		claims.UUID != "" &&
+		claims.Session != ""
+}
@@ -5,6 +5,7 @@ import (
 
 	loginEntity "github.com/kukinsula/boxy/entity/login"
 	redisFramework "github.com/kukinsula/boxy/framework/redis"
+	"github.com/kukinsula/boxy/usecase"
 	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 )
 
@@ -41,13 +42,28 @@ type LoginBackender interface {
 		context context.Context,
 		params *loginUsecase.SigninParams) (*loginUsecase.SigninResult, error)
 
+	Refresh(uuid string,
+		context context.Context,
+		params *loginUsecase.RefreshParams) (*loginUsecase.SigninResult, error)
+
 	Me(uuid string,
 		context context.Context,
 		token string) (*loginUsecase.SigninResult, error)
 
+	Sessions(uuid string,
+		context context.Context,
+		token string) ([]*loginEntity.Session, error)
+
+	RevokeSession(uuid string,
+		context context.Context,
+		token, session string) error
+
 	Logout(uuid string,
 		context context.Context,
 		token string) error
+
+	JWKS(uuid string,
+		context context.Context) (*usecase.JWKS, error)
 }
 
 type StreamingBackender interface {
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+
+	redisFramework "github.com/kukinsula/boxy/framework/redis"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errorStatuses = map[redisFramework.ErrorCode]int{
+	redisFramework.INVALID_PARAMS: 400,
+	redisFramework.INVALID_TOKEN:  401,
+	redisFramework.NOT_FOUND:      404,
+	redisFramework.CONFLICT:       409,
+	redisFramework.UNSUPPORTED:    502,
+
+	loginErrorCode(loginUsecase.WeakPasswordErr):       400,
+	loginErrorCode(loginUsecase.InvalidCredentialsErr): 401,
+	loginErrorCode(loginUsecase.InvalidTokenErr):       401,
+	loginErrorCode(loginUsecase.TokenExpiredErr):       401,
+	loginErrorCode(loginUsecase.UserNotFoundErr):       404,
+	loginErrorCode(loginUsecase.SessionNotFoundErr):    404,
+	loginErrorCode(loginUsecase.EmailTakenErr):         409,
+	loginErrorCode(loginUsecase.WrongStateErr):         409,
+}
+
+func loginErrorCode(err *loginUsecase.Error) redisFramework.ErrorCode {
+	return redisFramework.ErrorCode(err.Code)
+}
+
+// sendError aborts the request with the status matching the error code sent
+// back by the backend, or a 500 with the fallback code when it is unknown.
+func sendError(ctx *gin.Context, fallback string, err error) {
+	var timeout *redisFramework.TimeoutError
+	var failure *redisFramework.Error
+
+	status, body := 500, gin.H{"error": fallback, "message": err.Error()}
+
+	switch {
+	case errors.As(err, &timeout):
+		status, body["error"] = 504, "TIMEOUT"
+
+	case errors.As(err, &failure):
+		code, ok := errorStatuses[failure.Code]
+		if ok {
+			status, body["error"] = code, failure.Code
+		}
+
+		body["message"] = failure.Message
+
+		if len(failure.Details) != 0 {
+			body["details"] = failure.Details
+		}
+	}
+
+	ctx.AbortWithStatusJSON(status, body)
+}
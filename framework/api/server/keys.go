@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/log"
+	"github.com/kukinsula/boxy/usecase"
+)
+
+// UpdateKeys gives tokener the keys the Login service publishes.
+func UpdateKeys(
+	uuid string,
+	ctx context.Context,
+	login LoginBackender,
+	tokener *usecase.Tokener) error {
+
+	jwks, err := login.JWKS(uuid, ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := usecase.KeysFromJWKS(jwks)
+	if err != nil {
+		return err
+	}
+
+	return tokener.SetKeys(keys)
+}
+
+// refreshKeys updates the keys of the Tokener until the API shuts down, so
+// that it knows those the Login service schedules before they sign.
+func (api *API) refreshKeys() {
+	ticker := time.NewTicker(api.config.KeysRefresh)
+	defer ticker.Stop()
+
+	for goOn := true; goOn; {
+		select {
+		case <-api.stop:
+			goOn = false
+
+		case <-ticker.C:
+			uuid := entity.NewUUID()
+			ctx, cancel := context.WithTimeout(context.Background(), api.config.KeysRefresh)
+
+			err := UpdateKeys(uuid, ctx, api.backend.Login, api.config.Tokener)
+			cancel()
+
+			if err != nil {
+				api.logger(uuid, log.ERROR, "API.RefreshKeys",
+					map[string]interface{}{"error": err})
+			}
+		}
+	}
+}
@@ -22,10 +22,7 @@ func Signup(login LoginBackender) gin.HandlerFunc {
 		uuid := getRequestUUID(ctx)
 		user, err := login.Signup(uuid, ctx, &params)
 		if err != nil {
-			ctx.JSON(500, gin.H{
-				"error":   "SIGNUP_UNVAILABLE",
-				"message": err.Error(),
-			})
+			sendError(ctx, "SIGNUP_UNVAILABLE", err)
 			return
 		}
 
@@ -49,10 +46,7 @@ func CheckActivate(login LoginBackender) gin.HandlerFunc {
 		uuid := getRequestUUID(ctx)
 		err = login.CheckActivate(uuid, ctx, &params)
 		if err != nil {
-			ctx.JSON(500, gin.H{
-				"error":   "CHECK_ACTIVATE_UNAVAILABLE",
-				"message": err.Error(),
-			})
+			sendError(ctx, "CHECK_ACTIVATE_UNAVAILABLE", err)
 			return
 		}
 
@@ -76,10 +70,7 @@ func Activate(login LoginBackender) gin.HandlerFunc {
 		uuid := getRequestUUID(ctx)
 		err = login.Activate(uuid, ctx, &params)
 		if err != nil {
-			ctx.JSON(500, gin.H{
-				"error":   "ACTIVATE_UNAVAILABLE",
-				"message": err.Error(),
-			})
+			sendError(ctx, "ACTIVATE_UNAVAILABLE", err)
 			return
 		}
 
@@ -100,16 +91,41 @@ func Signin(login LoginBackender) gin.HandlerFunc {
 			return
 		}
 
+		params.IP = ctx.ClientIP()
+
 		uuid := getRequestUUID(ctx)
 		result, err := login.Signin(uuid, ctx, &params)
 		if err != nil {
-			ctx.JSON(500, gin.H{
-				"error":   "SIGNIN_UNAVAILABLE",
+			sendError(ctx, "SIGNIN_UNAVAILABLE", err)
+			return
+		}
+
+		ctx.JSON(200, result)
+	}
+}
+
+func Refresh(login LoginBackender) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var params loginUsecase.RefreshParams
+
+		err := ctx.BindJSON(&params)
+		if err != nil {
+			ctx.JSON(400, gin.H{
+				"error":   "INVALID_JSON",
 				"message": err.Error(),
 			})
 			return
 		}
 
+		params.IP = ctx.ClientIP()
+
+		uuid := getRequestUUID(ctx)
+		result, err := login.Refresh(uuid, ctx, &params)
+		if err != nil {
+			sendError(ctx, "REFRESH_UNAVAILABLE", err)
+			return
+		}
+
 		ctx.JSON(200, result)
 	}
 }
@@ -125,10 +141,7 @@ func Me(login LoginBackender) gin.HandlerFunc {
 
 		result, err := login.Me(uuid, ctx, token)
 		if err != nil {
-			ctx.JSON(500, gin.H{
-				"error":   "ME_UNAVAILABLE",
-				"message": err.Error(),
-			})
+			sendError(ctx, "ME_UNAVAILABLE", err)
 			return
 		}
 
@@ -136,6 +149,44 @@ func Me(login LoginBackender) gin.HandlerFunc {
 	}
 }
 
+func Sessions(login LoginBackender) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uuid := getRequestUUID(ctx)
+		token, err := getAccessToken(ctx)
+		if err != nil {
+			ctx.JSON(401, gin.H{"error": "AccessToken missing"})
+			return
+		}
+
+		sessions, err := login.Sessions(uuid, ctx, token)
+		if err != nil {
+			sendError(ctx, "SESSIONS_UNAVAILABLE", err)
+			return
+		}
+
+		ctx.JSON(200, sessions)
+	}
+}
+
+func RevokeSession(login LoginBackender) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		uuid := getRequestUUID(ctx)
+		token, err := getAccessToken(ctx)
+		if err != nil {
+			ctx.JSON(401, gin.H{"error": "AccessToken missing"})
+			return
+		}
+
+		err = login.RevokeSession(uuid, ctx, token, ctx.Param("session"))
+		if err != nil {
+			sendError(ctx, "REVOKE_SESSION_UNAVAILABLE", err)
+			return
+		}
+
+		ctx.JSON(204, nil)
+	}
+}
+
 func Logout(login LoginBackender) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		uuid := getRequestUUID(ctx)
@@ -147,13 +198,24 @@ func Logout(login LoginBackender) gin.HandlerFunc {
 
 		err = login.Logout(uuid, ctx, token)
 		if err != nil {
-			ctx.JSON(500, gin.H{
-				"error":   "LOGOUT_UNAVAILABLE",
-				"message": err.Error(),
-			})
+			sendError(ctx, "LOGOUT_UNAVAILABLE", err)
 			return
 		}
 
 		ctx.JSON(204, nil)
 	}
 }
+
+// JWKS serves the public keys verifying the tokens of the Login service.
+func JWKS(login LoginBackender) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		jwks, err := login.JWKS(getRequestUUID(ctx), ctx)
+		if err != nil {
+			sendError(ctx, "JWKS_UNAVAILABLE", err)
+			return
+		}
+
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(200, jwks)
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/kukinsula/boxy/entity"
 	"github.com/kukinsula/boxy/entity/log"
+	"github.com/kukinsula/boxy/usecase"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,10 +19,16 @@ type Logger func(
 	level, message string,
 	meta map[string]interface{})
 
+// Config of the API. Tokener must hold the keys of the Login service, and
+// Revocations its revoked tokens, for the API to verify access tokens itself.
+// Every KeysRefresh, Tokener is given the keys the Login service publishes.
 type Config struct {
-	Address string `yaml:"address"`
-	Backend *Backend
-	Logger  log.Logger
+	Address     string `yaml:"address"`
+	Backend     *Backend
+	Tokener     *usecase.Tokener
+	KeysRefresh time.Duration `yaml:"keys-refresh"`
+	Revocations loginUsecase.RevocationGateway
+	Logger      log.Logger
 }
 
 type API struct {
@@ -30,6 +38,7 @@ type API struct {
 	server  *http.Server
 	engine  *gin.Engine
 	done    chan error
+	stop    chan struct{}
 }
 
 func NewAPI(config Config) *API {
@@ -44,6 +53,7 @@ func NewAPI(config Config) *API {
 		},
 		engine: engine,
 		done:   make(chan error),
+		stop:   make(chan struct{}),
 	}
 
 	return api
@@ -52,6 +62,10 @@ func NewAPI(config Config) *API {
 func (api *API) Run() {
 	api.engine.Use(Welcome(api.logger))
 
+	if api.config.KeysRefresh > 0 {
+		go api.refreshKeys()
+	}
+
 	public := api.engine.Group("/")
 	{
 		public.POST("/login/signup",
@@ -65,14 +79,29 @@ func (api *API) Run() {
 
 		api.engine.POST("/login/signin",
 			Signin(api.backend.Login))
+
+		public.POST("/login/refresh",
+			Refresh(api.backend.Login))
+
+		public.GET("/.well-known/jwks.json",
+			JWKS(api.backend.Login))
 	}
 
 	private := api.engine.Group("/")
-	private.Use(Authenticate(api.backend.Login, api.logger))
+	private.Use(Authenticate(api.backend.Login,
+		api.config.Tokener,
+		api.config.Revocations,
+		api.logger))
 	{
 		private.GET("/login/me",
 			Me(api.backend.Login))
 
+		private.GET("/login/sessions",
+			Sessions(api.backend.Login))
+
+		private.DELETE("/login/sessions/:session",
+			RevokeSession(api.backend.Login))
+
 		private.DELETE("/login/logout",
 			Logout(api.backend.Login))
 
@@ -87,6 +116,8 @@ func (api *API) Shutdown(ctx context.Context) (err error) {
 	failure := make(chan error)
 	defer close(failure)
 
+	close(api.stop)
+
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
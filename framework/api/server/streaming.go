@@ -119,11 +119,10 @@ func Streaming(
 	subscription := streaming.Subscribe(ctx)
 	set := NewStreamingSet()
 
-	<-subscription.Subscribed
-
+	// Message stays open while the subscription reconnects
 	go func() {
-		for data := range subscription.Message {
-			set.Send(data)
+		for message := range subscription.Message {
+			set.Send(message.Data)
 		}
 	}()
 
@@ -0,0 +1,374 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexParams declares an index of a model. Keys are ordered, an index on
+// several keys being compound. ExpireAfter makes it a TTL index, on a single
+// date key. Partial restricts it to the documents matching the filter.
+type indexParams struct {
+	Name        string
+	Keys        []indexKey
+	Unique      bool
+	Background  bool
+	Sparse      bool
+	ExpireAfter time.Duration
+	Partial     bson.D
+}
+
+type indexKey struct {
+	Field string
+	Value int32 // 1 ascending, -1 descending
+}
+
+type IndexDriftKind string
+
+const (
+	INDEX_MISSING    = IndexDriftKind("MISSING")
+	INDEX_CHANGED    = IndexDriftKind("CHANGED")
+	INDEX_UNDECLARED = IndexDriftKind("UNDECLARED")
+)
+
+// IndexDrift is a difference between the indexes a model declares and those
+// of its collection.
+type IndexDrift struct {
+	Collection string
+	Index      string
+	Kind       IndexDriftKind
+	Details    string
+}
+
+func (drift IndexDrift) String() string {
+	str := fmt.Sprintf("%s.%s %s", drift.Collection, drift.Index, drift.Kind)
+	if drift.Details != "" {
+		str = fmt.Sprintf("%s: %s", str, drift.Details)
+	}
+
+	return str
+}
+
+// indexSpec is an index as listed by the server.
+type indexSpec struct {
+	Name                    string `bson:"name"`
+	Key                     bson.D `bson:"key"`
+	Unique                  bool   `bson:"unique"`
+	Sparse                  bool   `bson:"sparse"`
+	ExpireAfterSeconds      *int64 `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.D `bson:"partialFilterExpression"`
+}
+
+func indexModel(index indexParams) mongo.IndexModel {
+	keys := bson.D{}
+	for _, key := range index.Keys {
+		keys = append(keys, bson.E{Key: key.Field, Value: key.Value})
+	}
+
+	opts := options.Index().
+		SetName(index.Name).
+		SetUnique(index.Unique).
+		SetBackground(index.Background).
+		SetSparse(index.Sparse).
+		SetStorageEngine(bson.D{{
+			Key:   "wiredTiger",
+			Value: bson.D{{Key: "configString", Value: "block_compressor=zlib"}},
+		}})
+
+	if index.ExpireAfter != 0 {
+		opts.SetExpireAfterSeconds(int32(index.ExpireAfter / time.Second))
+	}
+
+	if len(index.Partial) != 0 {
+		opts.SetPartialFilterExpression(index.Partial)
+	}
+
+	return mongo.IndexModel{Keys: keys, Options: opts}
+}
+
+// createIndexes creates the indexes the model declares. Migrations call it,
+// not the programs.
+func (model *model) createIndexes(uuid string, ctx context.Context) error {
+	for _, index := range model.params.Indexes {
+		err := model.createindex(uuid, ctx, indexModel(index))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (model *model) dropIndexes(uuid string, ctx context.Context) error {
+	for _, index := range model.params.Indexes {
+		err := model.dropindex(uuid, ctx, index.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (model *model) createindex(
+	uuid string,
+	ctx context.Context,
+	index mongo.IndexModel,
+	opts ...*options.CreateIndexesOptions) error {
+
+	_, err := model.collection.Indexes().CreateOne(ctx, index, opts...)
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.EnsureIndex", model.params.Name),
+		map[string]interface{}{
+			"name":   *index.Options.Name,
+			"unique": *index.Options.Unique,
+			"error":  err,
+		})
+
+	return err
+}
+
+func (model *model) dropindex(uuid string, ctx context.Context, name string) error {
+	_, err := model.collection.Indexes().DropOne(ctx, name)
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.DropIndex", model.params.Name),
+		map[string]interface{}{"name": name, "error": err})
+
+	return err
+}
+
+// indexDrift compares the indexes of the collection with those the model
+// declares, by name. The _id index is never reported.
+func (model *model) indexDrift(ctx context.Context) ([]IndexDrift, error) {
+	cursor, err := model.collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := []indexSpec{}
+
+	err = cursor.All(ctx, &specs)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := map[string]indexSpec{}
+	for _, spec := range specs {
+		actual[spec.Name] = spec
+	}
+
+	drifts := []IndexDrift{}
+
+	for _, index := range model.params.Indexes {
+		drift := IndexDrift{Collection: model.params.Name, Index: index.Name}
+
+		spec, ok := actual[index.Name]
+		if !ok {
+			drift.Kind = INDEX_MISSING
+			drifts = append(drifts, drift)
+			continue
+		}
+
+		delete(actual, index.Name)
+
+		drift.Details = diffIndex(index, spec)
+		if drift.Details != "" {
+			drift.Kind = INDEX_CHANGED
+			drifts = append(drifts, drift)
+		}
+	}
+
+	for name := range actual {
+		if name != "_id_" {
+			drifts = append(drifts, IndexDrift{
+				Collection: model.params.Name,
+				Index:      name,
+				Kind:       INDEX_UNDECLARED,
+			})
+		}
+	}
+
+	return drifts, nil
+}
+
+// reconcileIndexes creates the missing indexes, recreates the changed ones
+// and drops the undeclared ones.
+func (model *model) reconcileIndexes(uuid string, ctx context.Context) error {
+	drifts, err := model.indexDrift(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, drift := range drifts {
+		if drift.Kind != INDEX_MISSING {
+			err = model.dropindex(uuid, ctx, drift.Index)
+			if err != nil {
+				return err
+			}
+		}
+
+		if drift.Kind == INDEX_UNDECLARED {
+			continue
+		}
+
+		for _, index := range model.params.Indexes {
+			if index.Name == drift.Index {
+				err = model.createindex(uuid, ctx, indexModel(index))
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IndexDrift reports how the indexes of every collection differ from those
+// their model declares.
+func (database *Database) IndexDrift(ctx context.Context) ([]IndexDrift, error) {
+	drifts := []IndexDrift{}
+
+	for _, model := range database.models() {
+		drift, err := model.indexDrift(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		drifts = append(drifts, drift...)
+	}
+
+	return drifts, nil
+}
+
+// ReconcileIndexes makes the indexes of every collection match those their
+// model declares.
+func (database *Database) ReconcileIndexes(ctx context.Context) error {
+	uuid := entity.NewUUID()
+
+	for _, model := range database.models() {
+		err := model.reconcileIndexes(uuid, ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffIndex describes how spec differs from index, nothing if it does not.
+func diffIndex(index indexParams, spec indexSpec) string {
+	differences := []string{}
+
+	keys := bson.D{}
+	for _, key := range index.Keys {
+		keys = append(keys, bson.E{Key: key.Field, Value: key.Value})
+	}
+
+	if canonical(keys) != canonical(spec.Key) {
+		differences = append(differences,
+			fmt.Sprintf("keys %s instead of %s", canonical(spec.Key), canonical(keys)))
+	}
+
+	if index.Unique != spec.Unique {
+		differences = append(differences,
+			fmt.Sprintf("unique %t instead of %t", spec.Unique, index.Unique))
+	}
+
+	if index.Sparse != spec.Sparse {
+		differences = append(differences,
+			fmt.Sprintf("sparse %t instead of %t", spec.Sparse, index.Sparse))
+	}
+
+	expireAfter := time.Duration(0)
+	if spec.ExpireAfterSeconds != nil {
+		expireAfter = time.Duration(*spec.ExpireAfterSeconds) * time.Second
+	}
+
+	if index.ExpireAfter != expireAfter {
+		differences = append(differences,
+			fmt.Sprintf("expiring after %s instead of %s", expireAfter, index.ExpireAfter))
+	}
+
+	if canonical(index.Partial) != canonical(spec.PartialFilterExpression) {
+		differences = append(differences,
+			fmt.Sprintf("partial filter %s instead of %s",
+				canonical(spec.PartialFilterExpression), canonical(index.Partial)))
+	}
+
+	return strings.Join(differences, ", ")
+}
+
+// canonical renders document as relaxed extended JSON once its numbers are
+// doubles, so that they compare equal whatever their BSON type: the shell and
+// other drivers declare keys as doubles.
+func canonical(document bson.D) string {
+	if len(document) == 0 {
+		return "{}"
+	}
+
+	data, err := bson.MarshalExtJSON(normalizeNumbers(document), false, false)
+	if err != nil {
+		return fmt.Sprintf("%v", document)
+	}
+
+	return string(data)
+}
+
+// normalizeNumbers returns value with its numbers, nested ones included,
+// converted to float64.
+func normalizeNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+
+	case int32:
+		return float64(value)
+
+	case int64:
+		return float64(value)
+
+	case float32:
+		return float64(value)
+
+	case bson.D:
+		normalized := make(bson.D, 0, len(value))
+		for _, element := range value {
+			normalized = append(normalized,
+				bson.E{Key: element.Key, Value: normalizeNumbers(element.Value)})
+		}
+
+		return normalized
+
+	case bson.M:
+		normalized := make(bson.M, len(value))
+		for key, element := range value {
+			normalized[key] = normalizeNumbers(element)
+		}
+
+		return normalized
+
+	case bson.A:
+		normalized := make(bson.A, 0, len(value))
+		for _, element := range value {
+			normalized = append(normalized, normalizeNumbers(element))
+		}
+
+		return normalized
+
+	default:
+		return value
+	}
+}
@@ -0,0 +1,78 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiffIndex(t *testing.T) {
+	expiresIn := int64(3600)
+
+	index := indexParams{
+		Name:        "compound",
+		Keys:        []indexKey{{Field: "user", Value: 1}, {Field: "date", Value: -1}},
+		Unique:      true,
+		ExpireAfter: time.Hour,
+		Partial:     bson.D{{Key: "state", Value: int32(0)}},
+	}
+
+	spec := indexSpec{
+		Name:                    "compound",
+		Key:                     bson.D{{Key: "user", Value: int32(1)}, {Key: "date", Value: int64(-1)}},
+		Unique:                  true,
+		ExpireAfterSeconds:      &expiresIn,
+		PartialFilterExpression: bson.D{{Key: "state", Value: int64(0)}},
+	}
+
+	diff := diffIndex(index, spec)
+	if diff != "" {
+		t.Errorf("diffIndex should find no difference, got %s", diff)
+	}
+
+	// As declared from the shell or by other drivers
+	doubles := spec
+	doubles.Key = bson.D{{Key: "user", Value: 1.0}, {Key: "date", Value: -1.0}}
+	doubles.PartialFilterExpression = bson.D{
+		{Key: "state", Value: bson.D{{Key: "$in", Value: bson.A{0.0}}}},
+	}
+
+	doublesIndex := index
+	doublesIndex.Partial = bson.D{
+		{Key: "state", Value: bson.D{{Key: "$in", Value: bson.A{int32(0)}}}},
+	}
+
+	diff = diffIndex(doublesIndex, doubles)
+	if diff != "" {
+		t.Errorf("diffIndex should find no difference with double values, got %s", diff)
+	}
+
+	tests := []struct {
+		name   string
+		change func(spec *indexSpec)
+	}{
+		{"keys order", func(spec *indexSpec) {
+			spec.Key = bson.D{{Key: "date", Value: -1}, {Key: "user", Value: 1}}
+		}},
+		{"key direction", func(spec *indexSpec) {
+			spec.Key = bson.D{{Key: "user", Value: 1}, {Key: "date", Value: 1}}
+		}},
+		{"unique", func(spec *indexSpec) { spec.Unique = false }},
+		{"sparse", func(spec *indexSpec) { spec.Sparse = true }},
+		{"TTL", func(spec *indexSpec) { spec.ExpireAfterSeconds = nil }},
+		{"partial filter", func(spec *indexSpec) { spec.PartialFilterExpression = nil }},
+		{"partial filter value", func(spec *indexSpec) {
+			spec.PartialFilterExpression = bson.D{{Key: "state", Value: 0.5}}
+		}},
+	}
+
+	for _, test := range tests {
+		changed := spec
+		test.change(&changed)
+
+		if diffIndex(index, changed) == "" {
+			t.Errorf("diffIndex should report a different %s", test.name)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/log"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	MigrationLockedErr       = errors.New("Migrations are locked by another runner")
+	IrreversibleMigrationErr = errors.New("Migration cannot be reverted")
+	UnknownMigrationErr      = errors.New("Unknown migration version")
+	MigrationLockLostErr     = errors.New("Migrations lock was lost")
+)
+
+const (
+	migrationsCollection    = "migrations"
+	migrationsLock          = "migrations_lock"
+	migrationsLockID        = "migrations"
+	migrationsLockExpiresIn = 10 * time.Minute
+	migrationsLockRenewal   = migrationsLockExpiresIn / 4
+	migrationsLockRetry     = time.Second
+)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(uuid string, ctx context.Context, database *Database) error
+	Down    func(uuid string, ctx context.Context, database *Database) error // nil if irreversible
+}
+
+// migrations are applied in order, once. Never edit an applied migration,
+// append a new one.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create users indexes",
+		Up: func(uuid string, ctx context.Context, database *Database) error {
+			return NewUserModel(database, database.params.Logger).createIndexes(uuid, ctx)
+		},
+		Down: func(uuid string, ctx context.Context, database *Database) error {
+			return NewUserModel(database, database.params.Logger).dropIndexes(uuid, ctx)
+		},
+	},
+
+	{
+		// Users used to be created without a state and with empty tokens
+		Version: 2,
+		Name:    "set users state and remove empty tokens",
+		Up: func(uuid string, ctx context.Context, database *Database) error {
+			users := database.database.Collection("users")
+
+			// Filter and update pairs
+			updates := [][2]bson.M{
+				{
+					{"state": bson.M{"$exists": false}, "activationToken": bson.M{"$nin": bson.A{nil, ""}}},
+					{"$set": bson.M{"state": loginEntity.ACTIVATING}},
+				},
+				{
+					{"state": bson.M{"$exists": false}},
+					{"$set": bson.M{"state": loginEntity.VALID}},
+				},
+			}
+
+			for _, field := range []string{
+				"accessToken",
+				string(loginEntity.USER_ACTIVATION_TOKEN),
+				string(loginEntity.USER_INITIALIZATION_TOKEN),
+			} {
+				updates = append(updates, [2]bson.M{
+					{field: ""},
+					{"$unset": bson.M{field: 1}},
+				})
+			}
+
+			for _, update := range updates {
+				_, err := users.UpdateMany(ctx, update[0], update[1])
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+
+	{
+		// Access tokens moved from users to their sessions, which store the
+		// SHA-256 digests of their refresh tokens
+		Version: 3,
+		Name:    "create sessions indexes and remove users access tokens",
+		Up: func(uuid string, ctx context.Context, database *Database) error {
+			err := NewSessionModel(database, database.params.Logger).createIndexes(uuid, ctx)
+			if err != nil {
+				return err
+			}
+
+			users := NewUserModel(database, database.params.Logger)
+
+			// Absent from the databases created since
+			err = users.dropindex(uuid, ctx, "accessToken")
+			if err != nil && !isIndexNotFoundError(err) {
+				return err
+			}
+
+			_, err = users.collection.UpdateMany(ctx,
+				bson.M{"accessToken": bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{"accessToken": 1}})
+
+			return err
+		},
+	},
+}
+
+// MigrationStatus tells whether the migration Version was applied, and when.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// LatestMigration returns the version every migration brings the database to.
+func LatestMigration() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrations returns the status of every known migration.
+func (database *Database) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := database.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+
+	for index, migration := range migrations {
+		record, ok := applied[migration.Version]
+
+		statuses[index] = MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		}
+	}
+
+	return statuses, nil
+}
+
+// Migrate applies every pending migration.
+func (database *Database) Migrate(ctx context.Context) error {
+	return database.MigrateTo(ctx, LatestMigration())
+}
+
+// MigrateTo applies the pending migrations up to version, then reverts the
+// applied ones above it, newest first. Version 0 reverts everything. The
+// migrations are locked only when there is something to do, so that the
+// runners finding the database up to date never conflict.
+func (database *Database) MigrateTo(ctx context.Context, version int) error {
+	if version != 0 && findMigration(version) == nil {
+		return fmt.Errorf("Migrate to %d failed: %w", version, UnknownMigrationErr)
+	}
+
+	applied, err := database.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !pendingMigrations(applied, version) {
+		return nil
+	}
+
+	uuid := entity.NewUUID()
+
+	err = database.lockMigrations(uuid, ctx)
+	if err != nil {
+		return err
+	}
+
+	defer database.unlockMigrations(uuid)
+
+	// The lease is renewed while migrating, the migrations are cancelled as
+	// soon as it is lost
+	ctx, cancel := context.WithCancel(ctx)
+	lost := make(chan error, 1)
+	renewed := make(chan struct{})
+
+	go func() {
+		defer close(renewed)
+		database.renewMigrationsLock(uuid, ctx, cancel, lost)
+	}()
+
+	defer func() {
+		cancel()
+		<-renewed
+	}()
+
+	err = database.migrate(uuid, ctx, version)
+
+	select {
+	case failure := <-lost:
+		return fmt.Errorf("Migrate to %d failed: %s: %w", version, failure, MigrationLockLostErr)
+
+	default:
+		return err
+	}
+}
+
+func (database *Database) migrate(uuid string, ctx context.Context, version int) error {
+	// Another runner may have migrated in the meantime
+	applied, err := database.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		_, ok := applied[migration.Version]
+		if ok || migration.Version > version {
+			continue
+		}
+
+		err = database.runMigration(uuid, ctx, migration, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for index := len(migrations) - 1; index >= 0; index-- {
+		migration := migrations[index]
+
+		_, ok := applied[migration.Version]
+		if !ok || migration.Version <= version {
+			continue
+		}
+
+		err = database.runMigration(uuid, ctx, migration, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitMigrate migrates to the latest version, waiting for the runner holding
+// the lock to finish first.
+func (database *Database) waitMigrate(ctx context.Context) error {
+	err := database.Migrate(ctx)
+
+	for errors.Is(err, MigrationLockedErr) {
+		database.params.Logger("", log.INFO, "Migration.Wait",
+			map[string]interface{}{"retry": migrationsLockRetry})
+
+		select {
+		case <-time.After(migrationsLockRetry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err = database.Migrate(ctx)
+	}
+
+	return err
+}
+
+// pendingMigrations tells whether migrating to version would apply or revert
+// any migration.
+func pendingMigrations(applied map[int]appliedMigration, version int) bool {
+	for _, migration := range migrations {
+		_, ok := applied[migration.Version]
+
+		if ok != (migration.Version <= version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (database *Database) runMigration(
+	uuid string,
+	ctx context.Context,
+	migration migration,
+	up bool) error {
+
+	collection := database.database.Collection(migrationsCollection)
+	operation := "Up"
+
+	var err error
+
+	if up {
+		err = migration.Up(uuid, ctx, database)
+		if err == nil {
+			_, err = collection.InsertOne(ctx, appliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			})
+		}
+	} else {
+		operation = "Down"
+
+		if migration.Down == nil {
+			err = IrreversibleMigrationErr
+		} else {
+			err = migration.Down(uuid, ctx, database)
+		}
+
+		if err == nil {
+			_, err = collection.DeleteOne(ctx, bson.M{"_id": migration.Version})
+		}
+	}
+
+	database.params.Logger(uuid, log.INFO, fmt.Sprintf("Migration.%s", operation),
+		map[string]interface{}{
+			"version": migration.Version,
+			"name":    migration.Name,
+			"error":   err,
+		})
+
+	if err != nil {
+		return fmt.Errorf("Migration %d (%s) %s failed: %w",
+			migration.Version, migration.Name, operation, err)
+	}
+
+	return nil
+}
+
+func (database *Database) appliedMigrations(
+	ctx context.Context) (map[int]appliedMigration, error) {
+
+	cursor, err := database.database.Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	records := []appliedMigration{}
+
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]appliedMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+// lockMigrations takes the lock, unless another runner holds it and has not
+// let it expire. The lock document is upserted only when it is absent or
+// expired, its unique _id rejecting the others.
+func (database *Database) lockMigrations(uuid string, ctx context.Context) error {
+	now := time.Now()
+
+	_, err := database.database.Collection(migrationsLock).UpdateOne(ctx,
+		bson.M{"_id": migrationsLockID, "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{
+			"owner":     uuid,
+			"expiresAt": now.Add(migrationsLockExpiresIn),
+		}},
+		options.Update().SetUpsert(true))
+
+	if isDuplicateKeyError(err, "_id_") {
+		return MigrationLockedErr
+	}
+
+	return err
+}
+
+// extendMigrationsLock pushes the expiration of the lock held by uuid back,
+// unless it already expired.
+func (database *Database) extendMigrationsLock(uuid string, ctx context.Context) error {
+	now := time.Now()
+
+	result, err := database.database.Collection(migrationsLock).UpdateOne(ctx,
+		bson.M{"_id": migrationsLockID, "owner": uuid, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"expiresAt": now.Add(migrationsLockExpiresIn)}})
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return MigrationLockLostErr
+	}
+
+	return nil
+}
+
+// renewMigrationsLock extends the lock held by uuid until ctx is done. Failed
+// renewals are retried while the lease lasts, then the migrations are
+// cancelled rather than left running alongside another runner.
+func (database *Database) renewMigrationsLock(
+	uuid string,
+	ctx context.Context,
+	cancel context.CancelFunc,
+	lost chan<- error) {
+
+	ticker := time.NewTicker(migrationsLockRenewal)
+	defer ticker.Stop()
+
+	expiresAt := time.Now().Add(migrationsLockExpiresIn)
+
+	for goOn := true; goOn; {
+		select {
+		case <-ctx.Done():
+			goOn = false
+
+		case <-ticker.C:
+			err := database.extendMigrationsLock(uuid, ctx)
+			if err == nil {
+				expiresAt = time.Now().Add(migrationsLockExpiresIn)
+				continue
+			}
+
+			if ctx.Err() != nil {
+				goOn = false
+				continue
+			}
+
+			database.params.Logger(uuid, log.WARN, "Migration.Renew",
+				map[string]interface{}{"error": err})
+
+			// The next renewal would come too late
+			expiring := time.Now().Add(migrationsLockRenewal).After(expiresAt)
+
+			if errors.Is(err, MigrationLockLostErr) || expiring {
+				lost <- err
+				cancel()
+				goOn = false
+			}
+		}
+	}
+}
+
+func (database *Database) unlockMigrations(uuid string) {
+	// The lock must be released even if the migrations were cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), database.params.Timeout)
+	defer cancel()
+
+	_, err := database.database.Collection(migrationsLock).DeleteOne(ctx,
+		bson.M{"_id": migrationsLockID, "owner": uuid})
+
+	if err != nil {
+		database.params.Logger(uuid, log.ERROR, "Migration.Unlock",
+			map[string]interface{}{"error": err})
+	}
+}
+
+func findMigration(version int) *migration {
+	for index := range migrations {
+		if migrations[index].Version == version {
+			return &migrations[index]
+		}
+	}
+
+	return nil
+}
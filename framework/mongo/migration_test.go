@@ -0,0 +1,122 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMigrate(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+
+	defer database.Drop(ctx)
+
+	err := database.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate should not fail: %s", err)
+	}
+
+	expectApplied(t, database, LatestMigration())
+
+	err = database.MigrateTo(ctx, 1)
+	if !errors.Is(err, IrreversibleMigrationErr) {
+		t.Errorf("MigrateTo 1 should fail with %s, got %v", IrreversibleMigrationErr, err)
+	}
+
+	err = database.MigrateTo(ctx, LatestMigration()+1)
+	if !errors.Is(err, UnknownMigrationErr) {
+		t.Errorf("MigrateTo an unknown version should fail with %s, got %v",
+			UnknownMigrationErr, err)
+	}
+
+	err = database.lockMigrations("other", ctx)
+	if err != nil {
+		t.Fatalf("lockMigrations should not fail: %s", err)
+	}
+
+	// Nothing is pending, the lock is not needed
+	err = database.Migrate(ctx)
+	if err != nil {
+		t.Errorf("Migrate should not fail when up to date: %s", err)
+	}
+
+	_, err = database.database.Collection(migrationsCollection).DeleteOne(ctx,
+		bson.M{"_id": LatestMigration()})
+
+	if err != nil {
+		t.Fatalf("DeleteOne should not fail: %s", err)
+	}
+
+	err = database.Migrate(ctx)
+	if !errors.Is(err, MigrationLockedErr) {
+		t.Errorf("Migrate should fail with %s, got %v", MigrationLockedErr, err)
+	}
+
+	// Init waits for the other runner to release the lock
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		database.unlockMigrations("other")
+	}()
+
+	err = database.Init(ctx)
+	if err != nil {
+		t.Errorf("Init should not fail once unlocked: %s", err)
+	}
+
+	expectApplied(t, database, LatestMigration())
+}
+
+func TestExtendMigrationsLock(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+
+	defer database.Drop(ctx)
+
+	err := database.lockMigrations("owner", ctx)
+	if err != nil {
+		t.Fatalf("lockMigrations should not fail: %s", err)
+	}
+
+	err = database.extendMigrationsLock("owner", ctx)
+	if err != nil {
+		t.Errorf("extendMigrationsLock should not fail: %s", err)
+	}
+
+	err = database.extendMigrationsLock("other", ctx)
+	if !errors.Is(err, MigrationLockLostErr) {
+		t.Errorf("extendMigrationsLock should fail with %s for another owner, got %v",
+			MigrationLockLostErr, err)
+	}
+
+	// An expired lease is not renewed, another runner may have taken it
+	_, err = database.database.Collection(migrationsLock).UpdateOne(ctx,
+		bson.M{"_id": migrationsLockID},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Second)}})
+
+	if err != nil {
+		t.Fatalf("UpdateOne should not fail: %s", err)
+	}
+
+	err = database.extendMigrationsLock("owner", ctx)
+	if !errors.Is(err, MigrationLockLostErr) {
+		t.Errorf("extendMigrationsLock should fail with %s once expired, got %v",
+			MigrationLockLostErr, err)
+	}
+}
+
+func expectApplied(t *testing.T, database *Database, version int) {
+	statuses, err := database.Migrations(context.Background())
+	if err != nil {
+		t.Fatalf("Migrations should not fail: %s", err)
+	}
+
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= version) {
+			t.Errorf("Migration %d should be applied: %t", status.Version, !status.Applied)
+		}
+	}
+}
@@ -3,14 +3,13 @@ package mongo
 import (
 	"context"
 	"fmt"
+	"strings"
 
-	"github.com/kukinsula/boxy/entity"
 	"github.com/kukinsula/boxy/entity/log"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/x/bsonx"
 )
 
 type model struct {
@@ -19,68 +18,17 @@ type model struct {
 }
 
 type modelParams struct {
-	Context  context.Context
 	Database *Database
 	Name     string
 	Logger   log.Logger
 	Indexes  []indexParams
 }
 
-type indexParams struct {
-	Name       string
-	Unique     bool
-	Value      int32
-	Background bool
-	Sparse     bool
-}
-
-func newModel(params modelParams) (*model, error) {
-	model := &model{
+func newModel(params modelParams) *model {
+	return &model{
 		collection: params.Database.database.Collection(params.Name),
 		params:     params,
 	}
-
-	uuid := entity.NewUUID()
-
-	for _, index := range params.Indexes {
-		err := model.createindex(uuid, params.Context, mongo.IndexModel{
-			Keys: bsonx.Doc{{Key: index.Name, Value: bsonx.Int32(index.Value)}},
-			Options: options.Index().
-				SetName(index.Name).
-				SetUnique(index.Unique).
-				SetBackground(index.Background).
-				SetSparse(index.Sparse).
-				SetStorageEngine(bsonx.Doc{{
-					"wiredTiger", bsonx.Document(bsonx.Doc{{
-						"configString", bsonx.String("block_compressor=zlib"),
-					}})},
-				}),
-		})
-
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return model, nil
-}
-
-func (model *model) createindex(
-	uuid string,
-	ctx context.Context,
-	index mongo.IndexModel,
-	opts ...*options.CreateIndexesOptions) error {
-
-	_, err := model.collection.Indexes().CreateOne(ctx, index, opts...)
-
-	model.params.Logger(uuid, log.DEBUG,
-		fmt.Sprintf("%s.EnsureIndex", model.params.Name),
-		map[string]interface{}{
-			"name":   *index.Options.Name,
-			"unique": *index.Options.Unique,
-		})
-
-	return err
 }
 
 func (model *model) InsertOne(uuid string, ctx context.Context, data interface{}) error {
@@ -117,6 +65,28 @@ func (model *model) FindOne(
 	return err
 }
 
+func (model *model) Find(
+	uuid string,
+	ctx context.Context,
+	conditions map[string]interface{},
+	results interface{},
+	opts ...*options.FindOptions) error {
+
+	cursor, err := model.collection.Find(ctx, conditions, opts...)
+	if err == nil {
+		err = cursor.All(ctx, results)
+	}
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.Find", model.params.Name),
+		map[string]interface{}{
+			"conditions": conditions,
+			"error":      err,
+		})
+
+	return err
+}
+
 func (model *model) UpdateOne(
 	uuid string,
 	ctx context.Context,
@@ -144,6 +114,29 @@ func (model *model) UpdateOne(
 	return nil
 }
 
+func (model *model) ReplaceOne(
+	uuid string,
+	ctx context.Context,
+	conditions map[string]interface{},
+	replacement interface{},
+	result interface{},
+	opts ...*options.FindOneAndReplaceOptions) error {
+
+	err := model.collection.
+		FindOneAndReplace(ctx, conditions, replacement, opts...).
+		Decode(result)
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.ReplaceOne", model.params.Name),
+		map[string]interface{}{
+			"conditions":  conditions,
+			"replacement": replacement,
+			"error":       err,
+		})
+
+	return err
+}
+
 func (model *model) DeleteOne(
 	uuid string,
 	ctx context.Context,
@@ -192,3 +185,38 @@ func (model *model) RemoveAllDocuments(uuid string, ctx context.Context) (*mongo
 
 	return result, nil
 }
+
+const (
+	indexNotFoundCode      = 27
+	duplicateKeyCode       = 11000
+	duplicateKeyUpdateCode = 11001
+)
+
+// isDuplicateKeyError tells whether err is a conflict on the unique index
+// named index.
+func isDuplicateKeyError(err error, index string) bool {
+	duplicate := func(code int, message string) bool {
+		return (code == duplicateKeyCode || code == duplicateKeyUpdateCode) &&
+			strings.Contains(message, fmt.Sprintf("index: %s ", index))
+	}
+
+	switch err := err.(type) {
+	case mongo.WriteException:
+		for _, writeErr := range err.WriteErrors {
+			if duplicate(writeErr.Code, writeErr.Message) {
+				return true
+			}
+		}
+
+	case mongo.CommandError:
+		return duplicate(int(err.Code), err.Message)
+	}
+
+	return false
+}
+
+func isIndexNotFoundError(err error) bool {
+	command, ok := err.(mongo.CommandError)
+
+	return ok && command.Code == indexNotFoundCode
+}
@@ -14,6 +14,7 @@ type Database struct {
 	client   *mongo.Client
 	database *mongo.Database
 	User     *UserModel
+	Session  *SessionModel
 	params   NewDatabaseParams
 }
 
@@ -46,17 +47,33 @@ func NewDatabase(params NewDatabaseParams) (*Database, error) {
 	}, nil
 }
 
+// Init checks that the server supports transactions, applies the pending
+// migrations and creates the models.
 func (database *Database) Init(ctx context.Context) error {
-	user, err := NewUserModel(ctx, database, database.params.Logger)
+	err := database.checkTransactions(ctx)
 	if err != nil {
 		return err
 	}
 
-	database.User = user
+	err = database.waitMigrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	database.User = NewUserModel(database, database.params.Logger)
+	database.Session = NewSessionModel(database, database.params.Logger)
 
 	return nil
 }
 
+// models returns every model, with the indexes they declare.
+func (database *Database) models() []*model {
+	return []*model{
+		NewUserModel(database, database.params.Logger).model,
+		NewSessionModel(database, database.params.Logger).model,
+	}
+}
+
 func (database *Database) Drop(ctx context.Context) error {
 	return database.database.Drop(ctx)
 }
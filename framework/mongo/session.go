@@ -0,0 +1,182 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/kukinsula/boxy/entity/log"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SessionModel struct {
+	*model
+}
+
+var sessionIndexes = []indexParams{
+	indexParams{
+		Name:       "uuid",
+		Keys:       []indexKey{{Field: "uuid", Value: 1}},
+		Unique:     true,
+		Background: true,
+	},
+
+	indexParams{
+		Name:       "userUUID_createdAt",
+		Keys:       []indexKey{{Field: "userUUID", Value: 1}, {Field: "createdAt", Value: 1}},
+		Background: true,
+	},
+
+	indexParams{
+		Name:       "accessToken",
+		Keys:       []indexKey{{Field: "accessToken", Value: 1}},
+		Unique:     true,
+		Background: true,
+	},
+
+	indexParams{
+		Name:       "refreshToken",
+		Keys:       []indexKey{{Field: "refreshToken", Value: 1}},
+		Unique:     true,
+		Background: true,
+	},
+
+	indexParams{
+		Name:       "previousRefreshToken",
+		Keys:       []indexKey{{Field: "previousRefreshToken", Value: 1}},
+		Background: true,
+		Sparse:     true,
+	},
+
+	// Sessions expire with their refresh token
+	indexParams{
+		Name:        "lastUsedAt",
+		Keys:        []indexKey{{Field: "lastUsedAt", Value: 1}},
+		Background:  true,
+		ExpireAfter: loginUsecase.REFRESH_TOKEN_EXPIRES_IN,
+	},
+}
+
+func NewSessionModel(database *Database, logger log.Logger) *SessionModel {
+	return &SessionModel{model: newModel(modelParams{
+		Database: database,
+		Name:     "sessions",
+		Logger:   logger,
+		Indexes:  sessionIndexes,
+	})}
+}
+
+func (model *SessionModel) CreateSession(
+	uuid string,
+	ctx context.Context,
+	session *loginEntity.Session) (*loginEntity.Session, error) {
+
+	err := model.InsertOne(uuid, ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (model *SessionModel) FindSessionByAccessToken(
+	uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	return model.findOne(uuid, ctx, map[string]interface{}{"accessToken": token})
+}
+
+func (model *SessionModel) FindSessionByRefreshToken(
+	uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	return model.findOne(uuid, ctx, map[string]interface{}{
+		"$or": bson.A{
+			bson.M{"refreshToken": token},
+			bson.M{"previousRefreshToken": token},
+		},
+	})
+}
+
+func (model *SessionModel) FindSessions(
+	uuid string,
+	ctx context.Context,
+	userUUID string) ([]*loginEntity.Session, error) {
+
+	sessions := []*loginEntity.Session{}
+
+	err := model.Find(uuid, ctx,
+		map[string]interface{}{"userUUID": userUUID},
+		&sessions,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (model *SessionModel) UpdateSession(
+	uuid string,
+	ctx context.Context,
+	session *loginEntity.Session) (*loginEntity.Session, error) {
+
+	updated := &loginEntity.Session{}
+
+	err := model.ReplaceOne(uuid, ctx,
+		map[string]interface{}{"uuid": session.UUID},
+		session,
+		updated,
+		options.FindOneAndReplace().SetReturnDocument(options.After))
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (model *SessionModel) DeleteSession(
+	uuid string,
+	ctx context.Context,
+	userUUID, sessionUUID string) (bool, error) {
+
+	result, err := model.DeleteMany(uuid, ctx,
+		map[string]interface{}{"uuid": sessionUUID, "userUUID": userUUID})
+
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount != 0, nil
+}
+
+// findOne returns the Session matching conditions, nil if none does.
+func (model *SessionModel) findOne(
+	uuid string,
+	ctx context.Context,
+	conditions map[string]interface{}) (*loginEntity.Session, error) {
+
+	session := &loginEntity.Session{}
+
+	err := model.FindOne(uuid, ctx, conditions, nil, session)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
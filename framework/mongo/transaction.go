@@ -0,0 +1,117 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ReplicaSetRequiredErr = errors.New("Transactions need a replica set or a sharded cluster")
+
+const (
+	transactionAttempts = 5
+
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+type transactionKey struct{}
+
+// Transaction is a unit of work: it runs operation in a transaction of a new
+// session, committed if operation succeeds and aborted otherwise. Operations
+// must use the context they are given. The whole transaction is retried on
+// transient errors, such as write conflicts, and its commit when its result is
+// unknown. Transactions need a replica set, which Init checks.
+func (database *Database) Transaction(
+	uuid string,
+	ctx context.Context,
+	operation func(ctx context.Context) error) error {
+
+	// Nested transactions join the current one
+	if ctx.Value(transactionKey{}) != nil {
+		return operation(ctx)
+	}
+
+	session, err := database.client.StartSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.EndSession(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err = mongo.WithSession(ctx, session, func(sessionCtx mongo.SessionContext) error {
+			return database.transaction(sessionCtx, session, operation)
+		})
+
+		if err == nil || attempt == transactionAttempts ||
+			!hasErrorLabel(err, transientTransactionError) {
+
+			return err
+		}
+
+		database.params.Logger(uuid, log.WARN, "Transaction.Retry",
+			map[string]interface{}{"attempt": attempt, "error": err})
+	}
+}
+
+func (database *Database) transaction(
+	ctx mongo.SessionContext,
+	session mongo.Session,
+	operation func(ctx context.Context) error) error {
+
+	err := session.StartTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = operation(context.WithValue(ctx, transactionKey{}, true))
+	if err != nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = session.CommitTransaction(ctx)
+
+		if err == nil || attempt == transactionAttempts ||
+			!hasErrorLabel(err, unknownTransactionCommitResult) {
+
+			return err
+		}
+	}
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var labeled interface {
+		HasErrorLabel(label string) bool
+	}
+
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// checkTransactions fails with ReplicaSetRequiredErr if the server is a
+// standalone one, whose transactions would fail at the first write.
+func (database *Database) checkTransactions(ctx context.Context) error {
+	var topology struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := database.database.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&topology)
+	if err != nil {
+		return err
+	}
+
+	// mongos answers isdbgrid
+	if topology.SetName == "" && topology.Msg != "isdbgrid" {
+		return fmt.Errorf("Server %s is standalone: %w", database.params.URI, ReplicaSetRequiredErr)
+	}
+
+	return nil
+}
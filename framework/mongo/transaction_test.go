@@ -0,0 +1,63 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+)
+
+func TestInitStandalone(t *testing.T) {
+	uri := os.Getenv("BOXY_MONGO_STANDALONE_URI")
+	if uri == "" {
+		t.Skip("BOXY_MONGO_STANDALONE_URI is not set")
+	}
+
+	ctx := context.Background()
+
+	database, err := NewDatabase(NewDatabaseParams{
+		Context:  ctx,
+		URI:      uri,
+		Database: "boxy_test",
+		Timeout:  5 * time.Second,
+		Logger:   log.NoOpLogger,
+	})
+
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %s", err)
+	}
+
+	defer database.Drop(ctx)
+
+	// Fails before migrating anything
+	err = database.Init(ctx)
+	if !errors.Is(err, ReplicaSetRequiredErr) {
+		t.Errorf("Init should fail with %s, got %v", ReplicaSetRequiredErr, err)
+	}
+
+	statuses, err := database.Migrations(ctx)
+	if err != nil {
+		t.Fatalf("Migrations should not fail: %s", err)
+	}
+
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("Migration %d should not be applied", status.Version)
+		}
+	}
+}
+
+func TestInitReplicaSet(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+
+	defer database.Drop(ctx)
+
+	err := database.checkTransactions(ctx)
+	if err != nil {
+		t.Errorf("checkTransactions should not fail on a replica set: %s", err)
+	}
+}
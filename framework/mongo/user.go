@@ -2,64 +2,51 @@ package mongo
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/kukinsula/boxy/entity/log"
 	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// TODO
-//
-// * transactions
-
 type UserModel struct {
 	*model
 }
 
-func NewUserModel(
-	ctx context.Context,
-	database *Database,
-	logger log.Logger) (*UserModel, error) {
+var userIndexes = []indexParams{
+	indexParams{
+		Name:       "email",
+		Keys:       []indexKey{{Field: "email", Value: 1}},
+		Unique:     true,
+		Background: true,
+	},
+
+	indexParams{
+		Name:       "uuid",
+		Keys:       []indexKey{{Field: "uuid", Value: 1}},
+		Unique:     true,
+		Background: true,
+	},
+}
 
-	model, err := newModel(modelParams{
-		Context:  ctx,
+func NewUserModel(database *Database, logger log.Logger) *UserModel {
+	return &UserModel{model: newModel(modelParams{
 		Database: database,
 		Name:     "users",
 		Logger:   logger,
+		Indexes:  userIndexes,
+	})}
+}
 
-		Indexes: []indexParams{
-			indexParams{
-				Name:       "email",
-				Value:      1,
-				Unique:     true,
-				Background: true,
-				Sparse:     false,
-			},
-
-			indexParams{
-				Name:       "uuid",
-				Value:      1,
-				Unique:     true,
-				Background: true,
-				Sparse:     false,
-			},
-
-			indexParams{
-				Name:       "accessToken",
-				Value:      1,
-				Unique:     true,
-				Background: true,
-				Sparse:     true,
-			},
-		},
-	})
-
-	if err != nil {
-		return nil, err
-	}
+func (model *UserModel) Transaction(
+	uuid string,
+	ctx context.Context,
+	operation func(ctx context.Context) error) error {
 
-	return &UserModel{model: model}, nil
+	return model.params.Database.Transaction(uuid, ctx, operation)
 }
 
 func (model *UserModel) Create(
@@ -67,14 +54,12 @@ func (model *UserModel) Create(
 	ctx context.Context,
 	user *loginEntity.User) (*loginEntity.User, error) {
 
-	err := model.InsertOne(uuid, ctx, bson.M{
-		"email":           user.Email,
-		"uuid":            user.UUID,
-		"firstName":       user.FirstName,
-		"lastName":        user.LastName,
-		"password":        user.Password,
-		"activationToken": user.ActivationToken,
-	})
+	err := model.InsertOne(uuid, ctx, user)
+
+	if isDuplicateKeyError(err, "email") {
+		return nil, fmt.Errorf("UserModel.Create failed: email %s: %w",
+			user.Email, loginUsecase.EmailTakenErr)
+	}
 
 	if err != nil {
 		return nil, err
@@ -87,74 +72,67 @@ func (model *UserModel) FindByEmailAndActivationToken(
 	uuid string,
 	ctx context.Context,
 	email, token string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
 
-	user := &loginEntity.User{}
-
-	err := model.FindOne(uuid, ctx,
+	return model.findOne(uuid, ctx,
 		map[string]interface{}{"email": email, "activationToken": token},
-		projection,
-		user)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return user, nil
+		projection)
 }
 
 func (model *UserModel) FindByEmailAndInitializationToken(
 	uuid string,
 	ctx context.Context,
 	email, token string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
-
-	user := &loginEntity.User{}
-
-	err := model.FindOne(uuid, ctx,
-		map[string]interface{}{"email": email, "initializationtoken": token},
-		projection,
-		user)
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
 
-	if err != nil {
-		return nil, err
-	}
-
-	return user, nil
+	return model.findOne(uuid, ctx,
+		map[string]interface{}{"email": email, "initializationToken": token},
+		projection)
 }
 
 func (model *UserModel) FindByEmail(
 	uuid string,
 	ctx context.Context,
 	email string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
-
-	user := &loginEntity.User{}
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
 
-	err := model.FindOne(uuid, ctx,
+	return model.findOne(uuid, ctx,
 		map[string]interface{}{"email": email},
-		projection,
-		user)
+		projection)
+}
 
-	if err != nil {
-		return nil, err
-	}
+func (model *UserModel) FindByUUID(
+	uuid string,
+	ctx context.Context,
+	userUUID string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
 
-	return user, nil
+	return model.findOne(uuid, ctx,
+		map[string]interface{}{"uuid": userUUID},
+		projection)
 }
 
-func (model *UserModel) FindByAccessToken(
+func (model *UserModel) Update(
 	uuid string,
 	ctx context.Context,
-	token string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
+	userUUID string,
+	patch *loginUsecase.UserPatch) (*loginEntity.User, error) {
+
+	conditions := map[string]interface{}{"uuid": userUUID}
+
+	update := userUpdate(patch)
+	if len(update) == 0 {
+		return model.findOne(uuid, ctx, conditions, loginEntity.UserFullProjection)
+	}
 
 	user := &loginEntity.User{}
 
-	err := model.FindOne(uuid, ctx,
-		map[string]interface{}{"accessToken": token},
-		projection,
-		user)
+	err := model.UpdateOne(uuid, ctx, conditions, update, user,
+		options.FindOneAndUpdate().SetReturnDocument(options.After))
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
 
 	if err != nil {
 		return nil, err
@@ -163,18 +141,57 @@ func (model *UserModel) FindByAccessToken(
 	return user, nil
 }
 
-func (model *UserModel) Update(
+// findOne returns the User matching conditions, nil if none does.
+func (model *UserModel) findOne(
 	uuid string,
 	ctx context.Context,
 	conditions map[string]interface{},
-	update map[string]interface{}) (*loginEntity.User, error) {
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
 
 	user := &loginEntity.User{}
 
-	err := model.UpdateOne(uuid, ctx, conditions, update, user)
+	err := model.FindOne(uuid, ctx, conditions, userProjection(projection), user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
+
+// userUpdate translates patch into $set and $unset operators.
+func userUpdate(patch *loginUsecase.UserPatch) map[string]interface{} {
+	update := map[string]interface{}{}
+
+	if len(patch.Sets()) != 0 {
+		set := map[string]interface{}{}
+		for field, value := range patch.Sets() {
+			set[string(field)] = value
+		}
+
+		update["$set"] = set
+	}
+
+	if len(patch.Unsets()) != 0 {
+		unset := map[string]interface{}{}
+		for _, field := range patch.Unsets() {
+			unset[string(field)] = 1
+		}
+
+		update["$unset"] = unset
+	}
+
+	return update
+}
+
+func userProjection(projection loginEntity.UserProjection) map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, field := range projection {
+		result[string(field)] = 1
+	}
+
+	return result
+}
@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+	"github.com/kukinsula/boxy/usecase/login/logintest"
+)
+
+// newTestDatabase connects to the empty MongoDB database boxy_test at
+// BOXY_MONGO_URI, a replica set, skipping the test when unset.
+func newTestDatabase(t *testing.T) *Database {
+	uri := os.Getenv("BOXY_MONGO_URI")
+	if uri == "" {
+		t.Skip("BOXY_MONGO_URI is not set")
+	}
+
+	ctx := context.Background()
+
+	database, err := NewDatabase(NewDatabaseParams{
+		Context:  ctx,
+		URI:      uri,
+		Database: "boxy_test",
+		Timeout:  5 * time.Second,
+		Logger:   log.NoOpLogger,
+	})
+
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %s", err)
+	}
+
+	err = database.Drop(ctx)
+	if err != nil {
+		t.Fatalf("Drop failed: %s", err)
+	}
+
+	return database
+}
+
+func TestUserModel(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+
+	defer database.Drop(ctx)
+
+	logintest.LoginGatewayContract(t, func(t *testing.T) loginUsecase.LoginGateway {
+		err := database.Drop(ctx)
+		if err != nil {
+			t.Fatalf("Drop failed: %s", err)
+		}
+
+		err = database.Init(ctx)
+		if err != nil {
+			t.Fatalf("Init failed: %s", err)
+		}
+
+		return database.User
+	})
+}
+
+func TestSessionModel(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+
+	defer database.Drop(ctx)
+
+	logintest.SessionGatewayContract(t,
+		func(t *testing.T) (loginUsecase.LoginGateway, loginUsecase.SessionGateway) {
+			err := database.Drop(ctx)
+			if err != nil {
+				t.Fatalf("Drop failed: %s", err)
+			}
+
+			err = database.Init(ctx)
+			if err != nil {
+				t.Fatalf("Init failed: %s", err)
+			}
+
+			return database.User, database.Session
+		})
+}
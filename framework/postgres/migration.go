@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/log"
+)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+// migrations are applied in order, once. Never edit an applied migration,
+// append a new one.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create users",
+		Up: `CREATE TABLE users (
+			uuid                 TEXT    NOT NULL,
+			email                TEXT    NOT NULL,
+			first_name           TEXT    NOT NULL DEFAULT '',
+			last_name            TEXT    NOT NULL DEFAULT '',
+			password             TEXT    NOT NULL DEFAULT '',
+			state                INTEGER NOT NULL DEFAULT 0,
+			activation_token     TEXT,
+			initialization_token TEXT,
+
+			CONSTRAINT users_pkey PRIMARY KEY (uuid),
+			CONSTRAINT users_email_unique UNIQUE (email)
+		)`,
+	},
+
+	{
+		// Refresh tokens are stored as SHA-256 digests, sessions expire with
+		// them
+		Version: 2,
+		Name:    "create sessions",
+		Up: `CREATE TABLE sessions (
+			uuid                          TEXT        NOT NULL,
+			user_uuid                     TEXT        NOT NULL,
+			device                        TEXT        NOT NULL DEFAULT '',
+			ip                            TEXT        NOT NULL DEFAULT '',
+			access_token                  TEXT        NOT NULL,
+			refresh_token_digest          TEXT        NOT NULL,
+			previous_refresh_token_digest TEXT,
+			created_at                    TIMESTAMPTZ NOT NULL,
+			last_used_at                  TIMESTAMPTZ NOT NULL,
+
+			CONSTRAINT sessions_pkey PRIMARY KEY (uuid),
+			CONSTRAINT sessions_access_token_unique UNIQUE (access_token),
+			CONSTRAINT sessions_refresh_token_digest_unique UNIQUE (refresh_token_digest)
+		);
+
+		CREATE INDEX sessions_user_uuid_created_at ON sessions (user_uuid, created_at);
+		CREATE INDEX sessions_previous_refresh_token_digest
+			ON sessions (previous_refresh_token_digest);
+		CREATE INDEX sessions_last_used_at ON sessions (last_used_at)`,
+	},
+}
+
+// migrate applies the migrations missing from schema_migrations, each in its
+// own transaction. The table lock serializes concurrent programs.
+func (database *Database) migrate(ctx context.Context) error {
+	_, err := database.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER     PRIMARY KEY,
+		name       TEXT        NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+
+	if err != nil {
+		return err
+	}
+
+	uuid := entity.NewUUID()
+
+	for _, migration := range migrations {
+		applied, err := database.apply(ctx, migration)
+		if err != nil {
+			return fmt.Errorf("Migration %d (%s) failed: %w",
+				migration.Version, migration.Name, err)
+		}
+
+		if applied {
+			database.params.Logger(uuid, log.INFO, "Postgres.Migrate",
+				map[string]interface{}{
+					"version": migration.Version,
+					"name":    migration.Name,
+				})
+		}
+	}
+
+	return nil
+}
+
+func (database *Database) apply(ctx context.Context, migration migration) (bool, error) {
+	tx, err := database.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "LOCK TABLE schema_migrations IN EXCLUSIVE MODE")
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)",
+		migration.Version).Scan(&exists)
+
+	if err != nil || exists {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, migration.Up)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+		migration.Version, migration.Name)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
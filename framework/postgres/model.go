@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/lib/pq"
+)
+
+type model struct {
+	database *Database
+	params   modelParams
+}
+
+type modelParams struct {
+	Database *Database
+	Table    string
+	Logger   log.Logger
+}
+
+func newModel(params modelParams) *model {
+	return &model{
+		database: params.Database,
+		params:   params,
+	}
+}
+
+// QueryRow runs query and scans its single row into dest.
+func (model *model) QueryRow(
+	uuid string,
+	ctx context.Context,
+	operation, query string,
+	args []interface{},
+	dest ...interface{}) error {
+
+	err := model.database.queryer(ctx).QueryRowContext(ctx, query, args...).Scan(dest...)
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.%s", model.params.Table, operation),
+		map[string]interface{}{"query": query, "error": err})
+
+	return err
+}
+
+// Query runs query and calls scan for each of its rows.
+func (model *model) Query(
+	uuid string,
+	ctx context.Context,
+	operation, query string,
+	args []interface{},
+	scan func(rows *sql.Rows) error) error {
+
+	err := model.query(ctx, query, args, scan)
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.%s", model.params.Table, operation),
+		map[string]interface{}{"query": query, "error": err})
+
+	return err
+}
+
+func (model *model) query(
+	ctx context.Context,
+	query string,
+	args []interface{},
+	scan func(rows *sql.Rows) error) error {
+
+	rows, err := model.database.queryer(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (model *model) Exec(
+	uuid string,
+	ctx context.Context,
+	operation, query string,
+	args ...interface{}) error {
+
+	_, err := model.database.queryer(ctx).ExecContext(ctx, query, args...)
+
+	model.params.Logger(uuid, log.DEBUG,
+		fmt.Sprintf("%s.%s", model.params.Table, operation),
+		map[string]interface{}{"query": query, "error": err})
+
+	return err
+}
+
+// isUniqueViolation tells whether err is a unique_violation of the constraint
+// named constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var failure *pq.Error
+
+	return errors.As(err, &failure) &&
+		failure.Code == "23505" && failure.Constraint == constraint
+}
+
+// placeholders returns the numbered parameters $from to $from+count-1.
+func placeholders(from, count int) []string {
+	result := make([]string, count)
+	for index := range result {
+		result[index] = fmt.Sprintf("$%d", from+index)
+	}
+
+	return result
+}
@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	violation := &pq.Error{Code: "23505", Constraint: "users_email_unique"}
+
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{violation, true},
+		{fmt.Errorf("Create failed: %w", violation), true},
+		{&pq.Error{Code: "23505", Constraint: "users_pkey"}, false},
+		{&pq.Error{Code: "23503", Constraint: "users_email_unique"}, false},
+		{errors.New(`duplicate key value violates unique constraint "users_email_unique"`), false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if isUniqueViolation(test.err, "users_email_unique") != test.expected {
+			t.Errorf("isUniqueViolation(%v) should be %v", test.err, test.expected)
+		}
+	}
+}
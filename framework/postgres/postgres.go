@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+)
+
+// Database is a PostgreSQL database reached through database/sql with the
+// github.com/lib/pq driver, whose errors it reads, registered as "postgres".
+type Database struct {
+	db      *sql.DB
+	User    *UserModel
+	Session *SessionModel
+	params  NewDatabaseParams
+}
+
+type NewDatabaseParams struct {
+	Context context.Context
+	Driver  string
+	URI     string
+	Timeout time.Duration
+	Logger  log.Logger
+}
+
+func NewDatabase(params NewDatabaseParams) (*Database, error) {
+	db, err := sql.Open(params.Driver, params.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(params.Context, params.Timeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Database{
+		db:     db,
+		params: params,
+	}, nil
+}
+
+// Init applies the pending migrations and creates the models.
+func (database *Database) Init(ctx context.Context) error {
+	err := database.migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	database.User = NewUserModel(database, database.params.Logger)
+	database.Session = NewSessionModel(database, database.params.Logger)
+
+	return nil
+}
+
+// Drop removes every table, migrations included.
+func (database *Database) Drop(ctx context.Context) error {
+	_, err := database.db.ExecContext(ctx,
+		"DROP TABLE IF EXISTS users, sessions, schema_migrations")
+
+	return err
+}
+
+func (database *Database) Close() error {
+	return database.db.Close()
+}
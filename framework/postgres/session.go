@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+)
+
+// sessionColumns are the columns of sessions, in the order of sessionValues
+// and sessionTargets.
+const sessionColumns = `uuid, user_uuid, device, ip, access_token, refresh_token_digest,
+	COALESCE(previous_refresh_token_digest, ''), created_at, last_used_at`
+
+type SessionModel struct {
+	*model
+}
+
+func NewSessionModel(database *Database, logger log.Logger) *SessionModel {
+	return &SessionModel{model: newModel(modelParams{
+		Database: database,
+		Table:    "sessions",
+		Logger:   logger,
+	})}
+}
+
+func (model *SessionModel) CreateSession(
+	uuid string,
+	ctx context.Context,
+	session *loginEntity.Session) (*loginEntity.Session, error) {
+
+	err := model.Exec(uuid, ctx, "Insert",
+		`INSERT INTO sessions (uuid, user_uuid, device, ip, access_token, refresh_token_digest,
+			previous_refresh_token_digest, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		sessionValues(session)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (model *SessionModel) FindSessionByAccessToken(
+	uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	return model.findOne(uuid, ctx, "access_token = $1", token)
+}
+
+func (model *SessionModel) FindSessionByRefreshToken(
+	uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	return model.findOne(uuid, ctx,
+		"refresh_token_digest = $1 OR previous_refresh_token_digest = $1", token)
+}
+
+func (model *SessionModel) FindSessions(
+	uuid string,
+	ctx context.Context,
+	userUUID string) ([]*loginEntity.Session, error) {
+
+	sessions := []*loginEntity.Session{}
+
+	err := model.Query(uuid, ctx, "Select",
+		fmt.Sprintf(`SELECT %s FROM sessions WHERE user_uuid = $1 AND last_used_at >= $2
+			ORDER BY created_at`, sessionColumns),
+		[]interface{}{userUUID, expiredBefore()},
+		func(rows *sql.Rows) error {
+			session := &loginEntity.Session{}
+
+			err := rows.Scan(sessionTargets(session)...)
+			if err != nil {
+				return err
+			}
+
+			sessions = append(sessions, session)
+
+			return nil
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (model *SessionModel) UpdateSession(
+	uuid string,
+	ctx context.Context,
+	session *loginEntity.Session) (*loginEntity.Session, error) {
+
+	updated := &loginEntity.Session{}
+
+	err := model.QueryRow(uuid, ctx, "Update",
+		fmt.Sprintf(`UPDATE sessions SET user_uuid = $2, device = $3, ip = $4,
+			access_token = $5, refresh_token_digest = $6, previous_refresh_token_digest = $7,
+			created_at = $8, last_used_at = $9
+		WHERE uuid = $1 RETURNING %s`, sessionColumns),
+		sessionValues(session),
+		sessionTargets(updated)...)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (model *SessionModel) DeleteSession(
+	uuid string,
+	ctx context.Context,
+	userUUID, sessionUUID string) (bool, error) {
+
+	var deleted string
+
+	err := model.QueryRow(uuid, ctx, "Delete",
+		"DELETE FROM sessions WHERE uuid = $1 AND user_uuid = $2 RETURNING uuid",
+		[]interface{}{sessionUUID, userUUID},
+		&deleted)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteExpired removes the Sessions whose refresh token expired, as the
+// MongoDB TTL index does. The finders already ignore them, it runs
+// periodically rather than on every write not to conflict with them.
+func (model *SessionModel) DeleteExpired(uuid string, ctx context.Context) error {
+	return model.Exec(uuid, ctx, "DeleteExpired",
+		"DELETE FROM sessions WHERE last_used_at < $1",
+		expiredBefore())
+}
+
+// findOne returns the unexpired Session matching where, nil if none does.
+func (model *SessionModel) findOne(
+	uuid string,
+	ctx context.Context,
+	where string,
+	args ...interface{}) (*loginEntity.Session, error) {
+
+	session := &loginEntity.Session{}
+
+	err := model.QueryRow(uuid, ctx, "Select",
+		fmt.Sprintf("SELECT %s FROM sessions WHERE (%s) AND last_used_at >= $%d",
+			sessionColumns, where, len(args)+1),
+		append(args, expiredBefore()),
+		sessionTargets(session)...)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// expiredBefore is the last use of the Sessions expiring now.
+func expiredBefore() time.Time {
+	return time.Now().Add(-loginUsecase.REFRESH_TOKEN_EXPIRES_IN)
+}
+
+// sessionValues returns the values of the columns of session, an empty
+// previous refresh token being NULL.
+func sessionValues(session *loginEntity.Session) []interface{} {
+	var previousRefreshToken interface{}
+	if session.PreviousRefreshToken != "" {
+		previousRefreshToken = session.PreviousRefreshToken
+	}
+
+	return []interface{}{
+		session.UUID,
+		session.UserUUID,
+		session.Device,
+		session.IP,
+		session.AccessToken,
+		session.RefreshToken,
+		previousRefreshToken,
+		session.CreatedAt,
+		session.LastUsedAt,
+	}
+}
+
+func sessionTargets(session *loginEntity.Session) []interface{} {
+	return []interface{}{
+		&session.UUID,
+		&session.UserUUID,
+		&session.Device,
+		&session.IP,
+		&session.AccessToken,
+		&session.RefreshToken,
+		&session.PreviousRefreshToken,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+	}
+}
@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/lib/pq"
+)
+
+const transactionAttempts = 5
+
+type transactionKey struct{}
+
+// queryer runs statements, in a transaction or not.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Transaction runs operation in a serializable transaction, committed if
+// operation succeeds and rolled back otherwise. Operations must use the
+// context they are given. The transaction is retried on serialization
+// failures and deadlocks.
+func (database *Database) Transaction(
+	uuid string,
+	ctx context.Context,
+	operation func(ctx context.Context) error) error {
+
+	// Nested transactions join the current one
+	if ctx.Value(transactionKey{}) != nil {
+		return operation(ctx)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := database.transaction(ctx, operation)
+		if err == nil || attempt == transactionAttempts || !isSerializationFailure(err) {
+			return err
+		}
+
+		database.params.Logger(uuid, log.WARN, "Transaction.Retry",
+			map[string]interface{}{"attempt": attempt, "error": err})
+	}
+}
+
+func (database *Database) transaction(
+	ctx context.Context,
+	operation func(ctx context.Context) error) error {
+
+	tx, err := database.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = operation(context.WithValue(ctx, transactionKey{}, tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// queryer returns the transaction of ctx, the database if it has none.
+func (database *Database) queryer(ctx context.Context) queryer {
+	tx, ok := ctx.Value(transactionKey{}).(*sql.Tx)
+	if ok {
+		return tx
+	}
+
+	return database.db
+}
+
+// isSerializationFailure tells whether err is a serialization_failure or a
+// deadlock_detected.
+func isSerializationFailure(err error) bool {
+	var failure *pq.Error
+
+	if !errors.As(err, &failure) {
+		return false
+	}
+
+	return failure.Code == "40001" || failure.Code == "40P01"
+}
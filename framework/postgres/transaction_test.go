@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{fmt.Errorf("Commit failed: %w", &pq.Error{Code: "40001"}), true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("could not serialize access due to concurrent update"), false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if isSerializationFailure(test.err) != test.expected {
+			t.Errorf("isSerializationFailure(%v) should be %v", test.err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/kukinsula/boxy/entity/log"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+)
+
+// userColumn maps a User field to its column. Tokens are NULL rather than
+// empty so that their unique constraint ignores Users without one.
+type userColumn struct {
+	field    loginEntity.UserField
+	name     string
+	nullable bool
+	target   func(user *loginEntity.User) interface{}
+}
+
+var userColumns = []userColumn{
+	{loginEntity.USER_UUID, "uuid", false,
+		func(user *loginEntity.User) interface{} { return &user.UUID }},
+	{loginEntity.USER_EMAIL, "email", false,
+		func(user *loginEntity.User) interface{} { return &user.Email }},
+	{loginEntity.USER_FIRST_NAME, "first_name", false,
+		func(user *loginEntity.User) interface{} { return &user.FirstName }},
+	{loginEntity.USER_LAST_NAME, "last_name", false,
+		func(user *loginEntity.User) interface{} { return &user.LastName }},
+	{loginEntity.USER_PASSWORD, "password", false,
+		func(user *loginEntity.User) interface{} { return &user.Password }},
+	{loginEntity.USER_STATE, "state", false,
+		func(user *loginEntity.User) interface{} { return &user.State }},
+	{loginEntity.USER_ACTIVATION_TOKEN, "activation_token", true,
+		func(user *loginEntity.User) interface{} { return &user.ActivationToken }},
+	{loginEntity.USER_INITIALIZATION_TOKEN, "initialization_token", true,
+		func(user *loginEntity.User) interface{} { return &user.InitializationToken }},
+}
+
+type UserModel struct {
+	*model
+}
+
+func NewUserModel(database *Database, logger log.Logger) *UserModel {
+	return &UserModel{model: newModel(modelParams{
+		Database: database,
+		Table:    "users",
+		Logger:   logger,
+	})}
+}
+
+func (model *UserModel) Transaction(
+	uuid string,
+	ctx context.Context,
+	operation func(ctx context.Context) error) error {
+
+	return model.database.Transaction(uuid, ctx, operation)
+}
+
+func (model *UserModel) Create(
+	uuid string,
+	ctx context.Context,
+	user *loginEntity.User) (*loginEntity.User, error) {
+
+	names := make([]string, len(userColumns))
+	values := make([]interface{}, len(userColumns))
+
+	for index, column := range userColumns {
+		names[index] = column.name
+		values[index] = column.value(column.target(user))
+	}
+
+	err := model.Exec(uuid, ctx, "Insert",
+		fmt.Sprintf("INSERT INTO users (%s) VALUES (%s)",
+			strings.Join(names, ", "),
+			strings.Join(placeholders(1, len(names)), ", ")),
+		values...)
+
+	if isUniqueViolation(err, "users_email_unique") {
+		return nil, fmt.Errorf("UserModel.Create failed: email %s: %w",
+			user.Email, loginUsecase.EmailTakenErr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (model *UserModel) FindByEmailAndActivationToken(
+	uuid string,
+	ctx context.Context,
+	email, token string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return model.findOne(uuid, ctx, projection,
+		"email = $1 AND activation_token = $2", email, token)
+}
+
+func (model *UserModel) FindByEmailAndInitializationToken(
+	uuid string,
+	ctx context.Context,
+	email, token string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return model.findOne(uuid, ctx, projection,
+		"email = $1 AND initialization_token = $2", email, token)
+}
+
+func (model *UserModel) FindByEmail(
+	uuid string,
+	ctx context.Context,
+	email string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return model.findOne(uuid, ctx, projection, "email = $1", email)
+}
+
+func (model *UserModel) FindByUUID(
+	uuid string,
+	ctx context.Context,
+	userUUID string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return model.findOne(uuid, ctx, projection, "uuid = $1", userUUID)
+}
+
+// Update translates patch into an UPDATE, cleared fields being reset to
+// their column default.
+func (model *UserModel) Update(
+	uuid string,
+	ctx context.Context,
+	userUUID string,
+	patch *loginUsecase.UserPatch) (*loginEntity.User, error) {
+
+	assignments := []string{}
+	values := []interface{}{}
+
+	for field, value := range patch.Sets() {
+		column, err := findUserColumn(field)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, column.value(value))
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column.name, len(values)))
+	}
+
+	for _, field := range patch.Unsets() {
+		column, err := findUserColumn(field)
+		if err != nil {
+			return nil, err
+		}
+
+		assignments = append(assignments, fmt.Sprintf("%s = DEFAULT", column.name))
+	}
+
+	if len(assignments) == 0 {
+		return model.findOne(uuid, ctx, nil, "uuid = $1", userUUID)
+	}
+
+	user := &loginEntity.User{}
+	columns := selectUserColumns(nil)
+	values = append(values, userUUID)
+
+	err := model.QueryRow(uuid, ctx, "Update",
+		fmt.Sprintf("UPDATE users SET %s WHERE uuid = $%d RETURNING %s",
+			strings.Join(assignments, ", "), len(values), selectExpressions(columns)),
+		values,
+		scanTargets(columns, user)...)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// findOne returns the User matching where, nil if none does.
+func (model *UserModel) findOne(
+	uuid string,
+	ctx context.Context,
+	projection loginEntity.UserProjection,
+	where string,
+	args ...interface{}) (*loginEntity.User, error) {
+
+	user := &loginEntity.User{}
+	columns := selectUserColumns(projection)
+
+	err := model.QueryRow(uuid, ctx, "Select",
+		fmt.Sprintf("SELECT %s FROM users WHERE %s", selectExpressions(columns), where),
+		args,
+		scanTargets(columns, user)...)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// value converts value to its column type, empty tokens becoming NULL.
+func (column userColumn) value(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *string:
+		return column.value(*value)
+
+	case *loginEntity.UserState:
+		return int64(*value)
+
+	case loginEntity.UserState:
+		return int64(value)
+
+	case string:
+		if column.nullable && value == "" {
+			return nil
+		}
+
+		return value
+	}
+
+	return value
+}
+
+func findUserColumn(field loginEntity.UserField) (userColumn, error) {
+	for _, column := range userColumns {
+		if column.field == field {
+			return column, nil
+		}
+	}
+
+	return userColumn{}, fmt.Errorf("UserModel: User has no field %s", field)
+}
+
+// selectUserColumns returns the columns of projection, every column if it is
+// empty.
+func selectUserColumns(projection loginEntity.UserProjection) []userColumn {
+	if len(projection) == 0 {
+		return userColumns
+	}
+
+	columns := []userColumn{}
+
+	for _, column := range userColumns {
+		for _, field := range projection {
+			if column.field == field {
+				columns = append(columns, column)
+				break
+			}
+		}
+	}
+
+	return columns
+}
+
+func selectExpressions(columns []userColumn) string {
+	expressions := make([]string, len(columns))
+
+	for index, column := range columns {
+		expressions[index] = column.name
+
+		if column.nullable {
+			expressions[index] = fmt.Sprintf("COALESCE(%s, '')", column.name)
+		}
+	}
+
+	return strings.Join(expressions, ", ")
+}
+
+func scanTargets(columns []userColumn, user *loginEntity.User) []interface{} {
+	targets := make([]interface{}, len(columns))
+	for index, column := range columns {
+		targets[index] = column.target(user)
+	}
+
+	return targets
+}
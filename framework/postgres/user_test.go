@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/log"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+	"github.com/kukinsula/boxy/usecase/login/logintest"
+
+	_ "github.com/lib/pq"
+)
+
+// newTestDatabase connects to the PostgreSQL database at BOXY_POSTGRES_URI,
+// skipping the test when unset.
+func newTestDatabase(t *testing.T) *Database {
+	uri := os.Getenv("BOXY_POSTGRES_URI")
+	if uri == "" {
+		t.Skip("BOXY_POSTGRES_URI is not set")
+	}
+
+	database, err := NewDatabase(NewDatabaseParams{
+		Context: context.Background(),
+		Driver:  "postgres",
+		URI:     uri,
+		Timeout: 5 * time.Second,
+		Logger:  log.NoOpLogger,
+	})
+
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %s", err)
+	}
+
+	return database
+}
+
+// reset drops then migrates database.
+func reset(t *testing.T, database *Database) {
+	ctx := context.Background()
+
+	err := database.Drop(ctx)
+	if err != nil {
+		t.Fatalf("Drop failed: %s", err)
+	}
+
+	err = database.Init(ctx)
+	if err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+}
+
+// TestUserModel runs the LoginGateway contract.
+func TestUserModel(t *testing.T) {
+	database := newTestDatabase(t)
+
+	defer database.Close()
+	defer database.Drop(context.Background())
+
+	logintest.LoginGatewayContract(t, func(t *testing.T) loginUsecase.LoginGateway {
+		reset(t, database)
+
+		return database.User
+	})
+}
+
+func TestSessionModel(t *testing.T) {
+	database := newTestDatabase(t)
+
+	defer database.Close()
+	defer database.Drop(context.Background())
+
+	logintest.SessionGatewayContract(t,
+		func(t *testing.T) (loginUsecase.LoginGateway, loginUsecase.SessionGateway) {
+			reset(t, database)
+
+			return database.User, database.Session
+		})
+}
+
+func TestSessionExpiry(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+
+	defer database.Close()
+	defer database.Drop(ctx)
+
+	reset(t, database)
+
+	newSession := func(lastUsedAt time.Time) *loginEntity.Session {
+		uuid := entity.NewUUID()
+
+		return &loginEntity.Session{
+			UUID:         uuid,
+			UserUUID:     "user",
+			AccessToken:  "access-" + uuid,
+			RefreshToken: "refresh-" + uuid,
+			CreatedAt:    lastUsedAt,
+			LastUsedAt:   lastUsedAt,
+		}
+	}
+
+	expired := newSession(time.Now().Add(-loginUsecase.REFRESH_TOKEN_EXPIRES_IN - time.Hour))
+
+	_, err := database.Session.CreateSession("", ctx, expired)
+	if err != nil {
+		t.Fatalf("CreateSession failed: %s", err)
+	}
+
+	found, err := database.Session.FindSessionByRefreshToken("", ctx, expired.RefreshToken)
+	if err != nil || found != nil {
+		t.Errorf("FindSessionByRefreshToken should ignore expired sessions, got %v, %v", found, err)
+	}
+
+	sessions, err := database.Session.FindSessions("", ctx, "user")
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("FindSessions should ignore expired sessions, got %v, %v", sessions, err)
+	}
+
+	_, err = database.Session.CreateSession("", ctx, newSession(time.Now()))
+	if err != nil {
+		t.Fatalf("CreateSession failed: %s", err)
+	}
+
+	err = database.Session.DeleteExpired("", ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpired failed: %s", err)
+	}
+
+	var count int
+
+	err = database.db.QueryRowContext(ctx,
+		"SELECT count(*) FROM sessions WHERE uuid = $1", expired.UUID).Scan(&count)
+
+	if err != nil || count != 0 {
+		t.Errorf("DeleteExpired should delete expired sessions, got %d, %v", count, err)
+	}
+
+	sessions, err = database.Session.FindSessions("", ctx, "user")
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("DeleteExpired should keep unexpired sessions, got %v, %v", sessions, err)
+	}
+}
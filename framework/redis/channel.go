@@ -1,5 +1,9 @@
 package redis
 
+import (
+	"fmt"
+)
+
 type Channel string
 
 const (
@@ -9,6 +13,50 @@ const (
 	LOGIN_CHECK_ACTIVATE = Channel("login.check_activate")
 	LOGIN_ACTIVATE       = Channel("login.activate")
 	LOGIN_SIGNIN         = Channel("login.signin")
+	LOGIN_REFRESH        = Channel("login.refresh")
 	LOGIN_ME             = Channel("login.me")
+	LOGIN_SESSIONS       = Channel("login.sessions")
+	LOGIN_REVOKE_SESSION = Channel("login.revoke_session")
 	LOGIN_LOGOUT         = Channel("login.logout")
+	LOGIN_JWKS           = Channel("login.jwks")
 )
+
+// Version is the channel serving version of the schema of the requests of
+// channel. Version 1 is served on channel itself so that the peers deployed
+// before versioning still reach it.
+func (channel Channel) Version(version int) Channel {
+	if version <= 1 {
+		return channel
+	}
+
+	return Channel(fmt.Sprintf("%s.v%d", channel, version))
+}
+
+// The keys of a channel are hash tagged with its name so that they live in
+// the same REDIS Cluster slot as its queue.
+
+// processing is the list holding the requests a worker is handling.
+func (channel Channel) processing(worker string) string {
+	return fmt.Sprintf("{%s}:processing:%s", channel, worker)
+}
+
+// workers is the set of workers handling requests sent on channel.
+func (channel Channel) workers() string {
+	return fmt.Sprintf("{%s}:workers", channel)
+}
+
+// heartbeat is the key a worker keeps alive while it handles channel.
+func (channel Channel) heartbeat(worker string) string {
+	return fmt.Sprintf("{%s}:heartbeat:%s", channel, worker)
+}
+
+// idempotency is the key holding the response to an idempotent request.
+func (channel Channel) idempotency(key string) string {
+	return fmt.Sprintf("{%s}:idempotency:%s", channel, key)
+}
+
+// idempotencyWaiters lists the duplicates of the idempotent request key
+// waiting for its response.
+func (channel Channel) idempotencyWaiters(key string) string {
+	return fmt.Sprintf("{%s}:idempotency:%s:waiters", channel, key)
+}
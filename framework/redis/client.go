@@ -2,7 +2,11 @@ package redis
 
 import (
 	"context"
-	_ "fmt"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/kukinsula/boxy/entity"
@@ -12,48 +16,137 @@ import (
 	"github.com/gomodule/redigo/redis"
 )
 
-const responseIdLen = 16 // bytes
-
 type Config struct {
-	Address         string        `yaml:"address"`
-	MaxIdle         int           `yaml:"max-idle"`
-	MaxActive       int           `yaml:"max-active"`
-	IdleTimeout     time.Duration `yaml:"idle-timeout"`
-	MaxConnLifetime time.Duration `yaml:"max-conn-lifetime"`
-	Codec           codec.Codec
-	Logger          log.Logger
+	Address           string                     `yaml:"address"`
+	SentinelAddresses []string                   `yaml:"sentinel-addresses"`
+	SentinelMaster    string                     `yaml:"sentinel-master"`
+	SentinelPassword  string                     `yaml:"sentinel-password"`
+	ClusterAddresses  []string                   `yaml:"cluster-addresses"`
+	Username          string                     `yaml:"username"`
+	Password          string                     `yaml:"password"`
+	Database          int                        `yaml:"database"`
+	TLS               bool                       `yaml:"tls"`
+	TLSConfig         *tls.Config                `yaml:"-"`
+	Dial              func() (redis.Conn, error) `yaml:"-"` // Overrides every other address
+	MaxIdle           int                        `yaml:"max-idle"`
+	MaxActive         int                        `yaml:"max-active"`
+	IdleTimeout       time.Duration              `yaml:"idle-timeout"`
+	MaxConnLifetime   time.Duration              `yaml:"max-conn-lifetime"`
+	RequestTimeout    time.Duration              `yaml:"request-timeout"`
+	Heartbeat         time.Duration              `yaml:"heartbeat"`
+	IdempotencyTTL    time.Duration              `yaml:"idempotency-ttl"`
+	Transport         Transport                  `yaml:"transport"`
+	Concurrency       int                        `yaml:"concurrency"`
+	Codec             codec.Codec
+	Logger            log.Logger
+	Metrics           Metrics
 }
 
+const (
+	defaultHeartbeat      = 5 * time.Second
+	defaultIdempotencyTTL = 24 * time.Hour
+	defaultConcurrency    = 16
+)
+
 type Client struct {
-	pool   *redis.Pool
-	codec  codec.Codec
-	logger log.Logger
+	id             string
+	pool           pool
+	codec          codec.Codec
+	logger         log.Logger
+	requestTimeout time.Duration
+	heartbeat      time.Duration
+	idempotencyTTL time.Duration
+	concurrency    int
+	metrics        Metrics
+	transport      transport
+	closed         chan struct{}
+	mutex          *sync.Mutex
+	handling       *sync.WaitGroup
 }
 
+var ClientClosedErr = errors.New("REDIS client closed")
+
 func NewClient(config Config) (*Client, error) {
 	client := &Client{
-		pool: &redis.Pool{
-			MaxActive:   config.MaxActive,
-			MaxIdle:     config.MaxIdle,
-			IdleTimeout: config.IdleTimeout,
-			Wait:        true,
-			Dial: func() (redis.Conn, error) {
-				return redis.Dial("tcp", config.Address)
-			},
-		},
-		codec:  config.Codec,
-		logger: config.Logger,
+		id:             entity.NewUUID(),
+		codec:          config.Codec,
+		logger:         config.Logger,
+		requestTimeout: config.RequestTimeout,
+		heartbeat:      config.Heartbeat,
+		idempotencyTTL: config.IdempotencyTTL,
+		concurrency:    config.Concurrency,
+		metrics:        config.Metrics,
+		closed:         make(chan struct{}),
+		mutex:          &sync.Mutex{},
+		handling:       &sync.WaitGroup{},
 	}
 
+	if client.heartbeat == 0 {
+		client.heartbeat = defaultHeartbeat
+	}
+
+	if client.idempotencyTTL == 0 {
+		client.idempotencyTTL = defaultIdempotencyTTL
+	}
+
+	if client.concurrency <= 0 {
+		client.concurrency = defaultConcurrency
+	}
+
+	if client.metrics == nil {
+		client.metrics = NoOpMetrics
+	}
+
+	pool, err := newPool(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client.pool = pool
+
+	transport, err := newTransport(client, config.Transport)
+	if err != nil {
+		pool.Close()
+
+		return nil, err
+	}
+
+	client.transport = transport
+
 	return client, nil
 }
 
+// Close stops the workers started by Handle, waits for the requests they
+// have in flight to be answered and closes the connections. Closing a closed
+// Client does nothing.
 func (client *Client) Close() error {
+	client.mutex.Lock()
+
+	if client.isClosed() {
+		client.mutex.Unlock()
+		return nil
+	}
+
+	close(client.closed)
+	client.mutex.Unlock()
+
+	client.handling.Wait()
+
 	return client.pool.Close()
 }
 
+func (client *Client) isClosed() bool {
+	select {
+	case <-client.closed:
+		return true
+
+	default:
+		return false
+	}
+}
+
 func (client *Client) Ping() (string, error) {
-	conn := client.pool.Get()
+	conn := client.pool.Get("")
 	defer conn.Close()
 
 	return redis.String(conn.Do("PING"))
@@ -65,10 +158,14 @@ func (client *Client) Publish(channel Channel, v interface{}) error {
 		return err
 	}
 
-	conn := client.pool.Get()
+	return client.publish(channel, data)
+}
+
+func (client *Client) publish(channel Channel, data []byte) error {
+	conn := client.pool.Get(string(channel))
 	defer conn.Close()
 
-	_, err = conn.Do("PUBLISH", string(channel), data)
+	_, err := conn.Do("PUBLISH", string(channel), data)
 
 	return err
 }
@@ -79,19 +176,38 @@ func (client *Client) Subscribe(
 	ping time.Duration) *Subscription {
 
 	subscription := NewSusbcription(ctx, channel, ping)
-	conn := client.pool.Get()
 
-	go subscription.Start(conn)
+	go subscription.Start(func() redis.Conn {
+		return client.pool.Get(string(channel))
+	})
+
+	return subscription
+}
+
+// PSubscribe subscribes to the channels matching pattern.
+func (client *Client) PSubscribe(
+	ctx context.Context,
+	pattern Channel,
+	ping time.Duration) *Subscription {
+
+	subscription := NewPatternSubscription(ctx, pattern, ping)
+
+	go subscription.Start(func() redis.Conn {
+		return client.pool.Get(string(pattern))
+	})
 
 	return subscription
 }
 
 type Request struct {
-	UUID    string
-	Context context.Context
-	Channel Channel
-	Ping    time.Duration
-	Params  interface{}
+	UUID           string
+	Context        context.Context
+	Channel        Channel
+	Version        int // Schema version of Params, 1 by default
+	Timeout        time.Duration
+	IdempotencyKey string
+	Token          string // Sent to the handlers, checked by AuthMiddleware
+	Params         interface{}
 }
 
 type Response struct {
@@ -102,42 +218,48 @@ type Response struct {
 	logger  log.Logger
 }
 
+type TimeoutError struct {
+	UUID     string
+	Channel  Channel
+	Deadline time.Time
+}
+
+func (err *TimeoutError) Error() string {
+	return fmt.Sprintf("Request %s on channel %s timed out at %s",
+		err.UUID, err.Channel, err.Deadline.Format(time.RFC3339Nano))
+}
+
+func (err *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
 func (client *Client) Request(req *Request) *Response {
 	start := time.Now()
-	failure := make(chan error)
-	conn := client.pool.Get()
-	ctx, cancel := context.WithCancel(req.Context)
 	resp := &Response{Request: req, codec: client.codec, logger: client.logger}
-	subscription := NewSusbcription(ctx, Channel(req.UUID), req.Ping)
 
-	go func() {
-		failure <- subscription.Start(conn)
-		close(failure)
-	}()
+	ctx, cancel := client.requestContext(req)
+	defer cancel()
 
-	var err error
+	replyTo, responses, err := client.transport.listen(ctx, req)
+	if err == nil {
+		err = client.sendRequest(ctx, req, replyTo)
+	}
 
-	for goOn := true; goOn; goOn = goOn && err == nil {
+	if err == nil {
 		select {
-		case err = <-failure:
+		case data := <-responses:
+			err = client.readResponse(resp, data)
 
-		case <-subscription.Subscribed:
-			err = client.sendRequest(req)
-
-		case data := <-subscription.Message:
-			resp.data = data
-
-			// Cancels the subscription after the first message is received
-			cancel()
-
-			goOn = false
+		case <-ctx.Done():
+			err = requestContextError(ctx, req)
 		}
 	}
 
 	client.logger(req.UUID, log.DEBUG, "REDIS request finished",
 		map[string]interface{}{
-			"channel":  subscription.channel,
+			"channel":  req.Channel,
 			"duration": time.Since(start),
+			"error":    err,
 		})
 
 	if err != nil {
@@ -147,23 +269,97 @@ func (client *Client) Request(req *Request) *Response {
 	return resp
 }
 
-func (client *Client) sendRequest(req *Request) error {
-	conn := client.pool.Get()
-	defer conn.Close()
+func (client *Client) requestContext(req *Request) (context.Context, context.CancelFunc) {
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	uuid, err := entity.FromStringToBytes(req.UUID)
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = client.requestTimeout
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+func (client *Client) readResponse(resp *Response, raw []byte) error {
+	header := &responseHeader{}
+	body, err := unframe(client.codec, raw, header)
 	if err != nil {
 		return err
 	}
 
-	data, err := client.codec.Encode(req.Params)
+	if header.Protocol > PROTOCOL_VERSION {
+		return NewError(UNSUPPORTED, "Response protocol %d is not supported, %d at most",
+			header.Protocol, PROTOCOL_VERSION)
+	}
+
+	if header.Status == STATUS_ERROR {
+		if header.Error == nil {
+			return NewError(INTERNAL, "Request %s failed without error", resp.Request.UUID)
+		}
+
+		return header.Error
+	}
+
+	resp.data = body
+
+	return nil
+}
+
+func requestContextError(ctx context.Context, req *Request) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		deadline, _ := ctx.Deadline()
+
+		return &TimeoutError{
+			UUID:     req.UUID,
+			Channel:  req.Channel,
+			Deadline: deadline,
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return fmt.Errorf("Request %s on channel %s ended without response",
+		req.UUID, req.Channel)
+}
+
+func (client *Client) sendRequest(
+	ctx context.Context,
+	req *Request,
+	replyTo string) error {
+
+	params, err := client.codec.Encode(req.Params)
+	if err != nil {
+		return err
+	}
+
+	header := newRequestHeader(req.UUID, ctx)
+	header.Method = string(req.Channel)
+	header.Version = req.Version
+	header.IdempotencyKey = req.IdempotencyKey
+	header.ReplyTo = replyTo
+	header.Token = req.Token
+
+	if header.Version == 0 {
+		header.Version = 1
+	}
+
+	data, err := frame(client.codec, header, params)
 	if err != nil {
 		return err
 	}
 
-	_, err = conn.Do("RPUSH", string(req.Channel), append(uuid, data...))
+	err = client.transport.push(req.Channel.Version(req.Version), data)
 
-	client.logger(req.UUID, log.DEBUG, "REDIS RPUSH",
+	client.logger(req.UUID, log.DEBUG, "REDIS request sent",
 		map[string]interface{}{
 			"channel": req.Channel,
 			"params":  req.Params,
@@ -180,78 +376,269 @@ type Handler interface {
 
 type HandlerBuilder func() Handler
 
-func (client *Client) Handle(channel Channel, builder HandlerBuilder) (err error) {
-	conn := client.pool.Get()
+// Handle pops requests sent on channel and executes them with the handlers
+// built by builder, at most Config.Concurrency at once. A request stays
+// pending until its response is sent so that another worker re-queues it if
+// this one dies in between. Once ctx is done, Handle stops popping requests
+// and returns when the ones in flight are answered, as it does once the client
+// is closed.
+func (client *Client) Handle(
+	ctx context.Context,
+	channel Channel,
+	builder HandlerBuilder) error {
+
+	client.mutex.Lock()
 
-	for err == nil {
-		values, err := redis.Values(conn.Do("BLPOP", string(channel), 0))
-		if err != nil {
-			break
+	if client.isClosed() {
+		client.mutex.Unlock()
+		return ClientClosedErr
+	}
+
+	client.handling.Add(1)
+	client.mutex.Unlock()
+
+	defer client.handling.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-client.closed:
+			cancel()
+
+		case <-ctx.Done():
 		}
+	}()
+
+	err := client.transport.prepare(channel)
+	if err != nil {
+		return err
+	}
+
+	err = client.register(channel)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go client.supervise(channel, done)
+
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	// A slot is taken before popping so that requests this worker cannot
+	// handle yet stay queued for the others
+	slots := make(chan struct{}, client.concurrency)
+	handlers := &sync.WaitGroup{}
+
+	for err == nil && ctx.Err() == nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+
+		var delivery *delivery
+
+		// Popping times out regularly to notice when ctx is done
+		delivery, err = client.transport.pop(conn, channel, client.heartbeat)
+		if err != nil || delivery == nil {
+			<-slots
+			continue
+		}
+
+		handlers.Add(1)
 
 		go func() {
-			var tmp string
-			var raw []byte
-
-			// Scan the BLOP received request
-			_, err = redis.Scan(values, &tmp, &raw)
-			if err != nil {
-				return
-			}
-
-			// Extract the reponse ID from raw payload
-			requestId, err := entity.FromBytes(raw[:responseIdLen])
-			if err != nil {
-				return
-			}
-
-			handler := builder()
-			params := handler.Params()
-
-			// Decode request parameters
-			err = client.codec.Decode(raw[responseIdLen:], params)
-
-			client.logger(requestId, log.DEBUG,
-				"REDIS BLOP received a request to handle",
-				map[string]interface{}{
-					"channel": channel,
-					"params":  params,
-					"error":   err,
-				})
-
-			if err != nil {
-				return
-			}
-
-			// Execute the handler
-			var body interface{}
-			result, err := handler.Exec(requestId, context.Background())
-			if err == nil {
-				body = result
-			} else {
-				body = err
-			}
-
-			// Send the response
-			err = client.Publish(Channel(string(requestId)), body)
-
-			client.logger(requestId, log.DEBUG,
-				"REDIS PUBLISH response",
-				map[string]interface{}{
-					"channel": channel,
-					"result":  result,
-					"error":   err,
-				})
-
-			if err != nil {
-				return
-			}
+			defer handlers.Done()
+			defer func() { <-slots }()
+
+			client.handle(channel, builder, delivery)
 		}()
 	}
 
-	conn.Close()
+	handlers.Wait()
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	client.logger(client.id, log.INFO, "REDIS worker drained",
+		map[string]interface{}{"channel": channel})
+
+	return client.unregister(channel)
+}
+
+func (client *Client) handle(
+	channel Channel,
+	builder HandlerBuilder,
+	delivery *delivery) {
+
+	start := time.Now()
+
+	defer client.ack(channel, delivery)
+
+	// Extract the request header from raw payload
+	header := &requestHeader{}
+	body, err := unframe(client.codec, delivery.raw, header)
+	if err != nil {
+		client.logger("", log.ERROR, "REDIS received a malformed request",
+			map[string]interface{}{"channel": channel, "error": err})
+		return
+	}
+
+	ctx, cancel := header.context(channel)
+	defer cancel()
+
+	// The caller already gave up on this request
+	if ctx.Err() != nil {
+		deadline, _ := header.deadline()
+
+		client.logger(header.UUID, log.WARN,
+			"REDIS received an expired request, skipped",
+			map[string]interface{}{"channel": channel, "deadline": deadline})
+		return
+	}
+
+	err = header.check(channel)
+	if err != nil {
+		client.reply(channel, header, nil, err)
+		return
+	}
+
+	if header.IdempotencyKey != "" {
+		claimed, err := client.claim(channel, header)
+		if err != nil {
+			client.logger(header.UUID, log.ERROR, "REDIS idempotent request claim failed",
+				map[string]interface{}{"channel": channel, "error": err})
+		}
+
+		if err != nil || !claimed {
+			return
+		}
+	}
+
+	handler := builder()
+	params := handler.Params()
+
+	// Decode request parameters
+	err = client.codec.Decode(body, params)
+
+	client.logger(header.UUID, log.DEBUG,
+		"REDIS received a request to handle",
+		map[string]interface{}{
+			"channel": channel,
+			"params":  params,
+			"error":   err,
+		})
+
+	var result interface{}
+
+	if err != nil {
+		err = NewError(INVALID_PARAMS, "Cannot decode %s params: %s", channel, err)
+	} else {
+		result, err = client.exec(channel, handler, header.UUID, ctx)
+	}
+
+	// Send the response
+	data, failure := client.reply(channel, header, result, err)
+
+	status := STATUS_OK
+	if failure != nil {
+		status = STATUS_ERROR
+	}
+
+	client.metrics.HandlerLatency(channel, time.Since(start), status)
+
+	if header.IdempotencyKey != "" {
+		client.remember(channel, header, data, failure)
+	}
+}
+
+// exec runs handler and turns its panics into internal errors.
+func (client *Client) exec(
+	channel Channel,
+	handler Handler,
+	uuid string,
+	ctx context.Context) (result interface{}, err error) {
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		client.logger(uuid, log.ERROR, "REDIS handler panicked",
+			map[string]interface{}{
+				"channel": channel,
+				"panic":   recovered,
+				"stack":   string(debug.Stack()),
+			})
+
+		result = nil
+		err = NewError(INTERNAL, "Handler of %s panicked: %v", channel, recovered)
+	}()
+
+	return handler.Exec(uuid, ctx)
+}
+
+// reply publishes the response to a request and returns it along with the
+// error it carries if any.
+func (client *Client) reply(
+	channel Channel,
+	request *requestHeader,
+	result interface{},
+	failure error) ([]byte, *Error) {
+
+	header := &responseHeader{
+		Protocol: PROTOCOL_VERSION,
+		UUID:     request.UUID,
+		Status:   STATUS_OK,
+	}
+
+	var body []byte
+	var err error
+
+	if failure == nil {
+		body, err = client.codec.Encode(result)
+		if err != nil {
+			failure = err
+		}
+	}
+
+	if failure != nil {
+		var known *Error
+
+		// Only its code reaches the caller
+		if !errors.As(failure, &known) {
+			client.logger(request.UUID, log.ERROR, "REDIS request failed",
+				map[string]interface{}{"channel": channel, "error": failure})
+		}
+
+		header.Status = STATUS_ERROR
+		header.Error = AsError(failure)
+		body = nil
+	}
+
+	data, err := frame(client.codec, header, body)
+	if err == nil {
+		err = client.transport.reply(request.replyTo(), data)
+	}
+
+	client.logger(request.UUID, log.DEBUG,
+		"REDIS response sent",
+		map[string]interface{}{
+			"channel": channel,
+			"status":  header.Status,
+			"result":  result,
+			"failure": header.Error,
+			"error":   err,
+		})
+
+	return data, header.Error
 }
 
 func (resp *Response) Decode(result interface{}) error {
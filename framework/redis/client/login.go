@@ -2,10 +2,10 @@ package client
 
 import (
 	"context"
-	"time"
 
 	loginEntity "github.com/kukinsula/boxy/entity/login"
 	redisFramework "github.com/kukinsula/boxy/framework/redis"
+	"github.com/kukinsula/boxy/usecase"
 	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 )
 
@@ -24,11 +24,11 @@ func (login *Login) Signup(
 
 	result := &loginEntity.User{}
 	err := login.Request(&redisFramework.Request{
-		UUID:    uuid,
-		Context: context,
-		Channel: redisFramework.LOGIN_SIGNUP,
-		Params:  params,
-		Ping:    time.Minute,
+		UUID:           uuid,
+		Context:        context,
+		Channel:        redisFramework.LOGIN_SIGNUP,
+		Params:         params,
+		IdempotencyKey: uuid,
 	}).Decode(result)
 
 	if err != nil {
@@ -48,7 +48,6 @@ func (login *Login) CheckActivate(
 		Context: context,
 		Channel: redisFramework.LOGIN_CHECK_ACTIVATE,
 		Params:  params,
-		Ping:    time.Minute,
 	}).Error
 }
 
@@ -62,7 +61,6 @@ func (login *Login) Activate(
 		Context: context,
 		Channel: redisFramework.LOGIN_ACTIVATE,
 		Params:  params,
-		Ping:    time.Minute,
 	}).Error
 }
 
@@ -77,7 +75,26 @@ func (login *Login) Signin(
 		Context: context,
 		Channel: redisFramework.LOGIN_SIGNIN,
 		Params:  params,
-		Ping:    time.Minute,
+	}).Decode(result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (login *Login) Refresh(
+	uuid string,
+	context context.Context,
+	params *loginUsecase.RefreshParams) (*loginUsecase.SigninResult, error) {
+
+	result := &loginUsecase.SigninResult{}
+	err := login.Request(&redisFramework.Request{
+		UUID:    uuid,
+		Context: context,
+		Channel: redisFramework.LOGIN_REFRESH,
+		Params:  params,
 	}).Decode(result)
 
 	if err != nil {
@@ -98,7 +115,6 @@ func (login *Login) Me(
 		Context: context,
 		Channel: redisFramework.LOGIN_ME,
 		Params:  &loginUsecase.AccessTokenParams{Token: token},
-		Ping:    time.Minute,
 	}).Decode(result)
 
 	if err != nil {
@@ -108,6 +124,41 @@ func (login *Login) Me(
 	return result, nil
 }
 
+func (login *Login) Sessions(
+	uuid string,
+	context context.Context,
+	token string) ([]*loginEntity.Session, error) {
+
+	result := []*loginEntity.Session{}
+	err := login.Request(&redisFramework.Request{
+		UUID:    uuid,
+		Context: context,
+		Channel: redisFramework.LOGIN_SESSIONS,
+		Params:  &loginUsecase.AccessTokenParams{Token: token},
+	}).Decode(&result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (login *Login) RevokeSession(
+	uuid string,
+	context context.Context,
+	token, session string) error {
+
+	resp := login.Request(&redisFramework.Request{
+		UUID:    uuid,
+		Context: context,
+		Channel: redisFramework.LOGIN_REVOKE_SESSION,
+		Params:  &loginUsecase.RevokeSessionParams{Token: token, Session: session},
+	})
+
+	return resp.Error
+}
+
 func (login *Login) Logout(
 	uuid string,
 	context context.Context,
@@ -118,8 +169,26 @@ func (login *Login) Logout(
 		Context: context,
 		Channel: redisFramework.LOGIN_LOGOUT,
 		Params:  &loginUsecase.AccessTokenParams{Token: token},
-		Ping:    time.Minute,
 	})
 
 	return resp.Error
 }
+
+func (login *Login) JWKS(
+	uuid string,
+	context context.Context) (*usecase.JWKS, error) {
+
+	result := &usecase.JWKS{}
+	err := login.Request(&redisFramework.Request{
+		UUID:    uuid,
+		Context: context,
+		Channel: redisFramework.LOGIN_JWKS,
+		Params:  &struct{}{},
+	}).Decode(result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
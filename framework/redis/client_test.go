@@ -0,0 +1,552 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/codec"
+	"github.com/kukinsula/boxy/entity/log"
+	"github.com/kukinsula/boxy/framework/redis/redistest"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func newMemoryClient(t *testing.T, server *redistest.MemoryServer) *Client {
+	client, err := NewClient(Config{
+		Dial:           server.Dial,
+		MaxActive:      20,
+		MaxIdle:        10,
+		RequestTimeout: time.Second,
+		Heartbeat:      50 * time.Millisecond,
+		Codec:          &codec.JSONCodec{},
+		Logger:         log.NoOpLogger,
+	})
+
+	if err != nil {
+		t.Errorf("NewClient failed: %s", err)
+		t.FailNow()
+	}
+
+	return client
+}
+
+// serve handles channel with builder until the returned function is called.
+func serve(t *testing.T, client *Client, channel Channel, builder HandlerBuilder) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- client.Handle(ctx, channel, builder)
+	}()
+
+	return func() {
+		cancel()
+
+		err := <-done
+		if err != nil {
+			t.Errorf("Handle failed: %s", err)
+		}
+	}
+}
+
+type funcHandler struct {
+	params *echoParams
+	exec   func(params *echoParams) (interface{}, error)
+}
+
+func (handler *funcHandler) Params() interface{} { return handler.params }
+
+func (handler *funcHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
+	return handler.exec(handler.params)
+}
+
+func funcBuilder(exec func(params *echoParams) (interface{}, error)) HandlerBuilder {
+	return func() Handler {
+		return &funcHandler{params: &echoParams{}, exec: exec}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer worker.Close()
+	defer caller.Close()
+
+	stop := serve(t, worker, "test", func() Handler {
+		return &echoHandler{params: &echoParams{}}
+	})
+
+	defer stop()
+
+	for _, value := range []string{"foo", "bar"} {
+		result := &echoParams{}
+		err := caller.Request(&Request{
+			UUID:    entity.NewUUID(),
+			Channel: "test",
+			Params:  &echoParams{Value: value},
+		}).Decode(result)
+
+		if err != nil {
+			t.Errorf("Request failed: %s", err)
+		}
+
+		if result.Value != value {
+			t.Errorf("Request should return %s, got %s", value, result.Value)
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	client := newMemoryClient(t, server)
+	defer client.Close()
+
+	stop := serve(t, client, "test", funcBuilder(func(params *echoParams) (interface{}, error) {
+		return nil, NewError(NOT_FOUND, "%s not found", params.Value)
+	}))
+
+	defer stop()
+
+	err := client.Request(&Request{
+		UUID:    entity.NewUUID(),
+		Channel: "test",
+		Params:  &echoParams{Value: "foo"},
+	}).Error
+
+	var failure *Error
+
+	if !errors.As(err, &failure) || failure.Code != NOT_FOUND {
+		t.Errorf("Request should fail with NOT_FOUND, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	client := newMemoryClient(t, redistest.NewMemoryServer())
+	defer client.Close()
+
+	err := client.Request(&Request{
+		UUID:    entity.NewUUID(),
+		Channel: "test",
+		Timeout: 50 * time.Millisecond,
+		Params:  &echoParams{},
+	}).Error
+
+	var timeout *TimeoutError
+
+	if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request should time out, got %v", err)
+	}
+}
+
+func TestIdempotentRequest(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	client := newMemoryClient(t, server)
+	defer client.Close()
+
+	calls := int32(0)
+
+	stop := serve(t, client, "test", funcBuilder(func(params *echoParams) (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}))
+
+	defer stop()
+
+	key := entity.NewUUID()
+
+	for index := 0; index < 2; index++ {
+		result := 0
+		err := client.Request(&Request{
+			UUID:           entity.NewUUID(),
+			Channel:        "test",
+			IdempotencyKey: key,
+			Params:         &echoParams{},
+		}).Decode(&result)
+
+		if err != nil {
+			t.Errorf("Request failed: %s", err)
+		}
+
+		if result != 1 {
+			t.Errorf("Request should return the first response, got %d", result)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Idempotent request should be handled once, was %d times", calls)
+	}
+}
+
+func TestConcurrentIdempotentRequests(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	client := newMemoryClient(t, server)
+	defer client.Close()
+
+	calls := int32(0)
+	release := make(chan struct{})
+
+	stop := serve(t, client, "test", funcBuilder(func(params *echoParams) (interface{}, error) {
+		<-release
+		return atomic.AddInt32(&calls, 1), nil
+	}))
+
+	defer stop()
+
+	key := entity.NewUUID()
+	results := make(chan error, 2)
+
+	send := func() {
+		result := 0
+		err := client.Request(&Request{
+			UUID:           entity.NewUUID(),
+			Channel:        "test",
+			IdempotencyKey: key,
+			Timeout:        2 * time.Second,
+			Params:         &echoParams{},
+		}).Decode(&result)
+
+		if err == nil && result != 1 {
+			err = fmt.Errorf("Request should return the first response, got %d", result)
+		}
+
+		results <- err
+	}
+
+	go send()
+
+	if !eventually(time.Second, func() bool {
+		conn := client.pool.Get("test")
+		defer conn.Close()
+
+		exists, _ := redis.Bool(conn.Do("EXISTS", Channel("test").idempotency(key)))
+
+		return exists
+	}) {
+		t.Fatalf("The first request should be claimed")
+	}
+
+	// The duplicate waits for the response of the first request
+	go send()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for index := 0; index < 2; index++ {
+		err := <-results
+		if err != nil {
+			t.Errorf("Request failed: %s", err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Idempotent request should be handled once, was %d times", calls)
+	}
+}
+
+func TestReap(t *testing.T) {
+	client := newRedisClient(t, LIST_TRANSPORT)
+	defer client.Close()
+
+	channel := Channel(fmt.Sprintf("test.reap.%s", entity.NewUUID()))
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	defer conn.Do("DEL", string(channel), channel.workers(),
+		channel.processing("dead"), channel.processing("alive"), channel.heartbeat("alive"))
+
+	// A worker which died while handling two requests and a living one
+	conn.Do("SADD", channel.workers(), "dead", "alive")
+	conn.Do("LPUSH", channel.processing("dead"), "first", "second")
+	conn.Do("LPUSH", channel.processing("alive"), "third")
+	conn.Do("SET", channel.heartbeat("alive"), "1", "PX", 10000)
+
+	requeued, err := client.Reap(channel)
+	if err != nil {
+		t.Errorf("Reap failed: %s", err)
+	}
+
+	if requeued != 2 {
+		t.Errorf("Reap should re-queue 2 requests, got %d", requeued)
+	}
+
+	for _, expected := range []string{"first", "second"} {
+		data, err := redis.String(conn.Do("RPOP", string(channel)))
+		if err != nil || data != expected {
+			t.Errorf("Request %s should be re-queued in order, got %s (%v)",
+				expected, data, err)
+		}
+	}
+
+	workers, err := redis.Strings(conn.Do("SMEMBERS", channel.workers()))
+	if err != nil || len(workers) != 1 || workers[0] != "alive" {
+		t.Errorf("Reap should only forget the dead worker, got %v (%v)", workers, err)
+	}
+
+	processing, err := redis.Int(conn.Do("LLEN", channel.processing("alive")))
+	if err != nil || processing != 1 {
+		t.Errorf("Reap should leave the requests of the living worker, got %d (%v)",
+			processing, err)
+	}
+}
+
+type panicHandler struct{}
+
+func (handler *panicHandler) Params() interface{} { return nil }
+
+func (handler *panicHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
+	panic("boom")
+}
+
+func TestExecRecoversPanics(t *testing.T) {
+	client := &Client{logger: log.NoOpLogger}
+
+	result, err := client.exec("test", &panicHandler{}, "uuid", context.Background())
+	if result != nil {
+		t.Errorf("exec should return no result, got %v", result)
+	}
+
+	failure := AsError(err)
+	if err == nil || failure.Code != INTERNAL {
+		t.Errorf("exec should return an INTERNAL error, got %v", err)
+	}
+}
+
+// eventually polls condition until it holds or timeout elapses.
+func eventually(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return true
+}
+
+// blockingBuilder builds handlers counting how many run at once until
+// release is closed.
+func blockingBuilder(running, highest *int32, release chan struct{}) HandlerBuilder {
+	return funcBuilder(func(params *echoParams) (interface{}, error) {
+		current := atomic.AddInt32(running, 1)
+		defer atomic.AddInt32(running, -1)
+
+		for {
+			max := atomic.LoadInt32(highest)
+			if current <= max || atomic.CompareAndSwapInt32(highest, max, current) {
+				break
+			}
+		}
+
+		<-release
+
+		return params, nil
+	})
+}
+
+func request(client *Client, value string, results chan<- error) {
+	results <- client.Request(&Request{
+		UUID:    entity.NewUUID(),
+		Channel: "test",
+		Timeout: 5 * time.Second,
+		Params:  &echoParams{Value: value},
+	}).Error
+}
+
+func TestHandleConcurrency(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer worker.Close()
+	defer caller.Close()
+
+	worker.concurrency = 2
+
+	var running, highest int32
+	release := make(chan struct{})
+
+	stop := serve(t, worker, "test", blockingBuilder(&running, &highest, release))
+	defer stop()
+
+	results := make(chan error, 3)
+
+	for _, value := range []string{"first", "second", "third"} {
+		go request(caller, value, results)
+	}
+
+	if !eventually(time.Second, func() bool { return atomic.LoadInt32(&running) == 2 }) {
+		t.Errorf("Handle should run 2 handlers, got %d", atomic.LoadInt32(&running))
+	}
+
+	// The third request stays queued for the other workers
+	time.Sleep(5 * worker.heartbeat)
+
+	if atomic.LoadInt32(&running) != 2 {
+		t.Errorf("Handle should not run more than 2 handlers, got %d",
+			atomic.LoadInt32(&running))
+	}
+
+	depth, err := worker.transport.depth("test")
+	if err != nil || depth != 1 {
+		t.Errorf("1 request should be queued, got %d (%v)", depth, err)
+	}
+
+	close(release)
+
+	for index := 0; index < 3; index++ {
+		err := <-results
+		if err != nil {
+			t.Errorf("Request failed: %s", err)
+		}
+	}
+
+	if atomic.LoadInt32(&highest) != 2 {
+		t.Errorf("At most 2 handlers should have run at once, got %d",
+			atomic.LoadInt32(&highest))
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer caller.Close()
+
+	var running, highest int32
+	release := make(chan struct{})
+
+	handled := make(chan error, 1)
+	go func() {
+		handled <- worker.Handle(context.Background(), "test",
+			blockingBuilder(&running, &highest, release))
+	}()
+
+	results := make(chan error, 1)
+	go request(caller, "foo", results)
+
+	if !eventually(time.Second, func() bool { return atomic.LoadInt32(&running) == 1 }) {
+		t.Errorf("Handle should run the request")
+		t.FailNow()
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- worker.Close() }()
+
+	select {
+	case <-closed:
+		t.Error("Close should wait for the request in flight")
+
+	case <-time.After(5 * worker.heartbeat):
+	}
+
+	close(release)
+
+	err := <-closed
+	if err != nil {
+		t.Errorf("Close failed: %s", err)
+	}
+
+	err = <-handled
+	if err != nil {
+		t.Errorf("Handle should return once drained, got %s", err)
+	}
+
+	err = <-results
+	if err != nil {
+		t.Errorf("The request in flight should be answered, got %s", err)
+	}
+
+	err = worker.Handle(context.Background(), "test", funcBuilder(nil))
+	if !errors.Is(err, ClientClosedErr) {
+		t.Errorf("Handle should fail once closed, got %v", err)
+	}
+
+	err = worker.Close()
+	if err != nil {
+		t.Errorf("Close should do nothing once closed, got %s", err)
+	}
+}
+
+type recordedLatency struct {
+	channel Channel
+	status  Status
+}
+
+type metricsRecorder struct {
+	mutex     *sync.Mutex
+	depths    map[Channel]int
+	latencies []recordedLatency
+}
+
+func (metrics *metricsRecorder) QueueDepth(channel Channel, depth int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.depths[channel] = depth
+}
+
+func (metrics *metricsRecorder) HandlerLatency(
+	channel Channel,
+	latency time.Duration,
+	status Status) {
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.latencies = append(metrics.latencies, recordedLatency{channel, status})
+}
+
+func TestMetrics(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	client := newMemoryClient(t, server)
+	defer client.Close()
+
+	metrics := &metricsRecorder{mutex: &sync.Mutex{}, depths: map[Channel]int{}}
+	client.metrics = metrics
+
+	stop := serve(t, client, "test", funcBuilder(func(params *echoParams) (interface{}, error) {
+		if params.Value == "fail" {
+			return nil, NewError(NOT_FOUND, "%s not found", params.Value)
+		}
+
+		return params, nil
+	}))
+
+	defer stop()
+
+	for _, value := range []string{"foo", "fail"} {
+		client.Request(&Request{
+			UUID:    entity.NewUUID(),
+			Channel: "test",
+			Params:  &echoParams{Value: value},
+		})
+	}
+
+	observed := eventually(time.Second, func() bool {
+		metrics.mutex.Lock()
+		defer metrics.mutex.Unlock()
+
+		_, ok := metrics.depths["test"]
+
+		return ok && len(metrics.latencies) == 2
+	})
+
+	if !observed {
+		t.Errorf("QueueDepth and HandlerLatency should be called, got %v and %v",
+			metrics.depths, metrics.latencies)
+		t.FailNow()
+	}
+
+	expected := []recordedLatency{{"test", STATUS_OK}, {"test", STATUS_ERROR}}
+
+	for index, latency := range metrics.latencies {
+		if latency != expected[index] {
+			t.Errorf("HandlerLatency should be called with %v, got %v",
+				expected[index], latency)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const clusterSlots = 16384
+
+// clusterPool routes each key to the pool of the node serving its slot. The
+// keys of a channel share a hash tag so that they live in the same slot, and
+// PUBSUB messages are broadcast to the whole cluster by any node.
+type clusterPool struct {
+	config     Config
+	options    []redis.DialOption
+	mutex      *sync.RWMutex
+	nodes      map[string]*redis.Pool
+	slots      []string
+	refreshing int32
+}
+
+func newClusterPool(config Config) (*clusterPool, error) {
+	pool := &clusterPool{
+		config:  config,
+		options: dialOptions(config),
+		mutex:   &sync.RWMutex{},
+		nodes:   map[string]*redis.Pool{},
+		slots:   make([]string, clusterSlots),
+	}
+
+	err := pool.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+func (pool *clusterPool) Get(key string) redis.Conn {
+	pool.mutex.RLock()
+	address := pool.slots[keySlot(key)]
+	pool.mutex.RUnlock()
+
+	// Slots not covered yet are redirected by the seed node
+	if address == "" {
+		address = pool.config.ClusterAddresses[0]
+	}
+
+	return &clusterConn{Conn: pool.node(address).Get(), pool: pool}
+}
+
+func (pool *clusterPool) Close() error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	var failure error
+
+	for _, node := range pool.nodes {
+		err := node.Close()
+		if err != nil {
+			failure = err
+		}
+	}
+
+	return failure
+}
+
+func (pool *clusterPool) node(address string) *redis.Pool {
+	pool.mutex.RLock()
+	node, ok := pool.nodes[address]
+	pool.mutex.RUnlock()
+
+	if ok {
+		return node
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	node, ok = pool.nodes[address]
+	if !ok {
+		node = newRedisPool(pool.config, func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, pool.options...)
+		})
+
+		pool.nodes[address] = node
+	}
+
+	return node
+}
+
+// refresh reloads the slots map from the first node answering CLUSTER SLOTS.
+func (pool *clusterPool) refresh() error {
+	pool.mutex.RLock()
+	addresses := append([]string{}, pool.config.ClusterAddresses...)
+	for address := range pool.nodes {
+		addresses = append(addresses, address)
+	}
+	pool.mutex.RUnlock()
+
+	var failure error
+
+	for _, address := range addresses {
+		conn := pool.node(address).Get()
+		ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+		conn.Close()
+
+		if err != nil {
+			failure = err
+			continue
+		}
+
+		slots := make([]string, clusterSlots)
+
+		err = parseClusterSlots(ranges, slots)
+		if err != nil {
+			failure = err
+			continue
+		}
+
+		pool.mutex.Lock()
+		pool.slots = slots
+		pool.mutex.Unlock()
+
+		return nil
+	}
+
+	return fmt.Errorf("REDIS Cluster slots unavailable: %w", failure)
+}
+
+// redirected refreshes the slots map in the background once a node answered
+// that a slot moved.
+func (pool *clusterPool) redirected() {
+	if !atomic.CompareAndSwapInt32(&pool.refreshing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&pool.refreshing, 0)
+
+		pool.refresh()
+	}()
+}
+
+// parseClusterSlots fills slots with the address of the master serving each
+// range of a CLUSTER SLOTS reply: [start, end, [ip, port, ...], replicas...].
+func parseClusterSlots(ranges []interface{}, slots []string) error {
+	for _, value := range ranges {
+		fields, err := redis.Values(value, nil)
+		if err != nil {
+			return err
+		}
+
+		if len(fields) < 3 {
+			return fmt.Errorf("Malformed REDIS CLUSTER SLOTS range: %v", fields)
+		}
+
+		start, err := redis.Int(fields[0], nil)
+		if err != nil {
+			return err
+		}
+
+		end, err := redis.Int(fields[1], nil)
+		if err != nil {
+			return err
+		}
+
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return fmt.Errorf("Malformed REDIS CLUSTER SLOTS master: %v", fields[2])
+		}
+
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return err
+		}
+
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return err
+		}
+
+		if start < 0 || end >= clusterSlots || start > end {
+			return fmt.Errorf("Invalid REDIS CLUSTER SLOTS range %d-%d", start, end)
+		}
+
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = fmt.Sprintf("%s:%d", host, port)
+		}
+	}
+
+	return nil
+}
+
+// clusterConn follows once the MOVED and ASK redirections of the commands
+// it runs, pipelined or not. The commands of a transaction are not followed,
+// a redirection aborting the whole transaction.
+type clusterConn struct {
+	redis.Conn
+	pool    *clusterPool
+	pending []*clusterCommand // Sent, waiting for their reply
+	multi   bool
+}
+
+type clusterCommand struct {
+	name        string
+	args        []interface{}
+	transaction bool
+}
+
+func (conn *clusterConn) Do(command string, args ...interface{}) (interface{}, error) {
+	// The replies of the pipelined commands are read and dropped by Do
+	conn.pending = nil
+
+	transaction := conn.track(command)
+	reply, err := conn.Conn.Do(command, args...)
+
+	if command == "" {
+		return reply, err
+	}
+
+	return conn.follow(&clusterCommand{command, args, transaction}, reply, err)
+}
+
+func (conn *clusterConn) Send(command string, args ...interface{}) error {
+	err := conn.Conn.Send(command, args...)
+	if err != nil {
+		return err
+	}
+
+	conn.pending = append(conn.pending, &clusterCommand{
+		name:        command,
+		args:        args,
+		transaction: conn.track(command),
+	})
+
+	return nil
+}
+
+func (conn *clusterConn) Receive() (interface{}, error) {
+	reply, err := conn.Conn.Receive()
+
+	// PUBSUB messages come without any command sent
+	if len(conn.pending) == 0 {
+		return reply, err
+	}
+
+	command := conn.pending[0]
+	conn.pending = conn.pending[1:]
+
+	return conn.follow(command, reply, err)
+}
+
+// track returns whether command runs within a transaction.
+func (conn *clusterConn) track(command string) bool {
+	transaction := conn.multi
+
+	switch strings.ToUpper(command) {
+	case "MULTI":
+		conn.multi = true
+
+	case "EXEC", "DISCARD":
+		conn.multi = false
+	}
+
+	return transaction
+}
+
+// follow runs command again on the node its reply redirects to, sending
+// ASKING first for an ASK redirection.
+func (conn *clusterConn) follow(
+	command *clusterCommand,
+	reply interface{},
+	err error) (interface{}, error) {
+
+	ask, address, ok := parseRedirection(err)
+	if !ok {
+		return reply, err
+	}
+
+	conn.pool.redirected()
+
+	if command.transaction {
+		return reply, err
+	}
+
+	target := conn.pool.node(address).Get()
+	defer target.Close()
+
+	if ask {
+		_, err = target.Do("ASKING")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return target.Do(command.name, command.args...)
+}
+
+// parseRedirection reads the address of a "MOVED slot address" or "ASK slot
+// address" error.
+func parseRedirection(err error) (bool, string, bool) {
+	failure, ok := err.(redis.Error)
+	if !ok {
+		return false, "", false
+	}
+
+	fields := strings.Fields(string(failure))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return false, "", false
+	}
+
+	return fields[0] == "ASK", fields[2], true
+}
+
+// keySlot is the cluster slot of key, computed on its hash tag if it has one.
+func keySlot(key string) int {
+	start := strings.IndexByte(key, '{')
+	if start >= 0 {
+		end := strings.IndexByte(key[start+1:], '}')
+		if end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16([]byte(key)) % clusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used by REDIS Cluster.
+func crc16(data []byte) uint16 {
+	crc := uint16(0)
+
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
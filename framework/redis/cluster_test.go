@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 0x31C3},
+		{"foo", 12182},
+		{"{foo}:workers", 12182},
+		{"{login.signup}:processing:worker", keySlot(string(LOGIN_SIGNUP))},
+	}
+
+	for _, test := range tests {
+		slot := keySlot(test.key)
+		if slot != test.slot {
+			t.Errorf("keySlot(%s) should be %d, got %d", test.key, test.slot, slot)
+		}
+	}
+
+	if keySlot("{}foo") == keySlot("") {
+		t.Errorf("keySlot should hash the whole key when its hash tag is empty")
+	}
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	slots := make([]string, clusterSlots)
+
+	err := parseClusterSlots([]interface{}{
+		[]interface{}{int64(0), int64(8191),
+			[]interface{}{[]byte("10.0.0.1"), int64(6379), []byte("a")}},
+		[]interface{}{int64(8192), int64(16383),
+			[]interface{}{[]byte("10.0.0.2"), int64(6379), []byte("b")},
+			[]interface{}{[]byte("10.0.0.3"), int64(6379), []byte("c")}},
+	}, slots)
+
+	if err != nil {
+		t.Errorf("parseClusterSlots failed: %s", err)
+		t.FailNow()
+	}
+
+	if slots[0] != "10.0.0.1:6379" || slots[8191] != "10.0.0.1:6379" {
+		t.Errorf("Slots 0-8191 should be served by 10.0.0.1:6379")
+	}
+
+	if slots[8192] != "10.0.0.2:6379" || slots[16383] != "10.0.0.2:6379" {
+		t.Errorf("Slots 8192-16383 should be served by the master 10.0.0.2:6379")
+	}
+}
+
+// nodeConn answers the commands with the replies of a fake cluster node and
+// records them.
+type nodeConn struct {
+	node    *fakeNode
+	replies []interface{}
+}
+
+type fakeNode struct {
+	mutex    *sync.Mutex
+	commands []string
+	answer   func(command string) (interface{}, error)
+}
+
+func newFakeNode(answer func(command string) (interface{}, error)) *fakeNode {
+	return &fakeNode{mutex: &sync.Mutex{}, answer: answer}
+}
+
+func (node *fakeNode) pool() *redis.Pool {
+	return &redis.Pool{Dial: func() (redis.Conn, error) { return &nodeConn{node: node}, nil }}
+}
+
+func (node *fakeNode) run(command string, args []interface{}) (interface{}, error) {
+	line := strings.TrimSpace(fmt.Sprintln(append([]interface{}{command}, args...)...))
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	// Neither the background refresh of the slots nor the flush of the pooled
+	// connections closed are part of the tests
+	if command != "CLUSTER" && command != "" {
+		node.commands = append(node.commands, line)
+	}
+
+	return node.answer(line)
+}
+
+func (node *fakeNode) received() []string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return append([]string{}, node.commands...)
+}
+
+func (conn *nodeConn) Close() error { return nil }
+func (conn *nodeConn) Err() error   { return nil }
+func (conn *nodeConn) Flush() error { return nil }
+
+func (conn *nodeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return conn.node.run(command, args)
+}
+
+func (conn *nodeConn) Send(command string, args ...interface{}) error {
+	reply, err := conn.node.run(command, args)
+	if err != nil {
+		reply = err
+	}
+
+	conn.replies = append(conn.replies, reply)
+
+	return nil
+}
+
+func (conn *nodeConn) Receive() (interface{}, error) {
+	reply := conn.replies[0]
+	conn.replies = conn.replies[1:]
+
+	err, ok := reply.(error)
+	if ok {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func TestParseRedirection(t *testing.T) {
+	tests := []struct {
+		err     error
+		ask     bool
+		address string
+		ok      bool
+	}{
+		{redis.Error("MOVED 3999 127.0.0.1:6381"), false, "127.0.0.1:6381", true},
+		{redis.Error("ASK 3999 127.0.0.1:6381"), true, "127.0.0.1:6381", true},
+		{redis.Error("ERR unknown command"), false, "", false},
+		{nil, false, "", false},
+	}
+
+	for _, test := range tests {
+		ask, address, ok := parseRedirection(test.err)
+		if ask != test.ask || address != test.address || ok != test.ok {
+			t.Errorf("parseRedirection(%v) should be %v %s %v, got %v %s %v",
+				test.err, test.ask, test.address, test.ok, ask, address, ok)
+		}
+	}
+}
+
+func TestClusterConnFollowsRedirections(t *testing.T) {
+	seed := newFakeNode(func(command string) (interface{}, error) {
+		switch command {
+		case "GET moved":
+			return nil, redis.Error("MOVED 1 target")
+
+		case "GET asked":
+			return nil, redis.Error("ASK 1 target")
+
+		case "GET local":
+			return []byte("local"), nil
+		}
+
+		return []byte("OK"), nil
+	})
+
+	target := newFakeNode(func(command string) (interface{}, error) {
+		return []byte("target"), nil
+	})
+
+	pool := &clusterPool{
+		mutex:      &sync.RWMutex{},
+		nodes:      map[string]*redis.Pool{"seed": seed.pool(), "target": target.pool()},
+		slots:      make([]string, clusterSlots),
+		refreshing: 1, // No background refresh
+	}
+
+	conn := &clusterConn{Conn: &nodeConn{node: seed}, pool: pool}
+
+	for _, key := range []string{"moved", "asked", "local"} {
+		expected := "target"
+		if key == "local" {
+			expected = "local"
+		}
+
+		reply, err := redis.String(conn.Do("GET", key))
+		if err != nil || reply != expected {
+			t.Errorf("Do GET %s should be answered by %s, got %s (%v)", key, expected, reply, err)
+		}
+	}
+
+	// Pipelined
+	conn.Send("GET", "moved")
+	conn.Send("GET", "local")
+	conn.Send("GET", "asked")
+	conn.Flush()
+
+	for _, expected := range []string{"target", "local", "target"} {
+		reply, err := redis.String(conn.Receive())
+		if err != nil || reply != expected {
+			t.Errorf("Receive should return %s, got %s (%v)", expected, reply, err)
+		}
+	}
+
+	received := target.received()
+	expected := []string{"GET moved", "ASKING", "GET asked", "GET moved", "ASKING", "GET asked"}
+
+	if strings.Join(received, ",") != strings.Join(expected, ",") {
+		t.Errorf("Target node should receive %v, got %v", expected, received)
+	}
+
+	// A transaction is aborted as a whole by a redirection
+	conn.Send("MULTI")
+	conn.Send("GET", "moved")
+	conn.Send("EXEC")
+	conn.Flush()
+
+	conn.Receive()
+
+	_, err := conn.Receive()
+	if _, _, ok := parseRedirection(err); !ok {
+		t.Errorf("A redirection within a transaction should not be followed, got %v", err)
+	}
+
+	if len(target.received()) != len(expected) {
+		t.Errorf("Target node should receive no command of the transaction, got %v",
+			target.received())
+	}
+}
@@ -0,0 +1,282 @@
+package redis
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// A worker is considered dead once it missed that many heartbeats.
+const staleHeartbeats = 3
+
+const pendingPrefix = "pending:"
+
+func (client *Client) staleAfter() time.Duration {
+	return staleHeartbeats * client.heartbeat
+}
+
+func (client *Client) register(channel Channel) error {
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	_, err := conn.Do("SET", channel.heartbeat(client.id), client.id,
+		"PX", milliseconds(client.staleAfter()))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("SADD", channel.workers(), client.id)
+
+	return err
+}
+
+// unregister removes this worker from channel once it stopped handling
+// requests.
+func (client *Client) unregister(channel Channel) error {
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", channel.heartbeat(client.id))
+	conn.Send("SREM", channel.workers(), client.id)
+
+	_, err := conn.Do("EXEC")
+
+	return err
+}
+
+// supervise keeps this worker alive on channel, re-queues the requests of the
+// dead ones and reports the queue depth until done is closed.
+func (client *Client) supervise(channel Channel, done chan struct{}) {
+	ticker := time.NewTicker(client.heartbeat)
+	defer ticker.Stop()
+
+	for goOn := true; goOn; {
+		select {
+		case <-ticker.C:
+			err := client.register(channel)
+			if err == nil {
+				_, err = client.Reap(channel)
+			}
+
+			if err == nil {
+				var depth int
+
+				depth, err = client.transport.depth(channel)
+				if err == nil {
+					client.metrics.QueueDepth(channel, depth)
+				}
+			}
+
+			if err != nil {
+				client.logger(client.id, log.WARN, "REDIS worker supervision failed",
+					map[string]interface{}{"channel": channel, "error": err})
+			}
+
+		case <-done:
+			goOn = false
+		}
+	}
+}
+
+// Reap re-queues the in-flight requests of the workers of channel which
+// stopped sending heartbeats and returns how many were re-queued.
+func (client *Client) Reap(channel Channel) (int, error) {
+	return client.transport.reap(channel)
+}
+
+// ack acknowledges a handled request so that it is not re-queued.
+func (client *Client) ack(channel Channel, delivery *delivery) {
+	err := client.transport.ack(channel, delivery)
+	if err != nil {
+		client.logger(client.id, log.ERROR, "REDIS acknowledgement failed",
+			map[string]interface{}{"channel": channel, "error": err})
+	}
+}
+
+// claim reserves an idempotent request for this worker. It returns false when
+// a live worker is already handling it, in which case the request waits for
+// its response, or when it was already answered in which case the stored
+// response is sent again.
+func (client *Client) claim(channel Channel, header *requestHeader) (bool, error) {
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	key := channel.idempotency(header.IdempotencyKey)
+
+	// The key changing while watched, the claim starts over
+	for {
+		_, err := conn.Do("WATCH", key)
+		if err != nil {
+			return false, err
+		}
+
+		data, err := redis.Bytes(conn.Do("GET", key))
+
+		switch {
+		case err == redis.ErrNil:
+
+		case err != nil:
+			conn.Do("UNWATCH")
+
+			return false, err
+
+		case bytes.HasPrefix(data, []byte(pendingPrefix)):
+			owner := string(data[len(pendingPrefix):])
+
+			alive, err := redis.Bool(conn.Do("EXISTS", channel.heartbeat(owner)))
+			if err != nil {
+				conn.Do("UNWATCH")
+
+				return false, err
+			}
+
+			if alive {
+				waiting, err := client.wait(conn, channel, header)
+				if err != nil || waiting {
+					client.logger(header.UUID, log.DEBUG,
+						"REDIS idempotent request already being handled, waiting for its response",
+						map[string]interface{}{"channel": channel, "worker": owner, "error": err})
+
+					return false, err
+				}
+
+				continue
+			}
+
+		default:
+			conn.Do("UNWATCH")
+
+			client.logger(header.UUID, log.DEBUG,
+				"REDIS idempotent request already handled, response sent again",
+				map[string]interface{}{"channel": channel, "key": header.IdempotencyKey})
+
+			return false, client.replay(header, data)
+		}
+
+		conn.Send("MULTI")
+		conn.Send("SET", key, pendingPrefix+client.id, "PX", milliseconds(client.idempotencyTTL))
+
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return false, err
+		}
+
+		// Another worker claimed the request in the meantime
+		return reply != nil, nil
+	}
+}
+
+// wait adds the request of header to the waiters of its idempotent request,
+// unless the watched key changed, in which case it returns false.
+func (client *Client) wait(
+	conn redis.Conn,
+	channel Channel,
+	header *requestHeader) (bool, error) {
+
+	waiter, err := client.codec.Encode(&requestHeader{UUID: header.UUID, ReplyTo: header.ReplyTo})
+	if err != nil {
+		conn.Do("UNWATCH")
+
+		return false, err
+	}
+
+	waiters := channel.idempotencyWaiters(header.IdempotencyKey)
+
+	conn.Send("MULTI")
+	conn.Send("RPUSH", waiters, waiter)
+	conn.Send("PEXPIRE", waiters, milliseconds(client.idempotencyTTL))
+
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return false, err
+	}
+
+	return reply != nil, nil
+}
+
+// replay sends again a stored response, addressed to the request it now
+// answers since replies are dispatched by request UUID.
+func (client *Client) replay(request *requestHeader, data []byte) error {
+	header := &responseHeader{}
+
+	body, err := unframe(client.codec, data, header)
+	if err != nil {
+		return err
+	}
+
+	header.UUID = request.UUID
+
+	data, err = frame(client.codec, header, body)
+	if err != nil {
+		return err
+	}
+
+	return client.transport.reply(request.replyTo(), data)
+}
+
+// remember stores the response to an idempotent request and sends it to the
+// duplicates waiting for it. Internal failures are forgotten so that the
+// request can be retried.
+func (client *Client) remember(
+	channel Channel,
+	header *requestHeader,
+	data []byte,
+	failure *Error) {
+
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	var err error
+
+	key := channel.idempotency(header.IdempotencyKey)
+
+	if data == nil || (failure != nil && failure.Code == INTERNAL) {
+		_, err = conn.Do("DEL", key)
+	} else {
+		_, err = conn.Do("SET", key, data, "PX", milliseconds(client.idempotencyTTL))
+	}
+
+	if err != nil {
+		client.logger(header.UUID, log.ERROR, "REDIS idempotent response not stored",
+			map[string]interface{}{"channel": channel, "error": err})
+	}
+
+	// The key changed, no more duplicate can wait
+	waiters := channel.idempotencyWaiters(header.IdempotencyKey)
+
+	for goOn := true; goOn; {
+		var waiter []byte
+
+		waiter, err = redis.Bytes(conn.Do("LPOP", waiters))
+		if err != nil {
+			goOn = false
+			continue
+		}
+
+		request := &requestHeader{}
+
+		err = client.codec.Decode(waiter, request)
+		if err == nil && data != nil {
+			err = client.replay(request, data)
+		}
+
+		if err != nil {
+			client.logger(request.UUID, log.ERROR, "REDIS idempotent response not sent to a duplicate",
+				map[string]interface{}{"channel": channel, "error": err})
+		}
+	}
+
+	if err != redis.ErrNil {
+		client.logger(header.UUID, log.ERROR, "REDIS idempotent request waiters not answered",
+			map[string]interface{}{"channel": channel, "error": err})
+	}
+}
+
+func milliseconds(duration time.Duration) int64 {
+	return int64(duration / time.Millisecond)
+}
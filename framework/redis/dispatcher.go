@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"sync"
+)
+
+// dispatcher hands the responses received on a shared reply address to the
+// requests waiting for them, identified by their UUID.
+type dispatcher struct {
+	mutex   *sync.Mutex
+	waiters map[string]chan []byte
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		mutex:   &sync.Mutex{},
+		waiters: map[string]chan []byte{},
+	}
+}
+
+func (dispatcher *dispatcher) wait(uuid string) <-chan []byte {
+	responses := make(chan []byte, 1)
+
+	dispatcher.mutex.Lock()
+	dispatcher.waiters[uuid] = responses
+	dispatcher.mutex.Unlock()
+
+	return responses
+}
+
+// forget stops waiting with responses, leaving alone the request which
+// reuses uuid in the meantime.
+func (dispatcher *dispatcher) forget(uuid string, responses <-chan []byte) {
+	dispatcher.mutex.Lock()
+
+	if dispatcher.waiters[uuid] == responses {
+		delete(dispatcher.waiters, uuid)
+	}
+
+	dispatcher.mutex.Unlock()
+}
+
+// dispatch delivers data to the request uuid and returns false if nobody
+// waits for it anymore.
+func (dispatcher *dispatcher) dispatch(uuid string, data []byte) bool {
+	dispatcher.mutex.Lock()
+	responses, ok := dispatcher.waiters[uuid]
+	delete(dispatcher.waiters, uuid)
+	dispatcher.mutex.Unlock()
+
+	if ok {
+		responses <- data
+	}
+
+	return ok
+}
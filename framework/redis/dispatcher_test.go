@@ -0,0 +1,30 @@
+package redis
+
+import (
+	"testing"
+)
+
+func TestDispatcherReusedUUID(t *testing.T) {
+	dispatcher := newDispatcher()
+
+	first := dispatcher.wait("uuid")
+	dispatcher.dispatch("uuid", []byte("first"))
+
+	// The first request forgets its waiter once the second one waits
+	second := dispatcher.wait("uuid")
+	dispatcher.forget("uuid", first)
+
+	if !dispatcher.dispatch("uuid", []byte("second")) {
+		t.Fatal("The second request should still wait for its response")
+	}
+
+	if string(<-first) != "first" || string(<-second) != "second" {
+		t.Error("Each request should receive its own response")
+	}
+
+	dispatcher.forget("uuid", second)
+
+	if dispatcher.dispatch("uuid", []byte("late")) {
+		t.Error("Nobody should wait for a late response")
+	}
+}
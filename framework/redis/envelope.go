@@ -0,0 +1,196 @@
+package redis
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/codec"
+)
+
+const headerSizeLen = 4 // bytes
+
+// PROTOCOL_VERSION is the version of the envelope layout. Peers reject the
+// envelopes of a protocol newer than theirs.
+const PROTOCOL_VERSION = 1
+
+type requestHeader struct {
+	Protocol       int    `json:"protocol"`
+	Method         string `json:"method,omitempty"`
+	Version        int    `json:"version,omitempty"` // Schema version of the params
+	UUID           string `json:"uuid"`
+	Deadline       int64  `json:"deadline,omitempty"` // Unix nanoseconds, 0 means none
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	ReplyTo        string `json:"replyTo,omitempty"`
+	Token          string `json:"token,omitempty"`
+	TraceID        string `json:"traceId,omitempty"`
+}
+
+func newRequestHeader(uuid string, ctx context.Context) *requestHeader {
+	header := &requestHeader{
+		Protocol: PROTOCOL_VERSION,
+		UUID:     uuid,
+		TraceID:  TraceID(ctx),
+	}
+
+	// A request sent outside of any trace starts one
+	if header.TraceID == "" {
+		header.TraceID = uuid
+	}
+
+	deadline, ok := ctx.Deadline()
+	if ok {
+		header.Deadline = deadline.UnixNano()
+	}
+
+	return header
+}
+
+// replyTo is the address the response must be sent to, the PUBSUB channel
+// named after the request by default.
+func (header *requestHeader) replyTo() string {
+	if header.ReplyTo == "" {
+		return header.UUID
+	}
+
+	return header.ReplyTo
+}
+
+func (header *requestHeader) deadline() (time.Time, bool) {
+	if header.Deadline == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, header.Deadline), true
+}
+
+// check makes sure a request received on channel can be handled. Requests
+// sent before versioning have no method and are handled as is.
+func (header *requestHeader) check(channel Channel) error {
+	if header.Protocol > PROTOCOL_VERSION {
+		return NewError(UNSUPPORTED, "Protocol %d is not supported, %d at most",
+			header.Protocol, PROTOCOL_VERSION)
+	}
+
+	if header.Method != "" && Channel(header.Method).Version(header.Version) != channel {
+		return NewError(UNSUPPORTED, "Method %s version %d is not served on %s",
+			header.Method, header.Version, channel)
+	}
+
+	return nil
+}
+
+// context builds the context a handler of channel runs with, honouring the
+// caller's deadline when it sent one. It carries the Header of the request
+// and its trace so that the requests the handler sends belong to it.
+func (header *requestHeader) context(channel Channel) (context.Context, context.CancelFunc) {
+	deadline, _ := header.deadline()
+
+	ctx := context.WithValue(context.Background(), headerContextKey, &Header{
+		Channel:  channel,
+		UUID:     header.UUID,
+		Method:   header.Method,
+		Version:  header.Version,
+		Deadline: deadline,
+		Token:    header.Token,
+		TraceID:  header.TraceID,
+	})
+
+	ctx = WithTraceID(ctx, header.TraceID)
+
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, deadline)
+}
+
+// Header is the envelope of the request a handler is executing, as seen by
+// the middlewares.
+type Header struct {
+	Channel  Channel
+	UUID     string
+	Method   string
+	Version  int
+	Deadline time.Time // Zero when the caller set none
+	Token    string
+	TraceID  string
+}
+
+type contextKey int
+
+const (
+	headerContextKey contextKey = iota
+	traceContextKey
+)
+
+// HeaderFromContext returns the Header of the request handled with ctx.
+func HeaderFromContext(ctx context.Context) (*Header, bool) {
+	header, ok := ctx.Value(headerContextKey).(*Header)
+
+	return header, ok
+}
+
+// WithTraceID returns a copy of ctx in which the requests sent belong to the
+// trace traceID.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceContextKey, traceID)
+}
+
+// TraceID returns the trace ctx belongs to, empty if none.
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceContextKey).(string)
+
+	return traceID
+}
+
+type Status string
+
+const (
+	STATUS_OK    = Status("OK")
+	STATUS_ERROR = Status("ERROR")
+)
+
+type responseHeader struct {
+	Protocol int    `json:"protocol"`
+	UUID     string `json:"uuid"`
+	Status   Status `json:"status"`
+	Error    *Error `json:"error,omitempty"`
+}
+
+// frame prefixes body with its codec encoded header and the header length.
+func frame(codec codec.Codec, header interface{}, body []byte) ([]byte, error) {
+	data, err := codec.Encode(header)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, headerSizeLen, headerSizeLen+len(data)+len(body))
+	binary.BigEndian.PutUint32(raw, uint32(len(data)))
+
+	raw = append(raw, data...)
+	raw = append(raw, body...)
+
+	return raw, nil
+}
+
+// unframe decodes the header of a framed payload and returns its body.
+func unframe(codec codec.Codec, raw []byte, header interface{}) ([]byte, error) {
+	if len(raw) < headerSizeLen {
+		return nil, fmt.Errorf("Envelope too short: %d bytes", len(raw))
+	}
+
+	size := int(binary.BigEndian.Uint32(raw))
+	if len(raw) < headerSizeLen+size {
+		return nil, fmt.Errorf("Envelope header truncated: %d bytes expected, %d available",
+			size, len(raw)-headerSizeLen)
+	}
+
+	err := codec.Decode(raw[headerSizeLen:headerSizeLen+size], header)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw[headerSizeLen+size:], nil
+}
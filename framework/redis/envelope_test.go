@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"testing"
+)
+
+func TestRequestHeaderCheck(t *testing.T) {
+	tests := []struct {
+		header  *requestHeader
+		channel Channel
+		valid   bool
+	}{
+		{&requestHeader{}, LOGIN_SIGNUP, true},
+		{&requestHeader{Protocol: 1, Method: "login.signup", Version: 1}, LOGIN_SIGNUP, true},
+		{&requestHeader{Protocol: 1, Method: "login.signup", Version: 2}, "login.signup.v2", true},
+		{&requestHeader{Protocol: 1, Method: "login.signup", Version: 2}, LOGIN_SIGNUP, false},
+		{&requestHeader{Protocol: 1, Method: "login.signin", Version: 1}, LOGIN_SIGNUP, false},
+		{&requestHeader{Protocol: PROTOCOL_VERSION + 1}, LOGIN_SIGNUP, false},
+	}
+
+	for _, test := range tests {
+		err := test.header.check(test.channel)
+
+		if test.valid && err != nil {
+			t.Errorf("check(%+v, %s) failed: %s", test.header, test.channel, err)
+		}
+
+		if !test.valid && (err == nil || AsError(err).Code != UNSUPPORTED) {
+			t.Errorf("check(%+v, %s) should fail with UNSUPPORTED, got %v",
+				test.header, test.channel, err)
+		}
+	}
+}
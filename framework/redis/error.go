@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorCode string
+
+const (
+	INTERNAL       = ErrorCode("INTERNAL")
+	INVALID_PARAMS = ErrorCode("INVALID_PARAMS")
+	NOT_FOUND      = ErrorCode("NOT_FOUND")
+	INVALID_TOKEN  = ErrorCode("INVALID_TOKEN")
+	CONFLICT       = ErrorCode("CONFLICT")
+	UNSUPPORTED    = ErrorCode("UNSUPPORTED")
+)
+
+// Error is the failure a handler sends back to the caller of a Request.
+type Error struct {
+	Code    ErrorCode              `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.Code, err.Message)
+}
+
+// WithDetails returns a copy of err carrying details, leaving err untouched.
+func (err *Error) WithDetails(details map[string]interface{}) *Error {
+	return &Error{
+		Code:    err.Code,
+		Message: err.Message,
+		Details: details,
+	}
+}
+
+// AsError returns the Error wrapped in err, or an INTERNAL one not disclosing
+// its message, which may come from a driver or a database.
+func AsError(err error) *Error {
+	var result *Error
+
+	if errors.As(err, &result) {
+		return result
+	}
+
+	return &Error{Code: INTERNAL, Message: "internal error"}
+}
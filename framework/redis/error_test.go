@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorWithDetails(t *testing.T) {
+	err := NewError(INVALID_PARAMS, "invalid %s", "email")
+	details := map[string]interface{}{"field": "email"}
+
+	result := err.WithDetails(details)
+
+	if result == err {
+		t.Fatal("WithDetails should return a copy")
+	}
+
+	if err.Details != nil {
+		t.Errorf("WithDetails should not change the receiver, got %v", err.Details)
+	}
+
+	if result.Code != err.Code || result.Message != err.Message ||
+		result.Details["field"] != "email" {
+
+		t.Errorf("WithDetails should keep code and message and set details, got %#v", result)
+	}
+}
+
+func TestAsError(t *testing.T) {
+	failure := NewError(NOT_FOUND, "no such user")
+
+	err := AsError(fmt.Errorf("Find failed: %w", failure))
+	if err != failure {
+		t.Errorf("AsError should return the wrapped Error, got %#v", err)
+	}
+
+	err = AsError(errors.New(`pq: relation "users" does not exist`))
+	if err.Code != INTERNAL || err.Message != "internal error" {
+		t.Errorf("AsError should hide the message of other errors, got %#v", err)
+	}
+}
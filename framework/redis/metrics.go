@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"time"
+)
+
+// Metrics receives the measures taken by the workers of a Client.
+type Metrics interface {
+	// QueueDepth is called on every heartbeat with the number of requests
+	// waiting on channel.
+	QueueDepth(channel Channel, depth int)
+
+	// HandlerLatency is called once a request of channel was answered.
+	HandlerLatency(channel Channel, latency time.Duration, status Status)
+}
+
+type noOpMetrics struct{}
+
+func (metrics noOpMetrics) QueueDepth(channel Channel, depth int) {}
+
+func (metrics noOpMetrics) HandlerLatency(
+	channel Channel,
+	latency time.Duration,
+	status Status) {
+}
+
+var NoOpMetrics Metrics = noOpMetrics{}
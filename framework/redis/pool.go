@@ -0,0 +1,189 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// pool hands out connections to the node serving a key.
+type pool interface {
+	Get(key string) redis.Conn
+	Close() error
+}
+
+// nodePool serves every key from a single node.
+type nodePool struct {
+	*redis.Pool
+}
+
+func (pool nodePool) Get(key string) redis.Conn {
+	return pool.Pool.Get()
+}
+
+func newPool(config Config) (pool, error) {
+	switch {
+	case config.Dial != nil:
+		return nodePool{Pool: newRedisPool(config, config.Dial)}, nil
+
+	case len(config.ClusterAddresses) != 0 && config.SentinelMaster != "":
+		return nil, fmt.Errorf("REDIS Sentinel and Cluster cannot be used together")
+
+	case len(config.ClusterAddresses) != 0:
+		// Cluster nodes only have the database 0
+		if config.Database != 0 {
+			return nil, fmt.Errorf("REDIS Cluster does not support database %d",
+				config.Database)
+		}
+
+		return newClusterPool(config)
+
+	case config.SentinelMaster != "":
+		if len(config.SentinelAddresses) == 0 {
+			return nil, fmt.Errorf("REDIS Sentinel master %s has no Sentinel address",
+				config.SentinelMaster)
+		}
+
+		redisPool := newRedisPool(config, sentinelDial(config))
+
+		// The master may have been demoted by a failover since the
+		// connection was last used
+		redisPool.TestOnBorrow = func(conn redis.Conn, used time.Time) error {
+			if time.Since(used) < time.Second {
+				return nil
+			}
+
+			return checkMaster(conn)
+		}
+
+		return nodePool{Pool: redisPool}, nil
+	}
+
+	options := dialOptions(config)
+
+	return nodePool{Pool: newRedisPool(config, func() (redis.Conn, error) {
+		return redis.Dial("tcp", config.Address, options...)
+	})}, nil
+}
+
+func newRedisPool(config Config, dial func() (redis.Conn, error)) *redis.Pool {
+	return &redis.Pool{
+		MaxActive:       config.MaxActive,
+		MaxIdle:         config.MaxIdle,
+		IdleTimeout:     config.IdleTimeout,
+		MaxConnLifetime: config.MaxConnLifetime,
+		Wait:            true,
+		Dial:            dial,
+	}
+}
+
+func dialOptions(config Config) []redis.DialOption {
+	options := []redis.DialOption{redis.DialDatabase(config.Database)}
+
+	if config.Username != "" {
+		options = append(options, redis.DialUsername(config.Username))
+	}
+
+	if config.Password != "" {
+		options = append(options, redis.DialPassword(config.Password))
+	}
+
+	return append(options, tlsOptions(config)...)
+}
+
+func tlsOptions(config Config) []redis.DialOption {
+	if !config.TLS {
+		return nil
+	}
+
+	return []redis.DialOption{
+		redis.DialUseTLS(true),
+		redis.DialTLSConfig(config.TLSConfig),
+	}
+}
+
+// sentinelDial connects to the master the first reachable Sentinel knows of.
+func sentinelDial(config Config) func() (redis.Conn, error) {
+	options := dialOptions(config)
+
+	sentinelOptions := tlsOptions(config)
+	if config.SentinelPassword != "" {
+		sentinelOptions = append(sentinelOptions, redis.DialPassword(config.SentinelPassword))
+	}
+
+	return func() (redis.Conn, error) {
+		var failure error
+
+		for _, sentinel := range config.SentinelAddresses {
+			address, err := masterAddress(sentinel, config.SentinelMaster, sentinelOptions)
+			if err != nil {
+				failure = err
+				continue
+			}
+
+			conn, err := redis.Dial("tcp", address, options...)
+			if err != nil {
+				failure = err
+				continue
+			}
+
+			// Sentinel may not have noticed a failover yet
+			err = checkMaster(conn)
+			if err != nil {
+				conn.Close()
+				failure = err
+				continue
+			}
+
+			return conn, nil
+		}
+
+		return nil, fmt.Errorf("No REDIS master %s found: %w", config.SentinelMaster, failure)
+	}
+}
+
+func masterAddress(
+	sentinel, master string,
+	options []redis.DialOption) (string, error) {
+
+	conn, err := redis.Dial("tcp", sentinel, options...)
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	fields, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", master))
+	if err != nil {
+		return "", err
+	}
+
+	if len(fields) != 2 {
+		return "", fmt.Errorf("Sentinel %s does not know master %s", sentinel, master)
+	}
+
+	return fmt.Sprintf("%s:%s", fields[0], fields[1]), nil
+}
+
+func checkMaster(conn redis.Conn) error {
+	fields, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+
+	if len(fields) == 0 {
+		return fmt.Errorf("REDIS ROLE returned nothing")
+	}
+
+	role, err := redis.String(fields[0], nil)
+	if err != nil {
+		return err
+	}
+
+	if role != "master" {
+		return fmt.Errorf("REDIS node is a %s, not a master", role)
+	}
+
+	return nil
+}
@@ -0,0 +1,803 @@
+// Package redistest provides an in-memory REDIS to test the packages using
+// framework/redis hermetically.
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var memoryConnClosedErr = errors.New("Memory REDIS connection closed")
+
+// MemoryServer is an in-process stand-in for REDIS. It implements the keys,
+// lists, sets, transactions and PUBSUB commands the Client relies on, so
+// that it can be tested hermetically through Config.Dial. Streams and Lua
+// scripts are not supported, the code relying on them is tested against a
+// real REDIS.
+type MemoryServer struct {
+	mutex    *sync.Mutex
+	changed  *sync.Cond // Wakes up blocked commands and receivers
+	values   map[string]*memoryValue
+	versions map[string]int
+	conns    map[*memoryConn]bool
+}
+
+type memoryValue struct {
+	data    []byte
+	list    [][]byte
+	set     map[string]bool
+	expires time.Time
+}
+
+func NewMemoryServer() *MemoryServer {
+	mutex := &sync.Mutex{}
+
+	return &MemoryServer{
+		mutex:    mutex,
+		changed:  sync.NewCond(mutex),
+		values:   map[string]*memoryValue{},
+		versions: map[string]int{},
+		conns:    map[*memoryConn]bool{},
+	}
+}
+
+// Dial opens a connection to server.
+func (server *MemoryServer) Dial() (redis.Conn, error) {
+	conn := &memoryConn{
+		server:   server,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		watched:  map[string]int{},
+	}
+
+	server.mutex.Lock()
+	server.conns[conn] = true
+	server.mutex.Unlock()
+
+	return conn, nil
+}
+
+// Disconnect drops every open connection, as a server restart would.
+func (server *MemoryServer) Disconnect() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for conn := range server.conns {
+		conn.closed = true
+	}
+
+	server.conns = map[*memoryConn]bool{}
+	server.changed.Broadcast()
+}
+
+// value returns the value of key, nil if it does not exist or expired.
+func (server *MemoryServer) value(key string) *memoryValue {
+	value, ok := server.values[key]
+	if !ok {
+		return nil
+	}
+
+	if !value.expires.IsZero() && !time.Now().Before(value.expires) {
+		server.delete(key)
+
+		return nil
+	}
+
+	return value
+}
+
+// touch marks key as modified for the connections watching it.
+func (server *MemoryServer) touch(key string) {
+	server.versions[key]++
+	server.changed.Broadcast()
+}
+
+func (server *MemoryServer) delete(key string) bool {
+	_, ok := server.values[key]
+	if ok {
+		delete(server.values, key)
+		server.touch(key)
+	}
+
+	return ok
+}
+
+func (server *MemoryServer) list(key string) ([][]byte, error) {
+	value := server.value(key)
+	if value == nil {
+		return nil, nil
+	}
+
+	if value.list == nil {
+		return nil, wrongTypeErr
+	}
+
+	return value.list, nil
+}
+
+// setList stores list under key, deleting it once empty as REDIS does.
+func (server *MemoryServer) setList(key string, list [][]byte) {
+	if len(list) == 0 {
+		server.delete(key)
+		return
+	}
+
+	value := server.value(key)
+	if value == nil {
+		value = &memoryValue{}
+		server.values[key] = value
+	}
+
+	value.list = list
+	server.touch(key)
+}
+
+func (server *MemoryServer) set(key string) (map[string]bool, error) {
+	value := server.value(key)
+	if value == nil {
+		return nil, nil
+	}
+
+	if value.set == nil {
+		return nil, wrongTypeErr
+	}
+
+	return value.set, nil
+}
+
+func (server *MemoryServer) publish(channel string, data []byte) int64 {
+	receivers := int64(0)
+
+	for conn := range server.conns {
+		if conn.channels[channel] {
+			conn.push([]interface{}{[]byte("message"), []byte(channel), data})
+			receivers++
+		}
+
+		for pattern := range conn.patterns {
+			matched, _ := path.Match(pattern, channel)
+			if matched {
+				conn.push([]interface{}{
+					[]byte("pmessage"), []byte(pattern), []byte(channel), data,
+				})
+
+				receivers++
+			}
+		}
+	}
+
+	return receivers
+}
+
+var wrongTypeErr = redis.Error(
+	"WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// memoryConn is a connection to a MemoryServer. Commands are run as soon as
+// they are sent and their replies queued until received.
+type memoryConn struct {
+	server   *MemoryServer
+	replies  []interface{}
+	closed   bool
+	channels map[string]bool
+	patterns map[string]bool
+	watched  map[string]int
+	queued   [][]interface{} // Commands of the current MULTI, nil outside
+}
+
+func (conn *memoryConn) Close() error {
+	conn.server.mutex.Lock()
+	defer conn.server.mutex.Unlock()
+
+	conn.closed = true
+	delete(conn.server.conns, conn)
+	conn.server.changed.Broadcast()
+
+	return nil
+}
+
+func (conn *memoryConn) Err() error {
+	conn.server.mutex.Lock()
+	defer conn.server.mutex.Unlock()
+
+	if conn.closed {
+		return memoryConnClosedErr
+	}
+
+	return nil
+}
+
+func (conn *memoryConn) Send(command string, args ...interface{}) error {
+	conn.server.mutex.Lock()
+	defer conn.server.mutex.Unlock()
+
+	if conn.closed {
+		return memoryConnClosedErr
+	}
+
+	conn.run(command, args)
+
+	return nil
+}
+
+func (conn *memoryConn) Flush() error {
+	return conn.Err()
+}
+
+func (conn *memoryConn) Receive() (interface{}, error) {
+	conn.server.mutex.Lock()
+	defer conn.server.mutex.Unlock()
+
+	for len(conn.replies) == 0 && !conn.closed {
+		conn.server.changed.Wait()
+	}
+
+	if len(conn.replies) == 0 {
+		return nil, memoryConnClosedErr
+	}
+
+	reply := conn.replies[0]
+	conn.replies = conn.replies[1:]
+
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// Do runs command and returns its reply along with the first error of the
+// replies still queued, like redigo does.
+func (conn *memoryConn) Do(command string, args ...interface{}) (interface{}, error) {
+	conn.server.mutex.Lock()
+	defer conn.server.mutex.Unlock()
+
+	if conn.closed {
+		return nil, memoryConnClosedErr
+	}
+
+	if command != "" {
+		conn.run(command, args)
+	}
+
+	replies := conn.replies
+	conn.replies = nil
+
+	if command == "" {
+		if len(replies) == 0 {
+			return nil, nil
+		}
+
+		return replies, nil
+	}
+
+	var err error
+
+	for _, reply := range replies {
+		failure, ok := reply.(redis.Error)
+		if ok && err == nil {
+			err = failure
+		}
+	}
+
+	return replies[len(replies)-1], err
+}
+
+func (conn *memoryConn) push(reply interface{}) {
+	conn.replies = append(conn.replies, reply)
+	conn.server.changed.Broadcast()
+}
+
+// run executes command with the server mutex locked and queues its replies.
+func (conn *memoryConn) run(command string, values []interface{}) {
+	command = strings.ToUpper(command)
+
+	args := make([]string, len(values))
+	for index, value := range values {
+		args[index] = memoryArg(value)
+	}
+
+	switch command {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		conn.subscribe(command, args)
+
+	case "MULTI":
+		conn.queued = [][]interface{}{}
+		conn.push("OK")
+
+	case "EXEC":
+		conn.push(conn.exec())
+
+	case "DISCARD":
+		conn.queued = nil
+		conn.watched = map[string]int{}
+		conn.push("OK")
+
+	default:
+		if conn.queued != nil {
+			conn.queued = append(conn.queued, append([]interface{}{command}, values...))
+			conn.push("QUEUED")
+			return
+		}
+
+		conn.push(conn.command(command, args))
+	}
+}
+
+func (conn *memoryConn) exec() interface{} {
+	if conn.queued == nil {
+		return redis.Error("ERR EXEC without MULTI")
+	}
+
+	queued := conn.queued
+	conn.queued = nil
+
+	watched := conn.watched
+	conn.watched = map[string]int{}
+
+	for key, version := range watched {
+		conn.server.value(key) // Expires key if needed
+
+		if conn.server.versions[key] != version {
+			return nil
+		}
+	}
+
+	replies := make([]interface{}, len(queued))
+
+	for index, queued := range queued {
+		args := make([]string, len(queued)-1)
+		for position, value := range queued[1:] {
+			args[position] = memoryArg(value)
+		}
+
+		replies[index] = conn.command(queued[0].(string), args)
+	}
+
+	return replies
+}
+
+func (conn *memoryConn) subscribe(command string, args []string) {
+	set, kind := conn.channels, strings.ToLower(command)
+	if strings.HasPrefix(command, "P") {
+		set = conn.patterns
+	}
+
+	subscribe := !strings.Contains(command, "UNSUBSCRIBE")
+
+	// Unsubscribing from nothing means from everything
+	if !subscribe && len(args) == 0 {
+		for channel := range set {
+			args = append(args, channel)
+		}
+
+		if len(args) == 0 {
+			conn.push([]interface{}{[]byte(kind), nil, conn.subscriptions()})
+			return
+		}
+	}
+
+	for _, channel := range args {
+		if subscribe {
+			set[channel] = true
+		} else {
+			delete(set, channel)
+		}
+
+		conn.push([]interface{}{[]byte(kind), []byte(channel), conn.subscriptions()})
+	}
+}
+
+func (conn *memoryConn) subscriptions() int64 {
+	return int64(len(conn.channels) + len(conn.patterns))
+}
+
+// command runs a command which is not about PUBSUB or transactions.
+func (conn *memoryConn) command(command string, args []string) interface{} {
+	server := conn.server
+
+	switch command {
+	case "PING":
+		if conn.subscriptions() != 0 {
+			data := ""
+			if len(args) != 0 {
+				data = args[0]
+			}
+
+			return []interface{}{[]byte("pong"), []byte(data)}
+		}
+
+		if len(args) != 0 {
+			return []byte(args[0])
+		}
+
+		return "PONG"
+
+	case "ECHO":
+		if len(args) != 1 {
+			return arityErr(command)
+		}
+
+		return []byte(args[0])
+
+	case "WATCH":
+		for _, key := range args {
+			server.value(key)
+			conn.watched[key] = server.versions[key]
+		}
+
+		return "OK"
+
+	case "UNWATCH":
+		conn.watched = map[string]int{}
+
+		return "OK"
+
+	case "GET":
+		if len(args) != 1 {
+			return arityErr(command)
+		}
+
+		value := server.value(args[0])
+		if value == nil {
+			return nil
+		}
+
+		if value.data == nil {
+			return wrongTypeErr
+		}
+
+		return value.data
+
+	case "SET":
+		if len(args) < 2 {
+			return arityErr(command)
+		}
+
+		value := &memoryValue{data: []byte(args[1])}
+
+		for index := 2; index < len(args); index++ {
+			option := strings.ToUpper(args[index])
+			if (option != "PX" && option != "EX") || index+1 == len(args) {
+				return redis.Error("ERR syntax error")
+			}
+
+			duration, err := strconv.ParseInt(args[index+1], 10, 64)
+			if err != nil || duration <= 0 {
+				return redis.Error("ERR invalid expire time in 'set' command")
+			}
+
+			unit := time.Millisecond
+			if option == "EX" {
+				unit = time.Second
+			}
+
+			value.expires = time.Now().Add(time.Duration(duration) * unit)
+			index++
+		}
+
+		server.values[args[0]] = value
+		server.touch(args[0])
+
+		return "OK"
+
+	case "DEL":
+		count := int64(0)
+
+		for _, key := range args {
+			server.value(key)
+
+			if server.delete(key) {
+				count++
+			}
+		}
+
+		return count
+
+	case "EXISTS":
+		count := int64(0)
+
+		for _, key := range args {
+			if server.value(key) != nil {
+				count++
+			}
+		}
+
+		return count
+
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return arityErr(command)
+		}
+
+		milliseconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return redis.Error("ERR value is not an integer or out of range")
+		}
+
+		value := server.value(args[0])
+		if value == nil {
+			return int64(0)
+		}
+
+		value.expires = time.Now().Add(time.Duration(milliseconds) * time.Millisecond)
+		server.touch(args[0])
+
+		return int64(1)
+
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return arityErr(command)
+		}
+
+		set, err := server.set(args[0])
+		if err != nil {
+			return err
+		}
+
+		if set == nil {
+			if command == "SREM" {
+				return int64(0)
+			}
+
+			set = map[string]bool{}
+			server.values[args[0]] = &memoryValue{set: set}
+		}
+
+		count := int64(0)
+
+		for _, member := range args[1:] {
+			if set[member] == (command == "SREM") {
+				count++
+			}
+
+			if command == "SADD" {
+				set[member] = true
+			} else {
+				delete(set, member)
+			}
+		}
+
+		if len(set) == 0 {
+			server.delete(args[0])
+		} else {
+			server.touch(args[0])
+		}
+
+		return count
+
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return arityErr(command)
+		}
+
+		set, err := server.set(args[0])
+		if err != nil {
+			return err
+		}
+
+		members := []interface{}{}
+		for member := range set {
+			members = append(members, []byte(member))
+		}
+
+		return members
+
+	case "LPUSH", "RPUSH":
+		if len(args) < 2 {
+			return arityErr(command)
+		}
+
+		list, err := server.list(args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, data := range args[1:] {
+			if command == "LPUSH" {
+				list = append([][]byte{[]byte(data)}, list...)
+			} else {
+				list = append(list, []byte(data))
+			}
+		}
+
+		server.setList(args[0], list)
+
+		return int64(len(list))
+
+	case "LPOP", "RPOP":
+		if len(args) != 1 {
+			return arityErr(command)
+		}
+
+		list, err := server.list(args[0])
+		if err != nil || len(list) == 0 {
+			return err
+		}
+
+		if command == "LPOP" {
+			server.setList(args[0], list[1:])
+
+			return list[0]
+		}
+
+		server.setList(args[0], list[:len(list)-1])
+
+		return list[len(list)-1]
+
+	case "LLEN":
+		if len(args) != 1 {
+			return arityErr(command)
+		}
+
+		list, err := server.list(args[0])
+		if err != nil {
+			return err
+		}
+
+		return int64(len(list))
+
+	case "LREM":
+		if len(args) != 3 {
+			return arityErr(command)
+		}
+
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return redis.Error("ERR value is not an integer or out of range")
+		}
+
+		list, failure := server.list(args[0])
+		if failure != nil {
+			return failure
+		}
+
+		return int64(server.remove(args[0], list, args[2], count))
+
+	case "RPOPLPUSH", "BRPOPLPUSH":
+		if len(args) != 2 && !(command == "BRPOPLPUSH" && len(args) == 3) {
+			return arityErr(command)
+		}
+
+		expired := false
+
+		if command == "BRPOPLPUSH" {
+			seconds, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || seconds < 0 {
+				return redis.Error("ERR timeout is not a float or out of range")
+			}
+
+			if seconds > 0 {
+				timer := time.AfterFunc(time.Duration(seconds*float64(time.Second)), func() {
+					server.mutex.Lock()
+					expired = true
+					server.changed.Broadcast()
+					server.mutex.Unlock()
+				})
+
+				defer timer.Stop()
+			}
+		}
+
+		return conn.popPush(args[0], args[1], command == "BRPOPLPUSH", &expired)
+
+	case "PUBLISH":
+		if len(args) != 2 {
+			return arityErr(command)
+		}
+
+		return server.publish(args[0], []byte(args[1]))
+
+	}
+
+	return redis.Error(fmt.Sprintf("ERR unknown command '%s'", command))
+}
+
+// popPush moves the last element of source to the head of destination,
+// waiting for one if block is set until expired is set.
+func (conn *memoryConn) popPush(
+	source, destination string,
+	block bool,
+	expired *bool) interface{} {
+
+	server := conn.server
+
+	for {
+		list, err := server.list(source)
+		if err != nil {
+			return err
+		}
+
+		if len(list) != 0 {
+			data := list[len(list)-1]
+			server.setList(source, list[:len(list)-1])
+
+			target, err := server.list(destination)
+			if err != nil {
+				return err
+			}
+
+			server.setList(destination, append([][]byte{data}, target...))
+
+			return data
+		}
+
+		if !block || conn.closed || *expired {
+			return nil
+		}
+
+		server.changed.Wait()
+	}
+}
+
+// remove deletes count occurrences of data from the list stored at key,
+// from the head when positive, from the tail when negative, all when 0.
+func (server *MemoryServer) remove(key string, list [][]byte, data string, count int) int {
+	removed := 0
+	result := make([][]byte, 0, len(list))
+
+	indexes := make([]int, len(list))
+	for index := range list {
+		indexes[index] = index
+		if count < 0 {
+			indexes[index] = len(list) - 1 - index
+		}
+	}
+
+	keep := make([]bool, len(list))
+
+	for _, index := range indexes {
+		keep[index] = true
+
+		if string(list[index]) == data && (count == 0 || removed < abs(count)) {
+			keep[index] = false
+			removed++
+		}
+	}
+
+	for index, element := range list {
+		if keep[index] {
+			result = append(result, element)
+		}
+	}
+
+	if removed != 0 {
+		server.setList(key, result)
+	}
+
+	return removed
+}
+
+func memoryArg(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case []byte:
+		return string(value)
+
+	case nil:
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+func arityErr(command string) redis.Error {
+	return redis.Error(fmt.Sprintf(
+		"ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
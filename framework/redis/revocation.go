@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Revocations is the set of the revoked token IDs, each one being a key
+// expiring with its token.
+type Revocations struct {
+	client *Client
+}
+
+func NewRevocations(client *Client) *Revocations {
+	return &Revocations{client: client}
+}
+
+func (revocations *Revocations) Revoke(
+	uuid string,
+	ctx context.Context,
+	id string,
+	expiresAt time.Time) error {
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	key := revocationKey(id)
+
+	conn := revocations.client.pool.Get(key)
+	defer conn.Close()
+
+	_, err := conn.Do("SET", key, 1, "PX", milliseconds(ttl))
+
+	revocations.client.logger(uuid, log.DEBUG, "Revocations.Revoke",
+		map[string]interface{}{"id": id, "ttl": ttl, "error": err})
+
+	return err
+}
+
+func (revocations *Revocations) IsRevoked(
+	uuid string,
+	ctx context.Context,
+	id string) (bool, error) {
+
+	key := revocationKey(id)
+
+	conn := revocations.client.pool.Get(key)
+	defer conn.Close()
+
+	return redis.Bool(conn.Do("EXISTS", key))
+}
+
+func revocationKey(id string) string {
+	return fmt.Sprintf("revoked:%s", id)
+}
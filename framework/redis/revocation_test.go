@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/framework/redis/redistest"
+)
+
+func TestRevocations(t *testing.T) {
+	client := newMemoryClient(t, redistest.NewMemoryServer())
+	defer client.Close()
+
+	revocations := NewRevocations(client)
+	uuid, ctx := entity.NewUUID(), context.Background()
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		wait      time.Duration
+		revoked   bool
+	}{
+		{"Revoked", time.Hour, 0, true},
+		{"Expired", -time.Hour, 0, false},
+		{"Expiring", 50 * time.Millisecond, 100 * time.Millisecond, false},
+	}
+
+	for _, test := range tests {
+		id := entity.NewUUID()
+
+		err := revocations.Revoke(uuid, ctx, id, time.Now().Add(test.expiresIn))
+		if err != nil {
+			t.Fatalf("%s: Revoke should not fail: %s", test.name, err)
+		}
+
+		time.Sleep(test.wait)
+
+		revoked, err := revocations.IsRevoked(uuid, ctx, id)
+		if err != nil {
+			t.Fatalf("%s: IsRevoked should not fail: %s", test.name, err)
+		}
+
+		if revoked != test.revoked {
+			t.Errorf("%s: IsRevoked should return %t", test.name, test.revoked)
+		}
+	}
+
+	revoked, err := revocations.IsRevoked(uuid, ctx, entity.NewUUID())
+	if err != nil || revoked {
+		t.Errorf("IsRevoked should return false for an unknown ID, got %t, %v", revoked, err)
+	}
+}
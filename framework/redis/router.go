@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+)
+
+// HandlerFunc executes a request once its params were decoded.
+type HandlerFunc func(uuid string, ctx context.Context, params interface{}) (interface{}, error)
+
+// Middleware wraps the handler of a channel.
+type Middleware func(channel Channel, next HandlerFunc) HandlerFunc
+
+// A listener failing repeatedly is restarted at most that often.
+const maxRestartDelay = time.Minute
+
+type route struct {
+	channel Channel
+	params  func() interface{}
+	handler HandlerFunc
+}
+
+type routeHandler struct {
+	params  interface{}
+	handler HandlerFunc
+}
+
+func (handler *routeHandler) Params() interface{} { return handler.params }
+
+func (handler *routeHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
+	return handler.handler(uuid, ctx, handler.params)
+}
+
+// Router handles the requests of several channels with the same Client.
+type Router struct {
+	client      *Client
+	routes      []*route
+	middlewares []Middleware
+}
+
+func NewRouter(client *Client) *Router {
+	return &Router{client: client}
+}
+
+// Use adds middlewares to every handler, the first one being the outermost.
+func (router *Router) Use(middlewares ...Middleware) {
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
+// Register handles the requests sent on channel with handler. params builds
+// the value the params of each request are decoded into.
+func (router *Router) Register(
+	channel Channel,
+	params func() interface{},
+	handler HandlerFunc) {
+
+	router.RegisterVersion(channel, 1, params, handler)
+}
+
+// RegisterVersion handles the requests sent on channel with the given schema
+// version, so that several versions of a method can be served side by side.
+func (router *Router) RegisterVersion(
+	channel Channel,
+	version int,
+	params func() interface{},
+	handler HandlerFunc) {
+
+	router.routes = append(router.routes, &route{
+		channel: channel.Version(version),
+		params:  params,
+		handler: handler,
+	})
+}
+
+// Serve handles the registered channels until ctx is done or the client is
+// closed, restarting the ones whose listener fails, and returns once they are
+// all drained.
+func (router *Router) Serve(ctx context.Context) error {
+	channels := map[Channel]bool{}
+
+	for _, route := range router.routes {
+		if channels[route.channel] {
+			return fmt.Errorf("Channel %s is registered more than once", route.channel)
+		}
+
+		channels[route.channel] = true
+	}
+
+	listeners := &sync.WaitGroup{}
+
+	for _, current := range router.routes {
+		listeners.Add(1)
+
+		go func(current *route) {
+			defer listeners.Done()
+
+			router.listen(ctx, current)
+		}(current)
+	}
+
+	listeners.Wait()
+
+	return nil
+}
+
+func (router *Router) listen(ctx context.Context, route *route) {
+	handler := route.handler
+
+	for index := len(router.middlewares) - 1; index >= 0; index-- {
+		handler = router.middlewares[index](route.channel, handler)
+	}
+
+	builder := func() Handler {
+		return &routeHandler{params: route.params(), handler: handler}
+	}
+
+	delay := router.client.heartbeat
+
+	for goOn := true; goOn; {
+		start := time.Now()
+		err := router.client.Handle(ctx, route.channel, builder)
+
+		if ctx.Err() != nil || router.client.isClosed() {
+			break
+		}
+
+		// A listener which ran for a while is restarted promptly
+		if time.Since(start) > maxRestartDelay {
+			delay = router.client.heartbeat
+		}
+
+		router.client.logger(router.client.id, log.ERROR,
+			"REDIS listener failed, restarting",
+			map[string]interface{}{
+				"channel": route.channel,
+				"error":   err,
+				"delay":   delay,
+			})
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			goOn = false
+		}
+
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// LoggingMiddleware logs every request handled along with its duration.
+func LoggingMiddleware(logger log.Logger) Middleware {
+	return func(channel Channel, next HandlerFunc) HandlerFunc {
+		return func(
+			uuid string,
+			ctx context.Context,
+			params interface{}) (interface{}, error) {
+
+			start := time.Now()
+			result, err := next(uuid, ctx, params)
+
+			level := log.INFO
+			if err != nil {
+				level = log.WARN
+			}
+
+			logger(uuid, level, "REDIS request handled",
+				map[string]interface{}{
+					"channel":  channel,
+					"duration": time.Since(start),
+					"error":    err,
+				})
+
+			return result, err
+		}
+	}
+}
+
+// AuthMiddleware rejects the requests of channels, every channel if none is
+// given, whose token is missing or refused by authenticate.
+func AuthMiddleware(
+	authenticate func(ctx context.Context, token string) error,
+	channels ...Channel) Middleware {
+
+	return func(channel Channel, next HandlerFunc) HandlerFunc {
+		if len(channels) != 0 && !containsChannel(channels, channel) {
+			return next
+		}
+
+		return func(
+			uuid string,
+			ctx context.Context,
+			params interface{}) (interface{}, error) {
+
+			header, ok := HeaderFromContext(ctx)
+			if !ok || header.Token == "" {
+				return nil, NewError(INVALID_TOKEN, "Request on %s has no token", channel)
+			}
+
+			err := authenticate(ctx, header.Token)
+			if err != nil {
+				var failure *Error
+
+				if errors.As(err, &failure) {
+					return nil, failure
+				}
+
+				return nil, NewError(INVALID_TOKEN, "Request on %s has an invalid token: %s",
+					channel, err)
+			}
+
+			return next(uuid, ctx, params)
+		}
+	}
+}
+
+func containsChannel(channels []Channel, channel Channel) bool {
+	for _, current := range channels {
+		if current == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TracingMiddleware logs a span per request handled, tied to the trace it
+// belongs to along with the requests sent while handling it.
+func TracingMiddleware(logger log.Logger) Middleware {
+	return func(channel Channel, next HandlerFunc) HandlerFunc {
+		return func(
+			uuid string,
+			ctx context.Context,
+			params interface{}) (interface{}, error) {
+
+			start := time.Now()
+			result, err := next(uuid, ctx, params)
+
+			span := map[string]interface{}{
+				"channel":  channel,
+				"traceId":  TraceID(ctx),
+				"start":    start,
+				"duration": time.Since(start),
+				"error":    err,
+			}
+
+			header, ok := HeaderFromContext(ctx)
+			if ok {
+				span["method"] = header.Method
+				span["version"] = header.Version
+
+				if !header.Deadline.IsZero() {
+					span["deadline"] = header.Deadline
+				}
+			}
+
+			logger(uuid, log.DEBUG, "REDIS span", span)
+
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/log"
+	"github.com/kukinsula/boxy/framework/redis/redistest"
+)
+
+func echoParamsBuilder() interface{} { return &echoParams{} }
+
+func echoHandlerFunc(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+	return params, nil
+}
+
+// serveRouter serves router until the returned function is called.
+func serveRouter(t *testing.T, router *Router) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- router.Serve(ctx)
+	}()
+
+	return func() {
+		cancel()
+
+		err := <-done
+		if err != nil {
+			t.Errorf("Serve failed: %s", err)
+		}
+	}
+}
+
+func TestServeRejectsDuplicateChannels(t *testing.T) {
+	router := NewRouter(&Client{})
+
+	router.Register("test", echoParamsBuilder, echoHandlerFunc)
+	router.Register("test", echoParamsBuilder, echoHandlerFunc)
+
+	err := router.Serve(context.Background())
+	if err == nil {
+		t.Errorf("Serve should fail when a channel is registered twice")
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer worker.Close()
+	defer caller.Close()
+
+	calls := []string{}
+	var header *Header
+
+	trace := func(name string) Middleware {
+		return func(channel Channel, next HandlerFunc) HandlerFunc {
+			return func(
+				uuid string,
+				ctx context.Context,
+				params interface{}) (interface{}, error) {
+
+				calls = append(calls, name)
+				result, err := next(uuid, ctx, params)
+				calls = append(calls, name)
+
+				return result, err
+			}
+		}
+	}
+
+	router := NewRouter(worker)
+	router.Use(trace("outer"), trace("inner"))
+	router.Register("test", echoParamsBuilder,
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			calls = append(calls, "handler")
+			header, _ = HeaderFromContext(ctx)
+
+			return params, nil
+		})
+
+	defer serveRouter(t, router)()
+
+	uuid := entity.NewUUID()
+	err := caller.Request(&Request{
+		UUID:    uuid,
+		Context: WithTraceID(context.Background(), "trace"),
+		Channel: "test",
+		Timeout: time.Second,
+		Token:   "token",
+		Params:  &echoParams{Value: "foo"},
+	}).Error
+
+	if err != nil {
+		t.Errorf("Request failed: %s", err)
+		t.FailNow()
+	}
+
+	expected := []string{"outer", "inner", "handler", "inner", "outer"}
+
+	if len(calls) != len(expected) {
+		t.Errorf("Middlewares should be called in order %v, got %v", expected, calls)
+		t.FailNow()
+	}
+
+	for index := range expected {
+		if calls[index] != expected[index] {
+			t.Errorf("Middlewares should be called in order %v, got %v", expected, calls)
+			break
+		}
+	}
+
+	if header == nil {
+		t.Error("Handler should find the request Header in its context")
+		t.FailNow()
+	}
+
+	if header.Channel != "test" || header.UUID != uuid || header.Token != "token" ||
+		header.TraceID != "trace" || header.Deadline.IsZero() {
+
+		t.Errorf("Header should carry the channel, UUID, token, trace and deadline, got %+v",
+			header)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer worker.Close()
+	defer caller.Close()
+
+	authenticate := func(ctx context.Context, token string) error {
+		if token != "secret" {
+			return errors.New("unknown token")
+		}
+
+		return nil
+	}
+
+	router := NewRouter(worker)
+	router.Use(AuthMiddleware(authenticate, "private"))
+	router.Register("private", echoParamsBuilder, echoHandlerFunc)
+	router.Register("public", echoParamsBuilder, echoHandlerFunc)
+
+	defer serveRouter(t, router)()
+
+	tests := []struct {
+		channel Channel
+		token   string
+		code    ErrorCode
+	}{
+		{"private", "", INVALID_TOKEN},
+		{"private", "wrong", INVALID_TOKEN},
+		{"private", "secret", ""},
+		{"public", "", ""},
+	}
+
+	for _, test := range tests {
+		err := caller.Request(&Request{
+			UUID:    entity.NewUUID(),
+			Channel: test.channel,
+			Token:   test.token,
+			Params:  &echoParams{},
+		}).Error
+
+		if test.code == "" && err != nil {
+			t.Errorf("Request on %s with token %q failed: %s", test.channel, test.token, err)
+		}
+
+		if test.code != "" && (err == nil || AsError(err).Code != test.code) {
+			t.Errorf("Request on %s with token %q should fail with %s, got %v",
+				test.channel, test.token, test.code, err)
+		}
+	}
+}
+
+func TestServeRestartsListeners(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer worker.Close()
+	defer caller.Close()
+
+	mutex := &sync.Mutex{}
+	restarts := 0
+
+	worker.logger = func(
+		uuid string,
+		level log.Level,
+		message string,
+		data map[string]interface{}) error {
+
+		if message == "REDIS listener failed, restarting" {
+			mutex.Lock()
+			restarts++
+			mutex.Unlock()
+		}
+
+		return nil
+	}
+
+	router := NewRouter(worker)
+	router.Register("test", echoParamsBuilder, echoHandlerFunc)
+
+	defer serveRouter(t, router)()
+
+	// Wait for the listener to pop before killing its connection
+	time.Sleep(2 * worker.heartbeat)
+	server.Disconnect()
+
+	restarted := eventually(time.Second, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return restarts != 0
+	})
+
+	if !restarted {
+		t.Error("Serve should restart the failed listener")
+	}
+
+	result := &echoParams{}
+	err := caller.Request(&Request{
+		UUID:    entity.NewUUID(),
+		Channel: "test",
+		Timeout: time.Second,
+		Params:  &echoParams{Value: "foo"},
+	}).Decode(result)
+
+	if err != nil || result.Value != "foo" {
+		t.Errorf("The restarted listener should answer, got %v (%v)", result, err)
+	}
+}
+
+func TestRegisterVersion(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	worker, caller := newMemoryClient(t, server), newMemoryClient(t, server)
+	defer worker.Close()
+	defer caller.Close()
+
+	versioned := func(version string) HandlerFunc {
+		return func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			return &echoParams{Value: version}, nil
+		}
+	}
+
+	router := NewRouter(worker)
+	router.Register("test", echoParamsBuilder, versioned("v1"))
+	router.RegisterVersion("test", 2, echoParamsBuilder, versioned("v2"))
+
+	defer serveRouter(t, router)()
+
+	tests := []struct {
+		version  int
+		expected string
+	}{
+		{0, "v1"}, // Clients deployed before versioning
+		{1, "v1"},
+		{2, "v2"},
+	}
+
+	for _, test := range tests {
+		result := &echoParams{}
+		err := caller.Request(&Request{
+			UUID:    entity.NewUUID(),
+			Channel: "test",
+			Version: test.version,
+			Params:  &echoParams{},
+		}).Decode(result)
+
+		if err != nil || result.Value != test.expected {
+			t.Errorf("Request version %d should reach the %s handler, got %s (%v)",
+				test.version, test.expected, result.Value, err)
+		}
+	}
+
+	err := caller.Request(&Request{
+		UUID:    entity.NewUUID(),
+		Channel: "test",
+		Version: 3,
+		Timeout: 5 * worker.heartbeat,
+		Params:  &echoParams{},
+	}).Error
+
+	var timeout *TimeoutError
+
+	if !errors.As(err, &timeout) {
+		t.Errorf("Request version 3 should not be served, got %v", err)
+	}
+}
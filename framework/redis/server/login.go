@@ -2,139 +2,98 @@ package server
 
 import (
 	"context"
+	"errors"
 
 	redisFramework "github.com/kukinsula/boxy/framework/redis"
 	loginUsecase "github.com/kukinsula/boxy/usecase/login"
 )
 
-// Signup
-
-type signupHandler struct {
-	login  *loginUsecase.Login
-	params *loginUsecase.CreateUserParams
-}
-
-func (handler *signupHandler) Params() interface{} { return handler.params }
-
-func (handler *signupHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
-	return handler.login.Signup(uuid, ctx, handler.params)
-}
-
-func HandleSignup(
-	client *redisFramework.Client,
-	login *loginUsecase.Login) error {
-
-	return client.Handle(redisFramework.LOGIN_SIGNUP, func() redisFramework.Handler {
-		return &signupHandler{login: login, params: &loginUsecase.CreateUserParams{}}
-	})
-}
-
-// CheckActivate
-
-type checkActivateHandler struct {
-	login  *loginUsecase.Login
-	params *loginUsecase.EmailAndTokenParams
-}
-
-func (handler *checkActivateHandler) Params() interface{} { return handler.params }
-
-func (handler *checkActivateHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
-	return nil, handler.login.CheckActivate(uuid, ctx, handler.params)
-}
-
-func HandleCheckActivate(
-	client *redisFramework.Client,
-	login *loginUsecase.Login) error {
-
-	return client.Handle(redisFramework.LOGIN_CHECK_ACTIVATE, func() redisFramework.Handler {
-		return &checkActivateHandler{login: login, params: &loginUsecase.EmailAndTokenParams{}}
-	})
-}
-
-// Activate
-
-type activateHandler struct {
-	login  *loginUsecase.Login
-	params *loginUsecase.EmailAndTokenParams
-}
-
-func (handler *activateHandler) Params() interface{} { return handler.params }
-
-func (handler *activateHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
-	return nil, handler.login.Activate(uuid, ctx, handler.params)
-}
-
-func HandleActivate(
-	client *redisFramework.Client,
-	login *loginUsecase.Login) error {
-
-	return client.Handle(redisFramework.LOGIN_ACTIVATE, func() redisFramework.Handler {
-		return &activateHandler{login: login, params: &loginUsecase.EmailAndTokenParams{}}
-	})
-}
-
-// Signin
-
-type signinHandler struct {
-	login  *loginUsecase.Login
-	params *loginUsecase.SigninParams
-}
-
-func (handler *signinHandler) Params() interface{} { return handler.params }
-
-func (handler *signinHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
-	return handler.login.Signin(uuid, ctx, handler.params)
-}
-
-func HandleSignin(
-	client *redisFramework.Client,
-	login *loginUsecase.Login) error {
-
-	return client.Handle(redisFramework.LOGIN_SIGNIN, func() redisFramework.Handler {
-		return &signinHandler{login: login, params: &loginUsecase.SigninParams{}}
-	})
-}
-
-// Me
-
-type meHandler struct {
-	login  *loginUsecase.Login
-	params *loginUsecase.AccessTokenParams
-}
-
-func (handler *meHandler) Params() interface{} { return handler.params }
-
-func (handler *meHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
-	return handler.login.Me(uuid, ctx, handler.params)
-}
-
-func HandleMe(
-	client *redisFramework.Client,
-	login *loginUsecase.Login) error {
-
-	return client.Handle(redisFramework.LOGIN_ME, func() redisFramework.Handler {
-		return &meHandler{login: login, params: &loginUsecase.AccessTokenParams{}}
-	})
-}
-
-// Logout
-
-type logoutHandler struct {
-	login  *loginUsecase.Login
-	params *loginUsecase.AccessTokenParams
-}
-
-func (handler *logoutHandler) Params() interface{} { return handler.params }
-
-func (handler *logoutHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
-	return nil, handler.login.Logout(uuid, ctx, handler.params)
-}
-
-func HandleLogout(
-	client *redisFramework.Client,
-	login *loginUsecase.Login) error {
-
-	return client.Handle(redisFramework.LOGIN_LOGOUT, func() redisFramework.Handler {
-		return &logoutHandler{login: login, params: &loginUsecase.AccessTokenParams{}}
-	})
+// loginError converts Login failures into errors the caller can switch on,
+// with their details.
+func loginError(err error) error {
+	var failure *loginUsecase.Error
+
+	if err == nil || !errors.As(err, &failure) {
+		return err
+	}
+
+	return redisFramework.NewError(redisFramework.ErrorCode(failure.Code),
+		"%s", err).WithDetails(failure.Details)
+}
+
+// RegisterLogin handles the Login requests with router.
+func RegisterLogin(router *redisFramework.Router, login *loginUsecase.Login) {
+	router.Register(redisFramework.LOGIN_SIGNUP,
+		func() interface{} { return &loginUsecase.CreateUserParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			user, err := login.Signup(uuid, ctx, params.(*loginUsecase.CreateUserParams))
+
+			return user, loginError(err)
+		})
+
+	router.Register(redisFramework.LOGIN_CHECK_ACTIVATE,
+		func() interface{} { return &loginUsecase.EmailAndTokenParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			return nil, loginError(login.CheckActivate(uuid, ctx,
+				params.(*loginUsecase.EmailAndTokenParams)))
+		})
+
+	router.Register(redisFramework.LOGIN_ACTIVATE,
+		func() interface{} { return &loginUsecase.EmailAndTokenParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			return nil, loginError(login.Activate(uuid, ctx,
+				params.(*loginUsecase.EmailAndTokenParams)))
+		})
+
+	router.Register(redisFramework.LOGIN_SIGNIN,
+		func() interface{} { return &loginUsecase.SigninParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			result, err := login.Signin(uuid, ctx, params.(*loginUsecase.SigninParams))
+
+			return result, loginError(err)
+		})
+
+	router.Register(redisFramework.LOGIN_REFRESH,
+		func() interface{} { return &loginUsecase.RefreshParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			result, err := login.Refresh(uuid, ctx, params.(*loginUsecase.RefreshParams))
+
+			return result, loginError(err)
+		})
+
+	router.Register(redisFramework.LOGIN_ME,
+		func() interface{} { return &loginUsecase.AccessTokenParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			result, err := login.Me(uuid, ctx, params.(*loginUsecase.AccessTokenParams))
+
+			return result, loginError(err)
+		})
+
+	router.Register(redisFramework.LOGIN_SESSIONS,
+		func() interface{} { return &loginUsecase.AccessTokenParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			sessions, err := login.Sessions(uuid, ctx, params.(*loginUsecase.AccessTokenParams))
+
+			return sessions, loginError(err)
+		})
+
+	router.Register(redisFramework.LOGIN_REVOKE_SESSION,
+		func() interface{} { return &loginUsecase.RevokeSessionParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			return nil, loginError(login.RevokeSession(uuid, ctx,
+				params.(*loginUsecase.RevokeSessionParams)))
+		})
+
+	router.Register(redisFramework.LOGIN_LOGOUT,
+		func() interface{} { return &loginUsecase.AccessTokenParams{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			return nil, loginError(login.Logout(uuid, ctx,
+				params.(*loginUsecase.AccessTokenParams)))
+		})
+
+	router.Register(redisFramework.LOGIN_JWKS,
+		func() interface{} { return &struct{}{} },
+		func(uuid string, ctx context.Context, params interface{}) (interface{}, error) {
+			return login.JWKS(uuid, ctx), nil
+		})
 }
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/codec"
+	"github.com/kukinsula/boxy/entity/log"
+	redisFramework "github.com/kukinsula/boxy/framework/redis"
+	redisClient "github.com/kukinsula/boxy/framework/redis/client"
+	"github.com/kukinsula/boxy/framework/redis/redistest"
+	"github.com/kukinsula/boxy/usecase"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+)
+
+// serveLogin serves the Login requests with a client built by newLogin until
+// the returned function is called.
+func serveLogin(
+	t *testing.T,
+	newLogin func(client *redisFramework.Client) *loginUsecase.Login) (*redisClient.Login, func()) {
+
+	client, err := redisFramework.NewClient(redisFramework.Config{
+		Dial:           redistest.NewMemoryServer().Dial,
+		MaxActive:      20,
+		RequestTimeout: time.Second,
+		Heartbeat:      50 * time.Millisecond,
+		Codec:          &codec.JSONCodec{},
+		Logger:         log.NoOpLogger,
+	})
+
+	if err != nil {
+		t.Errorf("NewClient failed: %s", err)
+		t.FailNow()
+	}
+
+	router := redisFramework.NewRouter(client)
+	RegisterLogin(router, newLogin(client))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+
+	go func() {
+		served <- router.Serve(ctx)
+	}()
+
+	return redisClient.NewLogin(client), func() {
+		cancel()
+		<-served
+		client.Close()
+	}
+}
+
+// expectCode checks that err carries code, or that it is nil if code is
+// empty.
+func expectCode(t *testing.T, operation string, err error, code string) {
+	t.Helper()
+
+	var failure *redisFramework.Error
+
+	if code == "" && err != nil {
+		t.Errorf("%s should not fail, got %s", operation, err)
+		return
+	}
+
+	if code != "" && (!errors.As(err, &failure) || string(failure.Code) != code) {
+		t.Errorf("%s should fail with %s, got %v", operation, code, err)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	// Invalid tokens are rejected before reaching the gateway
+	client, stop := serveLogin(t, func(client *redisFramework.Client) *loginUsecase.Login {
+		return loginUsecase.NewLogin(nil, nil, nil,
+			usecase.NewTokener("secret"), usecase.NewPassworder(4))
+	})
+
+	defer stop()
+
+	_, err := client.Me(entity.NewUUID(), context.Background(), "invalid")
+	expectCode(t, "Me", err, string(redisFramework.INVALID_TOKEN))
+
+	// So are weak passwords, with the rules they break
+	_, err = client.Signup(entity.NewUUID(), context.Background(),
+		&loginUsecase.CreateUserParams{Email: "titi@mail.io", Password: "titi"})
+
+	expectCode(t, "Signup", err, loginUsecase.WeakPasswordErr.Code)
+
+	var failure *redisFramework.Error
+
+	if !errors.As(err, &failure) {
+		t.FailNow()
+	}
+
+	violations, ok := failure.Details["violations"].([]interface{})
+	if !ok || len(violations) == 0 {
+		t.Errorf("Signup should detail the violations, got %v", failure.Details)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %s", err)
+	}
+
+	key, err := usecase.NewKey("test", private)
+	if err != nil {
+		t.Fatalf("NewKey failed: %s", err)
+	}
+
+	tokener, err := usecase.NewKeyTokener(key)
+	if err != nil {
+		t.Fatalf("NewKeyTokener failed: %s", err)
+	}
+
+	client, stop := serveLogin(t, func(client *redisFramework.Client) *loginUsecase.Login {
+		gateway := loginUsecase.NewLoginGatewayMock()
+
+		return loginUsecase.NewLogin(gateway, gateway, redisFramework.NewRevocations(client),
+			tokener, usecase.NewPassworder(4))
+	})
+
+	defer stop()
+
+	uuid, ctx := entity.NewUUID(), context.Background()
+	email, password := "titi@mail.io", "Azerty1234."
+
+	user, err := client.Signup(uuid, ctx, &loginUsecase.CreateUserParams{
+		Email:     email,
+		Password:  password,
+		FirstName: "Ti",
+		LastName:  "Ti",
+	})
+
+	expectCode(t, "Signup", err, "")
+
+	if user == nil || user.ActivationToken == "" {
+		t.Fatalf("Signup should return the activation token, got %v", user)
+	}
+
+	_, err = client.Signup(entity.NewUUID(), ctx, &loginUsecase.CreateUserParams{
+		Email:    email,
+		Password: password,
+	})
+
+	expectCode(t, "Signup twice", err, loginUsecase.EmailTakenErr.Code)
+
+	_, err = client.Signin(uuid, ctx, &loginUsecase.SigninParams{Email: email, Password: password})
+	expectCode(t, "Signin before activation", err, loginUsecase.WrongStateErr.Code)
+
+	activation := &loginUsecase.EmailAndTokenParams{Email: email, Token: user.ActivationToken}
+
+	err = client.CheckActivate(uuid, ctx, activation)
+	expectCode(t, "CheckActivate", err, "")
+
+	err = client.Activate(uuid, ctx, activation)
+	expectCode(t, "Activate", err, "")
+
+	err = client.Activate(uuid, ctx, activation)
+	expectCode(t, "Activate twice", err, loginUsecase.UserNotFoundErr.Code)
+
+	_, err = client.Signin(uuid, ctx, &loginUsecase.SigninParams{Email: email, Password: "Wrong1234."})
+	expectCode(t, "Signin with a wrong password", err, loginUsecase.InvalidCredentialsErr.Code)
+
+	signin, err := client.Signin(uuid, ctx, &loginUsecase.SigninParams{
+		Email:    email,
+		Password: password,
+		Device:   "test",
+	})
+
+	expectCode(t, "Signin", err, "")
+
+	if signin == nil || signin.AccessToken == "" || signin.RefreshToken == "" {
+		t.Fatalf("Signin should return an access and a refresh token, got %v", signin)
+	}
+
+	me, err := client.Me(uuid, ctx, signin.AccessToken)
+	expectCode(t, "Me", err, "")
+
+	if me == nil || me.Email != email {
+		t.Errorf("Me should return %s, got %v", email, me)
+	}
+
+	sessions, err := client.Sessions(uuid, ctx, signin.AccessToken)
+	expectCode(t, "Sessions", err, "")
+
+	if len(sessions) != 1 || sessions[0].UUID != signin.Session {
+		t.Errorf("Sessions should return the session %s, got %v", signin.Session, sessions)
+	}
+
+	refreshed, err := client.Refresh(uuid, ctx, &loginUsecase.RefreshParams{Token: signin.RefreshToken})
+	expectCode(t, "Refresh", err, "")
+
+	if refreshed == nil || refreshed.RefreshToken == signin.RefreshToken {
+		t.Fatalf("Refresh should rotate the refresh token, got %v", refreshed)
+	}
+
+	jwks, err := client.JWKS(uuid, ctx)
+	expectCode(t, "JWKS", err, "")
+
+	if jwks == nil || len(jwks.Keys) != 1 || jwks.Keys[0].ID != "test" {
+		t.Errorf("JWKS should publish the key test, got %v", jwks)
+	}
+
+	err = client.Logout(uuid, ctx, refreshed.AccessToken)
+	expectCode(t, "Logout", err, "")
+
+	_, err = client.Me(uuid, ctx, refreshed.AccessToken)
+	expectCode(t, "Me after Logout", err, loginUsecase.InvalidTokenErr.Code)
+
+	err = client.RevokeSession(uuid, ctx, refreshed.AccessToken, signin.Session)
+	expectCode(t, "RevokeSession after Logout", err, loginUsecase.InvalidTokenErr.Code)
+}
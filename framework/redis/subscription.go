@@ -2,17 +2,61 @@ package redis
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
 
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	stateEventsBuffer = 16
+
+	// Beyond, the connection is closed rather than waiting for the server
+	unsubscribeTimeout = time.Second
+)
+
+type SubscriptionState string
+
+const (
+	SUBSCRIBED   = SubscriptionState("SUBSCRIBED")
+	DISCONNECTED = SubscriptionState("DISCONNECTED")
+)
+
+// StateEvent reports a change of the connection of a Subscription. Once
+// disconnected, Attempt counts the consecutive failures and Delay is the time
+// waited before subscribing again.
+type StateEvent struct {
+	State   SubscriptionState
+	Attempt int
+	Delay   time.Duration
+	Error   error
+}
+
+// Message is a message published on Channel, received through Pattern when
+// it matched a pattern subscription.
+type Message struct {
+	Channel Channel
+	Pattern Channel
+	Data    []byte
+}
+
+// Subscription receives the messages published on a set of channels and
+// patterns over a single connection, reconnecting and subscribing again
+// whenever it drops. Subscribed is closed once first subscribed, Message and
+// State once Context is done. State events are dropped when nobody reads them.
 type Subscription struct {
 	Context    context.Context
-	channel    Channel
 	ping       time.Duration
 	Subscribed chan struct{}
-	Message    chan []byte
+	Message    chan Message
+	State      chan StateEvent
+	once       *sync.Once
+	mutex      *sync.Mutex
+	channels   map[Channel]bool
+	patterns   map[Channel]bool
+	pubsub     *redis.PubSubConn // Current connection, nil while disconnected
 }
 
 func NewSusbcription(
@@ -20,28 +64,172 @@ func NewSusbcription(
 	channel Channel,
 	ping time.Duration) *Subscription {
 
+	subscription := newSubscription(ctx, ping)
+	subscription.channels[channel] = true
+
+	return subscription
+}
+
+// NewPatternSubscription subscribes to the channels matching pattern, such
+// as "streaming.*".
+func NewPatternSubscription(
+	ctx context.Context,
+	pattern Channel,
+	ping time.Duration) *Subscription {
+
+	subscription := newSubscription(ctx, ping)
+	subscription.patterns[pattern] = true
+
+	return subscription
+}
+
+func newSubscription(ctx context.Context, ping time.Duration) *Subscription {
 	return &Subscription{
 		Context:    ctx,
-		channel:    channel,
 		ping:       ping,
 		Subscribed: make(chan struct{}),
-		Message:    make(chan []byte),
+		Message:    make(chan Message),
+		State:      make(chan StateEvent, stateEventsBuffer),
+		once:       &sync.Once{},
+		mutex:      &sync.Mutex{},
+		channels:   map[Channel]bool{},
+		patterns:   map[Channel]bool{},
 	}
 }
 
-func (subscription *Subscription) Start(conn redis.Conn) error {
-	pubsub := redis.PubSubConn{Conn: conn}
-	err := pubsub.Subscribe(string(subscription.channel))
+// Subscribe adds channels to the subscription, at any time.
+func (subscription *Subscription) Subscribe(channels ...Channel) error {
+	return subscription.update(subscription.channels, true, "SUBSCRIBE", channels)
+}
+
+// Unsubscribe removes channels from the subscription, at any time.
+func (subscription *Subscription) Unsubscribe(channels ...Channel) error {
+	return subscription.update(subscription.channels, false, "UNSUBSCRIBE", channels)
+}
+
+// PSubscribe adds patterns to the subscription, at any time.
+func (subscription *Subscription) PSubscribe(patterns ...Channel) error {
+	return subscription.update(subscription.patterns, true, "PSUBSCRIBE", patterns)
+}
+
+// PUnsubscribe removes patterns from the subscription, at any time.
+func (subscription *Subscription) PUnsubscribe(patterns ...Channel) error {
+	return subscription.update(subscription.patterns, false, "PUNSUBSCRIBE", patterns)
+}
+
+// update changes the channels or patterns of set and sends command on the
+// current connection. They are sent on the next one when disconnected.
+func (subscription *Subscription) update(
+	set map[Channel]bool,
+	add bool,
+	command string,
+	channels []Channel) error {
+
+	if len(channels) == 0 {
+		return nil
+	}
+
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+
+	for _, channel := range channels {
+		if add {
+			set[channel] = true
+		} else {
+			delete(set, channel)
+		}
+	}
+
+	if subscription.pubsub == nil {
+		return nil
+	}
+
+	return subscription.send(command, channels)
+}
+
+// send writes command with channels on the current connection, with mutex
+// locked.
+func (subscription *Subscription) send(command string, channels []Channel) error {
+	if len(channels) == 0 {
+		return nil
+	}
+
+	args := redis.Args{}
+	for _, channel := range channels {
+		args = args.Add(string(channel))
+	}
+
+	conn := subscription.pubsub.Conn
+
+	err := conn.Send(command, args...)
 	if err != nil {
 		return err
 	}
 
-	failure := make(chan error)
-	done := make(chan struct{})
+	return conn.Flush()
+}
+
+// Start subscribes with the connections returned by dial until Context is
+// done.
+func (subscription *Subscription) Start(dial func() redis.Conn) error {
+	defer func() {
+		subscription.once.Do(func() { close(subscription.Subscribed) })
+		close(subscription.Message)
+		close(subscription.State)
+	}()
+
+	delay := minReconnectDelay
+
+	for attempt := 1; subscription.Context.Err() == nil; attempt++ {
+		subscribed, err := subscription.run(dial())
+		if subscription.Context.Err() != nil {
+			break
+		}
+
+		if subscribed {
+			attempt, delay = 1, minReconnectDelay
+		}
+
+		subscription.notify(StateEvent{
+			State:   DISCONNECTED,
+			Attempt: attempt,
+			Delay:   delay,
+			Error:   err,
+		})
+
+		select {
+		case <-time.After(delay):
+		case <-subscription.Context.Done():
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	return nil
+}
+
+// run subscribes with conn until it fails or Context is done, and tells
+// whether the subscription succeeded.
+func (subscription *Subscription) run(conn redis.Conn) (bool, error) {
+	defer conn.Close()
+
+	pubsub := &redis.PubSubConn{Conn: conn}
+
+	err := subscription.connect(pubsub)
+	if err != nil {
+		return false, err
+	}
+
+	defer subscription.disconnect()
+
+	subscribed := false
+	failure := make(chan error, 1)
 
 	go func() {
-		failure <- subscription.receive(pubsub, done)
-		close(failure)
+		failure <- subscription.receive(pubsub, &subscribed)
 	}()
 
 	ticker := time.NewTicker(subscription.ping)
@@ -50,27 +238,69 @@ func (subscription *Subscription) Start(conn redis.Conn) error {
 	for goOn := true; goOn; goOn = goOn && err == nil {
 		select {
 		case err = <-failure: // receive failed or ended
+			return subscribed, err
 
 		case <-ticker.C: // Connection health check
+			subscription.mutex.Lock()
 			err = pubsub.Ping("")
+			subscription.mutex.Unlock()
 
 		case <-subscription.Context.Done():
 			goOn = false
 		}
 	}
 
-	pubsub.Unsubscribe(string(subscription.channel))
+	if err == nil {
+		subscription.mutex.Lock()
+		pubsub.Conn.Send("UNSUBSCRIBE")
+		pubsub.Conn.Send("PUNSUBSCRIBE")
+		pubsub.Conn.Flush()
+		subscription.mutex.Unlock()
 
-	<-done
+		select {
+		case <-failure:
+			return subscribed, nil
 
+		case <-time.After(unsubscribeTimeout):
+		}
+	}
+
+	// Unblocks receive
 	conn.Close()
+	<-failure
+
+	return subscribed, err
+}
+
+// connect subscribes pubsub to the channels and patterns and makes it the
+// current connection.
+func (subscription *Subscription) connect(pubsub *redis.PubSubConn) error {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+
+	subscription.pubsub = pubsub
+
+	err := subscription.send("SUBSCRIBE", keys(subscription.channels))
+	if err == nil {
+		err = subscription.send("PSUBSCRIBE", keys(subscription.patterns))
+	}
+
+	if err != nil {
+		subscription.pubsub = nil
+	}
 
 	return err
 }
 
+func (subscription *Subscription) disconnect() {
+	subscription.mutex.Lock()
+	subscription.pubsub = nil
+	subscription.mutex.Unlock()
+}
+
 func (subscription *Subscription) receive(
-	pubsub redis.PubSubConn,
-	done chan struct{}) (err error) {
+	pubsub *redis.PubSubConn,
+	subscribed *bool) (err error) {
 
 	for goOn := true; goOn; goOn = goOn && err == nil {
 		switch result := pubsub.Receive().(type) {
@@ -78,24 +308,47 @@ func (subscription *Subscription) receive(
 			err = result
 
 		case redis.Subscription:
-			switch result.Count {
-			case 0:
-				goOn = false
+			switch {
+			// Every channel may have been removed at runtime
+			case result.Count == 0:
+				goOn = subscription.Context.Err() == nil
 
-			case 1:
-				subscription.Subscribed <- struct{}{}
+			case !*subscribed:
+				*subscribed = true
+
+				subscription.once.Do(func() { close(subscription.Subscribed) })
+				subscription.notify(StateEvent{State: SUBSCRIBED})
 			}
 
 		case redis.Message:
-			subscription.Message <- result.Data
+			message := Message{
+				Channel: Channel(result.Channel),
+				Pattern: Channel(result.Pattern),
+				Data:    result.Data,
+			}
+
+			select {
+			case subscription.Message <- message:
+			case <-subscription.Context.Done():
+			}
 		}
 	}
 
-	close(subscription.Subscribed)
-	close(subscription.Message)
+	return err
+}
 
-	done <- struct{}{}
-	close(done)
+func keys(set map[Channel]bool) []Channel {
+	channels := make([]Channel, 0, len(set))
+	for channel := range set {
+		channels = append(channels, channel)
+	}
 
-	return err
+	return channels
+}
+
+func (subscription *Subscription) notify(event StateEvent) {
+	select {
+	case subscription.State <- event:
+	default:
+	}
 }
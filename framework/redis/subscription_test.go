@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/framework/redis/redistest"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func receive(t *testing.T, subscription *Subscription) Message {
+	select {
+	case message := <-subscription.Message:
+		return message
+
+	case <-time.After(time.Second):
+		t.Errorf("No message received")
+		t.FailNow()
+	}
+
+	return Message{}
+}
+
+func waitState(t *testing.T, subscription *Subscription, state SubscriptionState) {
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case event := <-subscription.State:
+			if event.State == state {
+				return
+			}
+
+		case <-timeout:
+			t.Errorf("Subscription never became %s", state)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSubscriptionReconnects(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	client := newMemoryClient(t, server)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := client.Subscribe(ctx, "test", time.Second)
+	waitState(t, subscription, SUBSCRIBED)
+
+	server.Disconnect()
+
+	waitState(t, subscription, DISCONNECTED)
+	waitState(t, subscription, SUBSCRIBED)
+
+	err := client.Publish("test", "foo")
+	if err != nil {
+		t.Errorf("Publish failed: %s", err)
+	}
+
+	message := receive(t, subscription)
+	if message.Channel != "test" || string(message.Data) != `"foo"` {
+		t.Errorf("Subscription should receive foo on test, got %+v", message)
+	}
+
+	cancel()
+
+	for range subscription.Message {
+	}
+}
+
+func TestPatternSubscription(t *testing.T) {
+	server := redistest.NewMemoryServer()
+	client := newMemoryClient(t, server)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := client.PSubscribe(ctx, "streaming.*", time.Second)
+	<-subscription.Subscribed
+
+	client.Publish("streaming.box", 1)
+
+	message := receive(t, subscription)
+	if message.Channel != "streaming.box" || message.Pattern != "streaming.*" {
+		t.Errorf("Subscription should receive on streaming.box through streaming.*, got %+v",
+			message)
+	}
+
+	err := subscription.Subscribe("other")
+	if err != nil {
+		t.Errorf("Subscribe failed: %s", err)
+	}
+
+	err = subscription.PUnsubscribe("streaming.*")
+	if err != nil {
+		t.Errorf("PUnsubscribe failed: %s", err)
+	}
+
+	client.Publish("streaming.box", 2)
+	client.Publish("other", 3)
+
+	message = receive(t, subscription)
+	if message.Channel != "other" || string(message.Data) != "3" {
+		t.Errorf("Subscription should only receive on other, got %+v", message)
+	}
+}
+
+// hungConn confirms the subscriptions, then never answers.
+type hungConn struct {
+	replies chan interface{}
+	closed  chan struct{}
+	once    *sync.Once
+}
+
+func newHungConn() *hungConn {
+	return &hungConn{
+		replies: make(chan interface{}, 8),
+		closed:  make(chan struct{}),
+		once:    &sync.Once{},
+	}
+}
+
+func (conn *hungConn) Close() error {
+	conn.once.Do(func() { close(conn.closed) })
+	return nil
+}
+
+func (conn *hungConn) Err() error { return nil }
+
+func (conn *hungConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return nil, errors.New("hung")
+}
+
+func (conn *hungConn) Send(command string, args ...interface{}) error {
+	if command == "SUBSCRIBE" {
+		for _, arg := range args {
+			conn.replies <- []interface{}{[]byte("subscribe"), []byte(arg.(string)), int64(1)}
+		}
+	}
+
+	return nil
+}
+
+func (conn *hungConn) Flush() error { return nil }
+
+func (conn *hungConn) Receive() (interface{}, error) {
+	select {
+	case reply := <-conn.replies:
+		return reply, nil
+
+	case <-conn.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func TestSubscriptionStopsOnHungConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := NewSusbcription(ctx, "test", time.Minute)
+	conn := newHungConn()
+
+	started := make(chan error, 1)
+	go func() {
+		started <- subscription.Start(func() redis.Conn { return conn })
+	}()
+
+	select {
+	case <-subscription.Subscribed:
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription never subscribed")
+	}
+
+	cancel()
+
+	for range subscription.Message {
+	}
+
+	select {
+	case <-started:
+	case <-time.After(unsubscribeTimeout + time.Second):
+		t.Errorf("Start should return although UNSUBSCRIBE is never answered")
+	}
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type Transport string
+
+const (
+	// LIST_TRANSPORT queues requests in lists and replies through a PUBSUB
+	// channel per process.
+	LIST_TRANSPORT = Transport("list")
+
+	// STREAM_TRANSPORT queues requests in streams read by a consumer group and
+	// replies through a stream per process.
+	STREAM_TRANSPORT = Transport("stream")
+)
+
+// delivery is a request popped by a worker, waiting to be acknowledged.
+type delivery struct {
+	id  string
+	raw []byte
+}
+
+type transport interface {
+	// listen gets ready to receive the response to req before it is sent. It
+	// returns the address the worker must reply to and the channel the
+	// response will be delivered on until ctx is done.
+	listen(ctx context.Context, req *Request) (string, <-chan []byte, error)
+
+	push(channel Channel, data []byte) error
+
+	// prepare is called once before a worker starts popping from channel.
+	prepare(channel Channel) error
+
+	// pop waits at most timeout for a request and returns nil if none came.
+	pop(conn redis.Conn, channel Channel, timeout time.Duration) (*delivery, error)
+
+	ack(channel Channel, delivery *delivery) error
+	reply(to string, data []byte) error
+
+	// reap re-queues the requests of the dead workers of channel.
+	reap(channel Channel) (int, error)
+
+	// depth returns the number of requests queued on channel.
+	depth(channel Channel) (int, error)
+}
+
+func newTransport(client *Client, kind Transport) (transport, error) {
+	switch kind {
+	case "", LIST_TRANSPORT:
+		return newListTransport(client), nil
+
+	case STREAM_TRANSPORT:
+		return newStreamTransport(client), nil
+	}
+
+	return nil, fmt.Errorf("Unknown REDIS transport %s", kind)
+}
@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// reapScript moves every request of a dead worker's processing list back to
+// the front of the channel queue, oldest first, and forgets the worker.
+var reapScript = redis.NewScript(3, `
+local count = 0
+local raw = redis.call('LPOP', KEYS[1])
+
+while raw do
+	redis.call('RPUSH', KEYS[2], raw)
+	count = count + 1
+	raw = redis.call('LPOP', KEYS[1])
+end
+
+redis.call('SREM', KEYS[3], ARGV[1])
+
+return count
+`)
+
+type listTransport struct {
+	client     *Client
+	replies    Channel
+	dispatcher *dispatcher
+	once       *sync.Once
+	subscribed chan struct{}
+}
+
+func newListTransport(client *Client) *listTransport {
+	return &listTransport{
+		client:     client,
+		replies:    Channel(fmt.Sprintf("replies:%s", client.id)),
+		dispatcher: newDispatcher(),
+		once:       &sync.Once{},
+		subscribed: make(chan struct{}),
+	}
+}
+
+func (transport *listTransport) listen(
+	ctx context.Context,
+	req *Request) (string, <-chan []byte, error) {
+
+	transport.once.Do(func() { go transport.receive() })
+
+	// Responses published before the subscription is ready would be lost
+	select {
+	case <-transport.subscribed:
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+
+	responses := transport.dispatcher.wait(req.UUID)
+
+	go func() {
+		<-ctx.Done()
+		transport.dispatcher.forget(req.UUID, responses)
+	}()
+
+	return string(transport.replies), responses, nil
+}
+
+// receive keeps a single subscription to the reply channel of this process
+// and dispatches the responses until the client is closed.
+func (transport *listTransport) receive() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-transport.client.closed
+		cancel()
+	}()
+
+	subscription := NewSusbcription(ctx, transport.replies, transport.client.heartbeat)
+
+	go subscription.Start(func() redis.Conn {
+		return transport.client.pool.Get(string(transport.replies))
+	})
+
+	go func() {
+		for event := range subscription.State {
+			if event.State == DISCONNECTED {
+				transport.client.logger(transport.client.id, log.WARN,
+					"REDIS replies subscription disconnected",
+					map[string]interface{}{
+						"channel": transport.replies,
+						"attempt": event.Attempt,
+						"delay":   event.Delay,
+						"error":   event.Error,
+					})
+			}
+		}
+	}()
+
+	<-subscription.Subscribed
+	close(transport.subscribed)
+
+	for message := range subscription.Message {
+		transport.dispatch(message.Data)
+	}
+}
+
+func (transport *listTransport) dispatch(data []byte) {
+	header := &responseHeader{}
+
+	_, err := unframe(transport.client.codec, data, header)
+	if err != nil {
+		transport.client.logger(transport.client.id, log.ERROR,
+			"REDIS replies subscription received a malformed response",
+			map[string]interface{}{"channel": transport.replies, "error": err})
+
+		return
+	}
+
+	transport.dispatcher.dispatch(header.UUID, data)
+}
+
+func (transport *listTransport) push(channel Channel, data []byte) error {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	// Workers pop from the right so that requests are handled in order
+	_, err := conn.Do("LPUSH", string(channel), data)
+
+	return err
+}
+
+func (transport *listTransport) prepare(channel Channel) error {
+	return nil
+}
+
+func (transport *listTransport) pop(
+	conn redis.Conn,
+	channel Channel,
+	timeout time.Duration) (*delivery, error) {
+
+	// BRPOPLPUSH timeout is in seconds, 0 would block forever
+	seconds := int64((timeout + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	raw, err := redis.Bytes(conn.Do("BRPOPLPUSH",
+		string(channel), channel.processing(transport.client.id), seconds))
+
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery{raw: raw}, nil
+}
+
+func (transport *listTransport) ack(channel Channel, delivery *delivery) error {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	_, err := conn.Do("LREM", channel.processing(transport.client.id), 1, delivery.raw)
+
+	return err
+}
+
+func (transport *listTransport) reply(to string, data []byte) error {
+	return transport.client.publish(Channel(to), data)
+}
+
+func (transport *listTransport) depth(channel Channel) (int, error) {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	return redis.Int(conn.Do("LLEN", string(channel)))
+}
+
+func (transport *listTransport) reap(channel Channel) (int, error) {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	workers, err := redis.Strings(conn.Do("SMEMBERS", channel.workers()))
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+
+	for _, worker := range workers {
+		if worker == transport.client.id {
+			continue
+		}
+
+		alive, err := redis.Bool(conn.Do("EXISTS", channel.heartbeat(worker)))
+		if err != nil {
+			return requeued, err
+		}
+
+		if alive {
+			continue
+		}
+
+		count, err := redis.Int(reapScript.Do(conn,
+			channel.processing(worker), string(channel), channel.workers(), worker))
+
+		if err != nil {
+			return requeued, err
+		}
+
+		requeued += count
+
+		transport.client.logger(transport.client.id, log.INFO,
+			"REDIS reaped a dead worker",
+			map[string]interface{}{
+				"channel":  channel,
+				"worker":   worker,
+				"requeued": count,
+			})
+	}
+
+	return requeued, nil
+}
@@ -0,0 +1,441 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	streamGroup      = "workers"
+	streamField      = "data"
+	streamReapBatch  = 100
+	replyStreamLimit = 1000
+)
+
+type streamTransport struct {
+	client     *Client
+	replies    string
+	dispatcher *dispatcher
+	once       *sync.Once
+}
+
+func newStreamTransport(client *Client) *streamTransport {
+	return &streamTransport{
+		client:     client,
+		replies:    fmt.Sprintf("replies:%s", client.id),
+		dispatcher: newDispatcher(),
+		once:       &sync.Once{},
+	}
+}
+
+func (transport *streamTransport) listen(
+	ctx context.Context,
+	req *Request) (string, <-chan []byte, error) {
+
+	transport.once.Do(func() { go transport.receive() })
+
+	responses := transport.dispatcher.wait(req.UUID)
+
+	go func() {
+		<-ctx.Done()
+		transport.dispatcher.forget(req.UUID, responses)
+	}()
+
+	return transport.replies, responses, nil
+}
+
+// receive reads the reply stream of this process and dispatches the
+// responses until the client is closed.
+func (transport *streamTransport) receive() {
+	last := "0"
+
+	for goOn := true; goOn; {
+		conn := transport.client.pool.Get(transport.replies)
+
+		var err error
+		last, err = transport.read(conn, last)
+
+		conn.Close()
+
+		if err != nil {
+			transport.client.logger(transport.client.id, log.WARN,
+				"REDIS XREAD replies failed",
+				map[string]interface{}{"stream": transport.replies, "error": err})
+		}
+
+		select {
+		case <-time.After(transport.client.heartbeat):
+		case <-transport.client.closed:
+			goOn = false
+		}
+	}
+}
+
+func (transport *streamTransport) read(conn redis.Conn, last string) (string, error) {
+	for {
+		// The reply stream disappears shortly after this process
+		_, err := conn.Do("PEXPIRE", transport.replies,
+			milliseconds(transport.client.staleAfter()))
+
+		if err != nil {
+			return last, err
+		}
+
+		reply, err := conn.Do("XREAD",
+			"BLOCK", milliseconds(transport.client.heartbeat),
+			"STREAMS", transport.replies, last)
+
+		if err != nil {
+			return last, err
+		}
+
+		entries, err := streamEntries(reply)
+		if err != nil {
+			return last, err
+		}
+
+		for _, entry := range entries {
+			last = entry.id
+
+			header := &responseHeader{}
+			_, err = unframe(transport.client.codec, entry.raw, header)
+			if err != nil {
+				transport.client.logger(transport.client.id, log.ERROR,
+					"REDIS XREAD received a malformed response",
+					map[string]interface{}{"stream": transport.replies, "error": err})
+
+				continue
+			}
+
+			transport.dispatcher.dispatch(header.UUID, entry.raw)
+		}
+	}
+}
+
+func (transport *streamTransport) push(channel Channel, data []byte) error {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	_, err := conn.Do("XADD", string(channel), "*", streamField, data)
+
+	return err
+}
+
+func (transport *streamTransport) prepare(channel Channel) error {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	_, err := conn.Do("XGROUP", "CREATE", string(channel), streamGroup, "0", "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+
+	return err
+}
+
+func (transport *streamTransport) pop(
+	conn redis.Conn,
+	channel Channel,
+	timeout time.Duration) (*delivery, error) {
+
+	// BLOCK 0 would block forever
+	block := milliseconds(timeout)
+	if block < 1 {
+		block = 1
+	}
+
+	reply, err := conn.Do("XREADGROUP",
+		"GROUP", streamGroup, transport.client.id,
+		"COUNT", 1, "BLOCK", block,
+		"STREAMS", string(channel), ">")
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := streamEntries(reply)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	return entries[0], nil
+}
+
+func (transport *streamTransport) ack(channel Channel, delivery *delivery) error {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("XACK", string(channel), streamGroup, delivery.id)
+	conn.Send("XDEL", string(channel), delivery.id)
+
+	_, err := conn.Do("EXEC")
+
+	return err
+}
+
+func (transport *streamTransport) reply(to string, data []byte) error {
+	conn := transport.client.pool.Get(to)
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("XADD", to, "MAXLEN", "~", replyStreamLimit, "*", streamField, data)
+	conn.Send("PEXPIRE", to, milliseconds(transport.client.staleAfter()))
+
+	_, err := conn.Do("EXEC")
+
+	return err
+}
+
+// depth counts the entries of the stream, which are deleted once handled.
+func (transport *streamTransport) depth(channel Channel) (int, error) {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	return redis.Int(conn.Do("XLEN", string(channel)))
+}
+
+// reap claims the pending requests of the consumers which stopped sending
+// heartbeats, adds them back to the stream and removes those consumers, even
+// the ones which had nothing pending.
+func (transport *streamTransport) reap(channel Channel) (int, error) {
+	conn := transport.client.pool.Get(string(channel))
+	defer conn.Close()
+
+	consumers, err := redis.Values(conn.Do("XINFO", "CONSUMERS", string(channel), streamGroup))
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+
+	for _, consumer := range consumers {
+		worker, err := consumerName(consumer)
+		if err != nil {
+			return requeued, err
+		}
+
+		if worker == transport.client.id {
+			continue
+		}
+
+		alive, err := redis.Bool(conn.Do("EXISTS", channel.heartbeat(worker)))
+		if err != nil {
+			return requeued, err
+		}
+
+		if alive {
+			continue
+		}
+
+		count, err := transport.requeue(conn, channel, worker)
+		requeued += count
+
+		if err != nil {
+			return requeued, err
+		}
+
+		transport.client.logger(transport.client.id, log.INFO,
+			"REDIS reaped a dead worker",
+			map[string]interface{}{
+				"channel":  channel,
+				"worker":   worker,
+				"requeued": count,
+			})
+	}
+
+	return requeued, nil
+}
+
+func (transport *streamTransport) requeue(
+	conn redis.Conn,
+	channel Channel,
+	worker string) (int, error) {
+
+	pending, err := redis.Values(conn.Do("XPENDING",
+		string(channel), streamGroup, "-", "+", streamReapBatch, worker))
+
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(pending))
+
+	for _, entry := range pending {
+		fields, err := redis.Values(entry, nil)
+		if err != nil || len(fields) < 1 {
+			return 0, err
+		}
+
+		id, err := redis.String(fields[0], nil)
+		if err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	count := 0
+
+	if len(ids) != 0 {
+		// Only one reaper can claim the requests since claiming resets their
+		// idle time
+		claimed, err := redis.Values(conn.Do("XCLAIM", redis.Args{}.
+			Add(string(channel), streamGroup, transport.client.id).
+			Add(milliseconds(transport.client.staleAfter())).
+			AddFlat(ids)...))
+
+		if err != nil {
+			return 0, err
+		}
+
+		entries, err := parseStreamEntries(claimed)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, entry := range entries {
+			conn.Send("MULTI")
+
+			if entry.raw != nil {
+				conn.Send("XADD", string(channel), "*", streamField, entry.raw)
+			}
+
+			conn.Send("XACK", string(channel), streamGroup, entry.id)
+			conn.Send("XDEL", string(channel), entry.id)
+
+			_, err = conn.Do("EXEC")
+			if err != nil {
+				return count, err
+			}
+
+			if entry.raw != nil {
+				count++
+			}
+		}
+	}
+
+	if len(ids) < streamReapBatch {
+		conn.Send("MULTI")
+		conn.Send("XGROUP", "DELCONSUMER", string(channel), streamGroup, worker)
+		conn.Send("SREM", channel.workers(), worker)
+
+		_, err = conn.Do("EXEC")
+	}
+
+	return count, err
+}
+
+// consumerName reads the name of a consumer described by XINFO CONSUMERS.
+func consumerName(consumer interface{}) (string, error) {
+	fields, err := redis.Values(consumer, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for index := 0; index+1 < len(fields); index += 2 {
+		key, _ := redis.String(fields[index], nil)
+
+		if key == "name" {
+			return redis.String(fields[index+1], nil)
+		}
+	}
+
+	return "", fmt.Errorf("Malformed REDIS consumer: %v", fields)
+}
+
+// streamEntries parses the entries of a XREAD or XREADGROUP reply.
+func streamEntries(reply interface{}) ([]*delivery, error) {
+	if reply == nil {
+		return nil, nil
+	}
+
+	streams, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*delivery{}
+
+	for _, stream := range streams {
+		fields, err := redis.Values(stream, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Malformed REDIS stream reply: %v", fields)
+		}
+
+		values, err := redis.Values(fields[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := parseStreamEntries(values)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, entries...)
+	}
+
+	return result, nil
+}
+
+// parseStreamEntries parses a list of [id, [field, value...]] entries.
+// Entries deleted in the meantime come without raw payload.
+func parseStreamEntries(values []interface{}) ([]*delivery, error) {
+	result := make([]*delivery, 0, len(values))
+
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+
+		fields, err := redis.Values(value, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Malformed REDIS stream entry: %v", fields)
+		}
+
+		id, err := redis.String(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &delivery{id: id}
+
+		if fields[1] != nil {
+			pairs, err := redis.Values(fields[1], nil)
+			if err != nil {
+				return nil, err
+			}
+
+			for index := 0; index+1 < len(pairs); index += 2 {
+				name, _ := redis.String(pairs[index], nil)
+
+				if name == streamField {
+					entry.raw, err = redis.Bytes(pairs[index+1], nil)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
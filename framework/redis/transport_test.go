@@ -0,0 +1,192 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	"github.com/kukinsula/boxy/entity/codec"
+	"github.com/kukinsula/boxy/entity/log"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type echoParams struct {
+	Value string `json:"value"`
+}
+
+type echoHandler struct {
+	params *echoParams
+}
+
+func (handler *echoHandler) Params() interface{} { return handler.params }
+
+func (handler *echoHandler) Exec(uuid string, ctx context.Context) (interface{}, error) {
+	return handler.params, nil
+}
+
+// newRedisClient connects to the REDIS at BOXY_REDIS_ADDRESS, the test is
+// skipped when it is not set.
+func newRedisClient(t *testing.T, transport Transport) *Client {
+	address := os.Getenv("BOXY_REDIS_ADDRESS")
+	if address == "" {
+		t.Skip("BOXY_REDIS_ADDRESS is not set")
+	}
+
+	client, err := NewClient(Config{
+		Address:        address,
+		MaxActive:      20,
+		MaxIdle:        10,
+		RequestTimeout: time.Second,
+		Heartbeat:      50 * time.Millisecond,
+		Transport:      transport,
+		Codec:          &codec.JSONCodec{},
+		Logger:         log.NoOpLogger,
+	})
+
+	if err != nil {
+		t.Errorf("NewClient failed: %s", err)
+		t.FailNow()
+	}
+
+	return client
+}
+
+func TestConsumerName(t *testing.T) {
+	name, err := consumerName([]interface{}{
+		[]byte("name"), []byte("worker"),
+		[]byte("pending"), int64(2),
+		[]byte("idle"), int64(1000),
+	})
+
+	if err != nil || name != "worker" {
+		t.Errorf("consumerName should return worker, got %s (%v)", name, err)
+	}
+
+	_, err = consumerName([]interface{}{[]byte("pending"), int64(2)})
+	if err == nil {
+		t.Error("consumerName should fail without name")
+	}
+}
+
+func TestStreamReap(t *testing.T) {
+	client := newRedisClient(t, STREAM_TRANSPORT)
+	defer client.Close()
+
+	channel := Channel(fmt.Sprintf("test.reap.%s", entity.NewUUID()))
+	conn := client.pool.Get(string(channel))
+	defer conn.Close()
+
+	defer conn.Do("DEL", string(channel), channel.workers())
+
+	err := client.transport.prepare(channel)
+	if err != nil {
+		t.Errorf("prepare failed: %s", err)
+		t.FailNow()
+	}
+
+	// A worker which died while handling a request and an idle one which
+	// died with nothing pending
+	conn.Do("XADD", string(channel), "*", streamField, "request")
+	conn.Do("XREADGROUP", "GROUP", streamGroup, "busy", "COUNT", 1,
+		"STREAMS", string(channel), ">")
+	conn.Do("XREADGROUP", "GROUP", streamGroup, "idle", "COUNT", 1,
+		"STREAMS", string(channel), ">")
+	conn.Do("SADD", channel.workers(), "busy", "idle")
+
+	requeued, err := client.Reap(channel)
+	if err != nil {
+		t.Errorf("Reap failed: %s", err)
+	}
+
+	if requeued != 1 {
+		t.Errorf("Reap should re-queue 1 request, got %d", requeued)
+	}
+
+	consumers, err := redis.Values(conn.Do("XINFO", "CONSUMERS", string(channel), streamGroup))
+	if err != nil {
+		t.Errorf("XINFO CONSUMERS failed: %s", err)
+	}
+
+	for _, consumer := range consumers {
+		name, _ := consumerName(consumer)
+
+		if name == "busy" || name == "idle" {
+			t.Errorf("Reap should delete the dead consumer %s", name)
+		}
+	}
+
+	workers, err := redis.Strings(conn.Do("SMEMBERS", channel.workers()))
+	if err != nil || len(workers) != 0 {
+		t.Errorf("Reap should remove the dead workers, got %v (%v)", workers, err)
+	}
+}
+
+func benchmarkTransport(b *testing.B, transport Transport) {
+	client, err := NewClient(Config{
+		Address:        "127.0.0.1:6379",
+		MaxActive:      50,
+		MaxIdle:        10,
+		IdleTimeout:    time.Minute,
+		RequestTimeout: 5 * time.Second,
+		Transport:      transport,
+		Codec:          &codec.JSONCodec{},
+		Logger:         log.NoOpLogger,
+	})
+
+	if err != nil {
+		b.Errorf("NewClient failed: %s", err)
+		b.FailNow()
+	}
+
+	defer client.Close()
+
+	_, err = client.Ping()
+	if err != nil {
+		b.Skipf("REDIS is unavailable: %s", err)
+	}
+
+	channel := Channel(fmt.Sprintf("benchmark.%s.%s", transport, entity.NewUUID()))
+
+	defer func() {
+		conn := client.pool.Get(string(channel))
+		conn.Do("DEL", string(channel), channel.workers())
+		conn.Close()
+	}()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	go client.Handle(ctx, channel, func() Handler {
+		return &echoHandler{params: &echoParams{}}
+	})
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			result := &echoParams{}
+			err := client.Request(&Request{
+				UUID:    entity.NewUUID(),
+				Context: context.Background(),
+				Channel: channel,
+				Params:  &echoParams{Value: "ping"},
+			}).Decode(result)
+
+			if err != nil {
+				b.Errorf("Request failed: %s", err)
+			}
+		}
+	})
+}
+
+func BenchmarkListTransport(b *testing.B) {
+	benchmarkTransport(b, LIST_TRANSPORT)
+}
+
+func BenchmarkStreamTransport(b *testing.B) {
+	benchmarkTransport(b, STREAM_TRANSPORT)
+}
@@ -1,28 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/kukinsula/boxy/entity"
 	"github.com/kukinsula/boxy/entity/codec"
 	"github.com/kukinsula/boxy/entity/log"
 	"github.com/kukinsula/boxy/framework/api/server"
 	redis "github.com/kukinsula/boxy/framework/redis"
 	redisClient "github.com/kukinsula/boxy/framework/redis/client"
+	"github.com/kukinsula/boxy/usecase"
 )
 
 func main() {
 	logger := log.CleanMetaLogger(log.StdoutLogger)
 	client, err := redis.NewClient(redis.Config{
-		Address:     "127.0.0.1:6379",
-		MaxActive:   10,
-		MaxIdle:     5,
-		IdleTimeout: 200 * time.Second,
-		Codec:       &codec.JSONCodec{},
-		Logger:      logger,
+		Address:        "127.0.0.1:6379",
+		MaxActive:      10,
+		MaxIdle:        5,
+		IdleTimeout:    200 * time.Second,
+		RequestTimeout: 10 * time.Second,
+		Codec:          &codec.JSONCodec{},
+		Logger:         logger,
 	})
 
 	if err != nil {
@@ -33,10 +37,21 @@ func main() {
 	login := redisClient.NewLogin(client)
 	streaming := redisClient.NewStreaming(client)
 	backend := server.NewBackend(login, streaming)
+	tokener, _ := usecase.NewKeyTokener()
+
+	// Without keys, the Login service verifies every access token
+	err = server.UpdateKeys(entity.NewUUID(), context.Background(), login, tokener)
+	if err != nil {
+		fmt.Printf("server.UpdateKeys failed: %s\n", err)
+	}
+
 	api := server.NewAPI(server.Config{
-		Address: "127.0.0.1:9000",
-		Backend: backend,
-		Logger:  logger,
+		Address:     "127.0.0.1:9000",
+		Backend:     backend,
+		Tokener:     tokener,
+		KeysRefresh: 5 * time.Minute,
+		Revocations: redis.NewRevocations(client),
+		Logger:      logger,
 	})
 
 	signals := make(chan os.Signal, 1)
@@ -58,6 +58,7 @@ func gui() {
 	signinResult, err := service.Login.Signin(entity.NewUUID(), &loginUsecase.SigninParams{
 		Email:    "fhBb.rykEy@mail.io",
 		Password: "LswSuyjg",
+		Device:   "cli",
 	})
 
 	if err != nil {
@@ -307,7 +308,7 @@ func stress() {
 
 		for index := 0; index < workers; index++ {
 			user := &loginEntity.User{
-				Password:  GenerateRandomString(8),
+				Password:  GenerateRandomPassword(16),
 				FirstName: GenerateRandomString(4),
 				LastName:  GenerateRandomString(5),
 			}
@@ -379,6 +380,7 @@ func worker(
 		signinResult, err = service.Login.Signin(entity.NewUUID(), &loginUsecase.SigninParams{
 			Email:    user.Email,
 			Password: user.Password,
+			Device:   "stress",
 		})
 
 		if err != nil {
@@ -446,7 +448,10 @@ func getRandomDuration(randomer *rand.Rand, min, max int) time.Duration {
 	return time.Duration(getRandom(randomer, min, max)) * time.Millisecond
 }
 
-var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+var (
+	letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	digitRunes  = []rune("0123456789")
+)
 
 func GenerateRandomString(n int) string {
 	b := make([]rune, n)
@@ -457,3 +462,18 @@ func GenerateRandomString(n int) string {
 
 	return string(b)
 }
+
+// GenerateRandomPassword returns n random runes satisfying the default
+// password policy: letters of both cases and digits.
+func GenerateRandomPassword(n int) string {
+	b := []rune(GenerateRandomString(n - 3))
+
+	b = append(b,
+		letterRunes[rand.Intn(26)],
+		letterRunes[26+rand.Intn(26)],
+		digitRunes[rand.Intn(len(digitRunes))])
+
+	rand.Shuffle(len(b), func(i, j int) { b[i], b[j] = b[j], b[i] })
+
+	return string(b)
+}
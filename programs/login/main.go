@@ -2,23 +2,46 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/kukinsula/boxy/entity"
 	"github.com/kukinsula/boxy/entity/codec"
 	"github.com/kukinsula/boxy/entity/log"
 	"github.com/kukinsula/boxy/framework/mongo"
+	"github.com/kukinsula/boxy/framework/postgres"
 	redis "github.com/kukinsula/boxy/framework/redis"
 	redisServer "github.com/kukinsula/boxy/framework/redis/server"
 	"github.com/kukinsula/boxy/usecase"
 	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+
+	_ "github.com/lib/pq"
 )
 
+const SESSIONS_PURGE_EVERY = time.Hour
+
 func main() {
 	logger := log.CleanMetaLogger(log.StdoutLogger)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(logger, os.Args[2:]))
+	}
+
+	// Keys rotate with the key set, ephemeral keys die with the service
+	tokener, err := newTokener(logger, os.Getenv("BOXY_JWT_KEYS"),
+		os.Getenv("BOXY_JWT_EPHEMERAL_KEY") == "true")
+
+	if err != nil {
+		fmt.Printf("newTokener failed: %s\n", err)
+		return
+	}
+
 	client, err := redis.NewClient(redis.Config{
 		Address:     "127.0.0.1:6379",
 		MaxActive:   10,
@@ -29,44 +52,180 @@ func main() {
 	})
 
 	if err != nil {
-		fmt.Println("redis.NewClient failed: %s", err)
+		fmt.Printf("redis.NewClient failed: %s\n", err)
 		return
 	}
 
 	ctx := context.Background()
-	database, err := mongo.NewDatabase(mongo.NewDatabaseParams{
-		Context:  ctx,
-		URI:      "mongodb://localhost:27017",
-		Database: "boxy",
-		Logger:   logger,
-	})
+
+	// PostgreSQL replaces MongoDB when configured
+	loginGateway, sessionGateway, err := newLoginGateways(ctx, logger,
+		os.Getenv("BOXY_POSTGRES_URI"))
 
 	if err != nil {
-		fmt.Println("NewDatabase failed: %s", err)
+		fmt.Printf("newLoginGateways failed: %s\n", err)
 		return
 	}
 
-	err = database.Init(ctx)
+	passworder := usecase.NewPassworder(10)
 
-	if err != nil {
-		fmt.Println("Database.Init failed: %s", err)
-		return
+	denylist := os.Getenv("BOXY_PASSWORD_DENYLIST")
+	if denylist != "" {
+		err = passworder.Policy.LoadDenylist(denylist)
+		if err != nil {
+			fmt.Printf("PasswordPolicy.LoadDenylist failed: %s\n", err)
+			return
+		}
 	}
 
-	tokener := usecase.NewTokener("TopSecret")
-	passworder := usecase.NewPassworder(10)
-	login := loginUsecase.NewLogin(database.User, tokener, passworder)
+	revocations := redis.NewRevocations(client)
+	login := loginUsecase.NewLogin(loginGateway, sessionGateway, revocations, tokener, passworder)
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	go redisServer.HandleSignup(client, login)
-	go redisServer.HandleSignin(client, login)
-	go redisServer.HandleCheckActivate(client, login)
-	go redisServer.HandleActivate(client, login)
-	go redisServer.HandleMe(client, login)
-	go redisServer.HandleLogout(client, login)
+	router := redis.NewRouter(client)
+	router.Use(redis.TracingMiddleware(logger), redis.LoggingMiddleware(logger))
+	redisServer.RegisterLogin(router, login)
+
+	serveCtx, stop := context.WithCancel(ctx)
+	served := make(chan error, 1)
+
+	go func() {
+		served <- router.Serve(serveCtx)
+	}()
+
+	select {
+	case <-signals:
+		// Let the requests in flight be answered
+		stop()
+		err = <-served
+
+	case err = <-served:
+		stop()
+	}
+
+	client.Close()
+
+	if err != nil {
+		fmt.Printf("Router.Serve failed: %s\n", err)
+		return
+	}
 
-	<-signals
 	fmt.Println("Finished!")
 }
+
+func newLoginGateways(
+	ctx context.Context,
+	logger log.Logger,
+	postgresURI string) (loginUsecase.LoginGateway, loginUsecase.SessionGateway, error) {
+
+	if postgresURI != "" {
+		database, err := postgres.NewDatabase(postgres.NewDatabaseParams{
+			Context: ctx,
+			Driver:  "postgres",
+			URI:     postgresURI,
+			Timeout: 10 * time.Second,
+			Logger:  logger,
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = database.Init(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		go deleteExpiredSessions(ctx, logger, database.Session)
+
+		return database.User, database.Session, nil
+	}
+
+	database, err := newMongoDatabase(ctx, logger, os.Getenv("BOXY_MONGO_URI"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = database.Init(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return database.User, database.Session, nil
+}
+
+// newMongoDatabase connects to the MongoDB at uri, the replica set rs0 on
+// localhost by default: the Login transactions cannot run on a standalone
+// server, started with mongod --replSet rs0 then rs.initiate() once.
+// deleteExpiredSessions purges the expired PostgreSQL sessions every
+// SESSIONS_PURGE_EVERY until ctx is done.
+func deleteExpiredSessions(ctx context.Context, logger log.Logger, sessions *postgres.SessionModel) {
+	ticker := time.NewTicker(SESSIONS_PURGE_EVERY)
+	defer ticker.Stop()
+
+	for goOn := true; goOn; {
+		select {
+		case <-ctx.Done():
+			goOn = false
+
+		case <-ticker.C:
+			uuid := entity.NewUUID()
+
+			err := sessions.DeleteExpired(uuid, ctx)
+			if err != nil {
+				logger(uuid, log.ERROR, "Sessions.DeleteExpired",
+					map[string]interface{}{"error": err})
+			}
+		}
+	}
+}
+
+func newMongoDatabase(ctx context.Context, logger log.Logger, uri string) (*mongo.Database, error) {
+	if uri == "" {
+		uri = "mongodb://localhost:27017/?replicaSet=rs0"
+	}
+
+	return mongo.NewDatabase(mongo.NewDatabaseParams{
+		Context:  ctx,
+		URI:      uri,
+		Database: "boxy",
+		Timeout:  10 * time.Second,
+		Logger:   logger,
+	})
+}
+
+// newTokener signs with the keys of the key set at path. Without one, it
+// signs with an ephemeral Ed25519 key if ephemeral, for development only: the
+// tokens would not survive a restart nor be shared between instances.
+func newTokener(logger log.Logger, path string, ephemeral bool) (*usecase.Tokener, error) {
+	if path != "" {
+		keys, err := usecase.LoadKeySet(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return usecase.NewKeyTokener(keys...)
+	}
+
+	if !ephemeral {
+		return nil, errors.New("BOXY_JWT_KEYS is not set, " +
+			"set BOXY_JWT_EPHEMERAL_KEY=true to sign with an ephemeral key in development")
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := usecase.NewKey(entity.NewUUID(), private)
+	if err != nil {
+		return nil, err
+	}
+
+	logger(entity.NewUUID(), log.WARN, "Tokener.EphemeralKey",
+		map[string]interface{}{"kid": key.ID, "message": "BOXY_JWT_KEYS is not set"})
+
+	return usecase.NewKeyTokener(key)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kukinsula/boxy/entity/log"
+	"github.com/kukinsula/boxy/framework/mongo"
+)
+
+const migrateUsage = `Usage: login migrate <command>
+
+Commands:
+  status        lists the migrations and whether they are applied
+  up            applies every pending migration
+  to <version>  applies or reverts migrations up to version, 0 reverting all
+  indexes       lists the indexes differing from those the models declare
+  reconcile     creates, recreates or drops indexes to match the models
+`
+
+// migrate runs the migrate subcommand on the MongoDB database and returns
+// the exit code.
+func migrate(logger log.Logger, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	ctx := context.Background()
+
+	database, err := newMongoDatabase(ctx, logger, os.Getenv("BOXY_MONGO_URI"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "NewDatabase failed: %s\n", err)
+		return 1
+	}
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		err = migrationStatus(ctx, database)
+
+	case args[0] == "up" && len(args) == 1:
+		err = database.Migrate(ctx)
+
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			fmt.Fprintf(os.Stderr, "Invalid version %s\n", args[1])
+			return 2
+		}
+
+		err = database.MigrateTo(ctx, version)
+
+	case args[0] == "indexes" && len(args) == 1:
+		err = indexDrift(ctx, database)
+
+	case args[0] == "reconcile" && len(args) == 1:
+		err = database.ReconcileIndexes(ctx)
+
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s failed: %s\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+func migrationStatus(ctx context.Context, database *mongo.Database) error {
+	statuses, err := database.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		applied := "pending"
+		if status.Applied {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+
+		fmt.Printf("%4d  %-25s  %s\n", status.Version, applied, status.Name)
+	}
+
+	return nil
+}
+
+func indexDrift(ctx context.Context, database *mongo.Database) error {
+	drifts, err := database.IndexDrift(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		fmt.Println("Indexes match the models")
+	}
+
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+
+	return nil
+}
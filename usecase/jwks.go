@@ -0,0 +1,181 @@
+package usecase
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// JWK is a public key as RFC 7517 publishes it.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is the key set served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the asymmetric keys of tokener that are not retired, those
+// scheduled to sign later included so that verifiers know them beforehand.
+func (tokener *Tokener) JWKS() *JWKS {
+	tokener.mutex.RLock()
+	defer tokener.mutex.RUnlock()
+
+	now := time.Now()
+	result := &JWKS{Keys: []JWK{}}
+
+	for _, key := range tokener.keys {
+		if !key.verifies(now) {
+			continue
+		}
+
+		jwk, ok := key.jwk()
+		if ok {
+			result.Keys = append(result.Keys, jwk)
+		}
+	}
+
+	return result
+}
+
+func (key *Key) jwk() (JWK, bool) {
+	jwk := JWK{ID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeJWK(public.N.Bytes())
+		jwk.E = encodeJWK(big.NewInt(int64(public.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = encodeJWK(padJWK(public.X.Bytes(), 32))
+		jwk.Y = encodeJWK(padJWK(public.Y.Bytes(), 32))
+
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = encodeJWK(public)
+
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// KeysFromJWKS returns the keys of jwks, which only verify.
+func KeysFromJWKS(jwks *JWKS) ([]*Key, error) {
+	keys := []*Key{}
+
+	for _, jwk := range jwks.Keys {
+		public, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWK %s: %w", jwk.ID, err)
+		}
+
+		key, err := NewKey(jwk.ID, public)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (jwk JWK) publicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeJWK(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeJWK(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("%w: curve %s", UnsupportedKeyErr, jwk.Curve)
+		}
+
+		x, err := decodeJWK(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeJWK(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %s", UnsupportedKeyErr, jwk.Curve)
+		}
+
+		x, err := decodeJWK(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: Ed25519 key of %d bytes", UnsupportedKeyErr, len(x))
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("%w: key type %s", UnsupportedKeyErr, jwk.KeyType)
+}
+
+func encodeJWK(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeJWK(str string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(str)
+}
+
+// padJWK left pads data with zeros to size bytes, as EC coordinates must be.
+func padJWK(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+
+	return append(make([]byte, size-len(data)), data...)
+}
@@ -0,0 +1,230 @@
+package usecase
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EDDSA = "EdDSA"
+)
+
+var UnsupportedKeyErr = errors.New("Tokener: unsupported key")
+
+// Key signs and verifies tokens with its Algorithm, its ID being their kid.
+// Rotation is scheduled by SignFrom, the newest key already valid signing,
+// while VerifyUntil retires it. Keys holding only a public key verify only.
+type Key struct {
+	ID          string
+	Algorithm   string
+	SignFrom    time.Time // Zero signs at once
+	VerifyUntil time.Time // Zero never retires
+	private     interface{}
+	public      interface{}
+}
+
+// NewSecretKey returns an HS256 Key, which cannot be published.
+func NewSecretKey(id, secret string) *Key {
+	return &Key{
+		ID:        id,
+		Algorithm: HS256,
+		private:   []byte(secret),
+		public:    []byte(secret),
+	}
+}
+
+// NewKey returns the Key of an RSA, P-256 ECDSA or Ed25519 private or public
+// key, its algorithm depending on its type.
+func NewKey(id string, key interface{}) (*Key, error) {
+	result := &Key{ID: id}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		result.Algorithm, result.private, result.public = RS256, key, &key.PublicKey
+
+	case *rsa.PublicKey:
+		result.Algorithm, result.public = RS256, key
+
+	case *ecdsa.PrivateKey:
+		result.Algorithm, result.private, result.public = ES256, key, &key.PublicKey
+
+	case *ecdsa.PublicKey:
+		result.Algorithm, result.public = ES256, key
+
+	case ed25519.PrivateKey:
+		result.Algorithm, result.private, result.public = EDDSA, key, key.Public()
+
+	case ed25519.PublicKey:
+		result.Algorithm, result.public = EDDSA, key
+
+	default:
+		return nil, fmt.Errorf("%w: %T", UnsupportedKeyErr, key)
+	}
+
+	ecdsaKey, ok := result.public.(*ecdsa.PublicKey)
+	if ok && ecdsaKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: curve %s instead of P-256",
+			UnsupportedKeyErr, ecdsaKey.Curve.Params().Name)
+	}
+
+	return result, nil
+}
+
+// LoadKey reads the PEM key at path: a PKCS #1, SEC 1 or PKCS #8 private key
+// or a PKIX public key.
+func LoadKey(id, path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not PEM encoded", UnsupportedKeyErr, path)
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+
+	default:
+		return nil, fmt.Errorf("%w: %s holds a %s", UnsupportedKeyErr, path, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Tokener: cannot parse %s: %s", path, err)
+	}
+
+	return NewKey(id, key)
+}
+
+// keySetFile lists the keys of a key set, their paths being relative to it.
+type keySetFile struct {
+	Keys []struct {
+		ID          string    `json:"kid"`
+		Path        string    `json:"path"`
+		SignFrom    time.Time `json:"signFrom"`
+		VerifyUntil time.Time `json:"verifyUntil"`
+	} `json:"keys"`
+}
+
+// LoadKeySet reads the keys listed by the JSON file at path, such as:
+//
+//	{"keys": [
+//		{"kid": "2020-01", "path": "2020-01.pem", "verifyUntil": "2020-03-01T00:00:00Z"},
+//		{"kid": "2020-02", "path": "2020-02.pem", "signFrom": "2020-02-01T00:00:00Z"}
+//	]}
+func LoadKeySet(path string) ([]*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := keySetFile{}
+
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("Tokener: cannot parse key set %s: %s", path, err)
+	}
+
+	keys := []*Key{}
+
+	for _, entry := range file.Keys {
+		keyPath := entry.Path
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+
+		key, err := LoadKey(entry.ID, keyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		key.SignFrom = entry.SignFrom
+		key.VerifyUntil = entry.VerifyUntil
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (key *Key) signs(now time.Time) bool {
+	return key.private != nil && !now.Before(key.SignFrom) && key.verifies(now)
+}
+
+func (key *Key) verifies(now time.Time) bool {
+	return key.VerifyUntil.IsZero() || now.Before(key.VerifyUntil)
+}
+
+func (key *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(key.Algorithm)
+}
+
+// signingMethodEdDSA signs with Ed25519, which jwt-go lacks.
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(EDDSA, func() jwt.SigningMethod {
+		return signingMethodEdDSA{}
+	})
+}
+
+func (method signingMethodEdDSA) Alg() string {
+	return EDDSA
+}
+
+func (method signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func (method signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	data, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(public, []byte(signingString), data) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package login
+
+// Error is a Login failure carrying a stable code transports can switch on.
+// Use cases wrap the sentinels below with their own context, errors.Is and
+// errors.As still find them. Details describe the failure to the client.
+type Error struct {
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// Is matches the errors having the code of err, whatever their details.
+func (err *Error) Is(target error) bool {
+	failure, ok := target.(*Error)
+
+	return ok && failure.Code == err.Code
+}
+
+// WithDetails returns a copy of err carrying details, the sentinels being
+// shared.
+func (err *Error) WithDetails(details map[string]interface{}) *Error {
+	return &Error{
+		Code:    err.Code,
+		Message: err.Message,
+		Details: details,
+	}
+}
+
+var (
+	UserNotFoundErr = &Error{
+		Code:    "USER_NOT_FOUND",
+		Message: "User not found",
+	}
+
+	InvalidCredentialsErr = &Error{
+		Code:    "INVALID_CREDENTIALS",
+		Message: "Invalid credentials",
+	}
+
+	InvalidTokenErr = &Error{
+		Code:    "INVALID_TOKEN",
+		Message: "Invalid token",
+	}
+
+	TokenExpiredErr = &Error{
+		Code:    "TOKEN_EXPIRED",
+		Message: "Token expired",
+	}
+
+	EmailTakenErr = &Error{
+		Code:    "EMAIL_TAKEN",
+		Message: "Email already taken",
+	}
+
+	SessionNotFoundErr = &Error{
+		Code:    "SESSION_NOT_FOUND",
+		Message: "Session not found",
+	}
+
+	WrongStateErr = &Error{
+		Code:    "WRONG_STATE",
+		Message: "User is in the wrong state",
+	}
+
+	// Its details list the violations of the password policy
+	WeakPasswordErr = &Error{
+		Code:    "WEAK_PASSWORD",
+		Message: "Password too weak",
+	}
+)
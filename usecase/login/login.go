@@ -2,13 +2,24 @@ package login
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/kukinsula/boxy/entity"
 	loginEntity "github.com/kukinsula/boxy/entity/login"
 	"github.com/kukinsula/boxy/usecase"
 )
 
+// Every token Login issues is meant for its Users, its subject telling what
+// it may be used for.
+const (
+	TOKEN_AUDIENCE               = "Users"
+	TOKEN_ISSUER                 = "Login"
+	ACTIVATION_TOKEN_SUBJECT     = "Signup"
+	INITIALIZATION_TOKEN_SUBJECT = "Create"
+)
+
 type LoginGateway interface {
 	Create(
 		uuid string,
@@ -19,48 +30,64 @@ type LoginGateway interface {
 		uuid string,
 		ctx context.Context,
 		email, token string,
-		projection map[string]interface{}) (*loginEntity.User, error)
+		projection loginEntity.UserProjection) (*loginEntity.User, error)
 
 	FindByEmailAndInitializationToken(
 		uuid string,
 		ctx context.Context,
 		email, token string,
-		projection map[string]interface{}) (*loginEntity.User, error)
+		projection loginEntity.UserProjection) (*loginEntity.User, error)
 
 	FindByEmail(
 		uuid string,
 		ctx context.Context,
 		email string,
-		projection map[string]interface{}) (*loginEntity.User, error)
+		projection loginEntity.UserProjection) (*loginEntity.User, error)
+
+	FindByUUID(
+		uuid string,
+		ctx context.Context,
+		userUUID string,
+		projection loginEntity.UserProjection) (*loginEntity.User, error)
 
-	FindByAccessToken(
+	// Transaction runs operation atomically, with the context the gateway
+	// calls must use. It may run operation again on transient conflicts, and
+	// runs it in the current transaction if ctx already has one.
+	Transaction(
 		uuid string,
 		ctx context.Context,
-		token string,
-		projection map[string]interface{}) (*loginEntity.User, error)
+		operation func(ctx context.Context) error) error
 
+	// Update applies patch to the User identified by userUUID and returns it
+	// updated, nil if there is none.
 	Update(
 		uuid string,
 		ctx context.Context,
-		conditions map[string]interface{},
-		update map[string]interface{}) (*loginEntity.User, error)
+		userUUID string,
+		patch *UserPatch) (*loginEntity.User, error)
 }
 
 type Login struct {
-	loginGateway LoginGateway
-	tokener      *usecase.Tokener
-	passworder   *usecase.Passworder
+	loginGateway      LoginGateway
+	sessionGateway    SessionGateway
+	revocationGateway RevocationGateway
+	tokener           *usecase.Tokener
+	passworder        *usecase.Passworder
 }
 
 func NewLogin(
 	loginGateway LoginGateway,
+	sessionGateway SessionGateway,
+	revocationGateway RevocationGateway,
 	tokener *usecase.Tokener,
 	passworder *usecase.Passworder) *Login {
 
 	return &Login{
-		loginGateway: loginGateway,
-		tokener:      tokener,
-		passworder:   passworder,
+		loginGateway:      loginGateway,
+		sessionGateway:    sessionGateway,
+		revocationGateway: revocationGateway,
+		tokener:           tokener,
+		passworder:        passworder,
 	}
 }
 
@@ -88,10 +115,10 @@ func (login *Login) Signup(
 	params *CreateUserParams) (*loginEntity.User, error) {
 
 	token, err := login.tokener.Generate(usecase.GenerateTokenParams{
-		Audience:  "Users",
+		Audience:  TOKEN_AUDIENCE,
 		ExpiresIn: time.Hour * 24,
-		Issuer:    "Login",
-		Subject:   "Signup",
+		Issuer:    TOKEN_ISSUER,
+		Subject:   ACTIVATION_TOKEN_SUBJECT,
 		Email:     params.Email,
 		UUID:      uuid,
 	})
@@ -100,7 +127,9 @@ func (login *Login) Signup(
 		return nil, err
 	}
 
-	encrypted, err := login.passworder.Hash(params.Password)
+	encrypted, err := login.hash("Signup", params.Password,
+		params.Email, params.FirstName, params.LastName)
+
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +139,7 @@ func (login *Login) Signup(
 		Email(params.Email).
 		FirstName(params.FirstName).
 		LastName(params.LastName).
-		Password(string(encrypted)).
+		Password(encrypted).
 		State(loginEntity.ACTIVATING).
 		ActivationToken(token).
 		Build()
@@ -132,21 +161,21 @@ func (login *Login) CheckActivate(
 	ctx context.Context,
 	params *EmailAndTokenParams) error {
 
-	_, err := login.tokener.Verify(params.Token)
+	err := login.verify("CheckActivation", ACTIVATION_TOKEN_SUBJECT, params.Token)
 	if err != nil {
 		return err
 	}
 
 	user, err := login.loginGateway.FindByEmailAndActivationToken(
-		uuid, ctx, params.Email, params.Token, map[string]interface{}{})
+		uuid, ctx, params.Email, params.Token, loginEntity.UserProjection{loginEntity.USER_UUID})
 
 	if err != nil {
 		return err
 	}
 
 	if user == nil {
-		return fmt.Errorf("CheckActivation failed: cannot find User with token %s",
-			params.Token)
+		return fmt.Errorf("CheckActivation failed: cannot find User with token %s: %w",
+			params.Token, UserNotFoundErr)
 	}
 
 	return nil
@@ -157,62 +186,99 @@ func (login *Login) Activate(
 	ctx context.Context,
 	params *EmailAndTokenParams) error {
 
-	_, err := login.tokener.Verify(params.Token)
+	err := login.verify("Activate", ACTIVATION_TOKEN_SUBJECT, params.Token)
 	if err != nil {
 		return err
 	}
 
-	user, err := login.loginGateway.FindByEmailAndActivationToken(uuid, ctx,
-		params.Email, params.Token, map[string]interface{}{"uuid": 1})
+	return login.loginGateway.Transaction(uuid, ctx, func(ctx context.Context) error {
+		user, err := login.loginGateway.FindByEmailAndActivationToken(uuid, ctx,
+			params.Email, params.Token, loginEntity.UserProjection{loginEntity.USER_UUID})
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			return err
+		}
 
-	if user == nil {
-		return fmt.Errorf("Activate failed: cannot find User with email %s", params.Email)
-	}
+		if user == nil {
+			return fmt.Errorf("Activate failed: cannot find User with email %s: %w",
+				params.Email, UserNotFoundErr)
+		}
 
-	_, err = login.loginGateway.Update(uuid, ctx,
-		map[string]interface{}{"uuid": user.UUID},
-		map[string]interface{}{
-			"$set":   map[string]interface{}{"state": loginEntity.VALID},
-			"$unset": map[string]interface{}{"activationToken": 1},
-		})
+		_, err = login.loginGateway.Update(uuid, ctx, user.UUID, NewUserPatch().
+			State(loginEntity.VALID).
+			Unset(loginEntity.USER_ACTIVATION_TOKEN))
 
-	return err
+		return err
+	})
 }
 
+// SigninParams are the credentials of a User and the device it signs in
+// from. IP is set by the transport, not by the client.
 type SigninParams struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
+	IP       string `json:"ip"`
 }
 
 func (params *SigninParams) String() string {
-	return fmt.Sprintf("Email: %s, Password: ******", params.Email)
+	return fmt.Sprintf("Email: %s, Password: ******, Device: %s, IP: %s",
+		params.Email, params.Device, params.IP)
 }
 
 type SigninResult struct {
-	UUID        string `json:"uuid""`
-	Email       string `json:"email"`
-	FirstName   string `json:"firstName"`
-	LastName    string `json:"lastName"`
-	AccessToken string `json:"access-token"`
+	UUID         string `json:"uuid"`
+	Email        string `json:"email"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	Session      string `json:"session"`
+	AccessToken  string `json:"access-token"`
+	RefreshToken string `json:"refresh-token,omitempty"`
 }
 
 func (result *SigninResult) String() string {
-	return fmt.Sprintf("UUID: %s, Email: %s, FirstName: %s, LastName: %s, AccessToken: %s",
-		result.UUID, result.Email, result.FirstName, result.LastName, result.AccessToken)
+	return fmt.Sprintf(
+		"UUID: %s, Email: %s, FirstName: %s, LastName: %s, Session: %s, AccessToken: %s",
+		result.UUID, result.Email, result.FirstName, result.LastName,
+		result.Session, result.AccessToken)
 }
 
+// Signin checks the credentials and opens a new Session, the other Sessions
+// of the User being left untouched.
 func (login *Login) Signin(
 	uuid string,
 	ctx context.Context,
 	params *SigninParams) (*SigninResult, error) {
 
+	var result *SigninResult
+
+	err := login.loginGateway.Transaction(uuid, ctx, func(ctx context.Context) error {
+		var err error
+		result, err = login.signin(uuid, ctx, params)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (login *Login) signin(
+	uuid string,
+	ctx context.Context,
+	params *SigninParams) (*SigninResult, error) {
+
 	user, err := login.loginGateway.FindByEmail(uuid, ctx, params.Email,
-		map[string]interface{}{
-			"uuid": 1, "email": 1, "firstName": 1, "lastName": 1, "password": 1,
+		loginEntity.UserProjection{
+			loginEntity.USER_UUID,
+			loginEntity.USER_EMAIL,
+			loginEntity.USER_FIRST_NAME,
+			loginEntity.USER_LAST_NAME,
+			loginEntity.USER_PASSWORD,
+			loginEntity.USER_STATE,
 		})
 
 	if err != nil {
@@ -220,55 +286,40 @@ func (login *Login) Signin(
 	}
 
 	if user == nil {
-		return nil, fmt.Errorf("Signin failed: cannot find User with email %s", params.Email)
+		return nil, fmt.Errorf("Signin failed: cannot find User with email %s: %w",
+			params.Email, UserNotFoundErr)
 	}
 
 	err = login.passworder.Compare([]byte(user.Password), []byte(params.Password))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Signin failed: wrong password for User with email %s: %w",
+			params.Email, InvalidCredentialsErr)
 	}
 
-	generate := false
-	if user.AccessToken == "" {
-		generate = true
-	} else {
-		_, err = login.tokener.Verify(user.AccessToken)
-		if err != nil {
-			generate = true
-		}
+	if user.State != loginEntity.VALID {
+		return nil, fmt.Errorf("Signin failed: User with email %s is in state %d: %w",
+			params.Email, user.State, WrongStateErr)
 	}
 
-	if generate {
-		user.AccessToken, err = login.tokener.Generate(usecase.GenerateTokenParams{
-			Audience:  "Users",
-			ExpiresIn: time.Hour * 24,
-			Issuer:    "Login",
-			Subject:   "Signin",
-			Email:     params.Email,
-		})
-
-		if err != nil {
-			return nil, err
-		}
+	session := &loginEntity.Session{
+		UUID:      entity.NewUUID(),
+		UserUUID:  user.UUID,
+		Device:    params.Device,
+		IP:        params.IP,
+		CreatedAt: now(),
 	}
 
-	_, err = login.loginGateway.Update(uuid, ctx,
-		map[string]interface{}{"uuid": user.UUID},
-		map[string]interface{}{"$set": map[string]interface{}{
-			"accessToken": user.AccessToken,
-		}})
+	refreshToken, err := login.rotate(user, session)
+	if err != nil {
+		return nil, err
+	}
 
+	_, err = login.sessionGateway.CreateSession(uuid, ctx, session)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SigninResult{
-		UUID:        user.UUID,
-		Email:       user.Email,
-		FirstName:   user.FirstName,
-		LastName:    user.LastName,
-		AccessToken: user.AccessToken,
-	}, nil
+	return signinResult(user, session, refreshToken), nil
 }
 
 type AccessTokenParams struct {
@@ -276,7 +327,7 @@ type AccessTokenParams struct {
 }
 
 func (params *AccessTokenParams) String() string {
-	return params.Token
+	return "Token: ******"
 }
 
 func (login *Login) Me(
@@ -284,30 +335,29 @@ func (login *Login) Me(
 	ctx context.Context,
 	params *AccessTokenParams) (*SigninResult, error) {
 
-	_, err := login.tokener.Verify(params.Token)
+	session, err := login.session("Me", uuid, ctx, params.Token)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := login.loginGateway.FindByAccessToken(uuid, ctx, params.Token,
-		map[string]interface{}{"uuid": 1, "email": 1, "firstName": 1, "lastName": 1})
+	user, err := login.loginGateway.FindByUUID(uuid, ctx, session.UserUUID,
+		loginEntity.UserProjection{
+			loginEntity.USER_UUID,
+			loginEntity.USER_EMAIL,
+			loginEntity.USER_FIRST_NAME,
+			loginEntity.USER_LAST_NAME,
+		})
 
 	if err != nil {
 		return nil, err
 	}
 
 	if user == nil {
-		return nil, fmt.Errorf("Me failed: cannot find user with access token %s",
-			params.Token)
+		return nil, fmt.Errorf("Me failed: cannot find user of session %s: %w",
+			session.UUID, InvalidTokenErr)
 	}
 
-	return &SigninResult{
-		UUID:        user.UUID,
-		Email:       user.Email,
-		FirstName:   user.FirstName,
-		LastName:    user.LastName,
-		AccessToken: params.Token,
-	}, nil
+	return signinResult(user, session, ""), nil
 }
 
 func (login *Login) Create(
@@ -316,10 +366,10 @@ func (login *Login) Create(
 	params CreateUserParams) (*loginEntity.User, error) {
 
 	token, err := login.tokener.Generate(usecase.GenerateTokenParams{
-		Audience:  "Users",
+		Audience:  TOKEN_AUDIENCE,
 		ExpiresIn: time.Hour * 24,
-		Issuer:    "Login",
-		Subject:   "Create",
+		Issuer:    TOKEN_ISSUER,
+		Subject:   INITIALIZATION_TOKEN_SUBJECT,
 		Email:     params.Email,
 		UUID:      uuid,
 	})
@@ -328,7 +378,9 @@ func (login *Login) Create(
 		return nil, err
 	}
 
-	encrypted, err := login.passworder.Hash(params.Password)
+	encrypted, err := login.hash("Create", params.Password,
+		params.Email, params.FirstName, params.LastName)
+
 	if err != nil {
 		return nil, err
 	}
@@ -336,7 +388,7 @@ func (login *Login) Create(
 	user := loginEntity.NewUserBuilder().
 		UUID(uuid).
 		Email(params.Email).
-		Password(string(encrypted)).
+		Password(encrypted).
 		InitializationToken(token).
 		State(loginEntity.INITIALIZING).
 		Build()
@@ -349,13 +401,13 @@ func (login *Login) CheckInitialization(
 	ctx context.Context,
 	email, token string) error {
 
-	_, err := login.tokener.Verify(token)
+	err := login.verify("CheckInitialization", INITIALIZATION_TOKEN_SUBJECT, token)
 	if err != nil {
 		return err
 	}
 
 	user, err := login.loginGateway.FindByEmailAndInitializationToken(
-		uuid, ctx, email, token, loginEntity.UserFullProjection)
+		uuid, ctx, email, token, loginEntity.UserProjection{loginEntity.USER_UUID})
 
 	if err != nil {
 		return err
@@ -363,8 +415,8 @@ func (login *Login) CheckInitialization(
 
 	if user == nil {
 		return fmt.Errorf(
-			"CheckInitialization failed: cannot find user with email %s and initialization token %s",
-			email, token)
+			"CheckInitialization failed: cannot find user with email %s and initialization token %s: %w",
+			email, token, UserNotFoundErr)
 	}
 
 	return nil
@@ -375,44 +427,48 @@ func (login *Login) Initialize(
 	ctx context.Context,
 	params InitializeParams) (*loginEntity.User, error) {
 
-	_, err := login.tokener.Verify(params.Token)
+	err := login.verify("Initialize", INITIALIZATION_TOKEN_SUBJECT, params.Token)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := login.loginGateway.FindByEmailAndInitializationToken(
-		uuid, ctx, params.Email, params.Token, map[string]interface{}{"uuid": 1})
-
+	encrypted, err := login.hash("Initialize", params.Password, params.Email)
 	if err != nil {
 		return nil, err
 	}
 
-	if user == nil {
-		return nil, fmt.Errorf(
-			"Initialize failed: cannot find user with email %s and initialization token %s",
-			params.Email, params.Token)
-	}
+	var user *loginEntity.User
 
-	encrypted, err := login.passworder.Hash(params.Password)
-	if err != nil {
-		return nil, err
-	}
+	err = login.loginGateway.Transaction(uuid, ctx, func(ctx context.Context) error {
+		found, err := login.loginGateway.FindByEmailAndInitializationToken(uuid, ctx,
+			params.Email, params.Token, loginEntity.UserProjection{loginEntity.USER_UUID})
 
-	user, err = login.loginGateway.Update(uuid, ctx,
-		map[string]interface{}{"uuid": user.UUID},
-		map[string]interface{}{
-			"$set": map[string]interface{}{
-				"state":    loginEntity.VALID,
-				"password": encrypted,
-			},
-			"$unset": map[string]interface{}{"initializationToken": 1},
-		})
+		if err != nil {
+			return err
+		}
+
+		if found == nil {
+			return fmt.Errorf(
+				"Initialize failed: cannot find user with email %s and initialization token %s: %w",
+				params.Email, params.Token, UserNotFoundErr)
+		}
+
+		user, err = login.loginGateway.Update(uuid, ctx, found.UUID, NewUserPatch().
+			State(loginEntity.VALID).
+			Password(encrypted).
+			Unset(loginEntity.USER_INITIALIZATION_TOKEN))
+
+		return err
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	user.AccessToken = params.Token
+	if user == nil {
+		return nil, fmt.Errorf("Initialize failed: cannot find user with email %s: %w",
+			params.Email, UserNotFoundErr)
+	}
 
 	return user, nil
 }
@@ -422,32 +478,70 @@ func (login *Login) Logout(
 	ctx context.Context,
 	params *AccessTokenParams) error {
 
-	_, err := login.tokener.Verify(params.Token)
+	session, err := login.session("Logout", uuid, ctx, params.Token)
 	if err != nil {
 		return err
 	}
 
-	user, err := login.loginGateway.FindByAccessToken(
-		uuid, ctx, params.Token, loginEntity.UserFullProjection)
-
+	_, err = login.sessionGateway.DeleteSession(uuid, ctx, session.UserUUID, session.UUID)
 	if err != nil {
 		return err
 	}
 
-	if user == nil {
-		return fmt.Errorf("Logout failed: cannot find user with access token %s",
-			params.Token)
+	return login.revoke(uuid, ctx, session.AccessToken)
+}
+
+// JWKS returns the public keys verifying the tokens Login issues.
+func (login *Login) JWKS(uuid string, ctx context.Context) *usecase.JWKS {
+	return login.tokener.JWKS()
+}
+
+// hash hashes password once it satisfies the password policy. Personal infos
+// of its User must not appear in it.
+func (login *Login) hash(
+	operation, password string,
+	personalInfos ...string) (string, error) {
+
+	err := login.passworder.Check(password, personalInfos...)
+
+	var weak *usecase.PasswordError
+	if errors.As(err, &weak) {
+		return "", fmt.Errorf("%s failed: %s: %w", operation, err,
+			WeakPasswordErr.WithDetails(map[string]interface{}{
+				"violations": weak.Violations,
+			}))
 	}
 
-	_, err = login.loginGateway.Update(uuid, ctx,
-		map[string]interface{}{"uuid": user.UUID},
-		map[string]interface{}{"$unset": map[string]interface{}{"accessToken": 1}})
+	if err != nil {
+		return "", err
+	}
 
+	encrypted, err := login.passworder.Hash(password)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	user.AccessToken = ""
+	return string(encrypted), nil
+}
 
-	return nil
+// verify checks that token was issued by Login for subject.
+func (login *Login) verify(operation, subject, token string) error {
+	_, err := login.tokener.Verify(token, tokenParams(subject))
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, usecase.ExpiredTokenErr) {
+		return fmt.Errorf("%s failed: %s: %w", operation, err, TokenExpiredErr)
+	}
+
+	return fmt.Errorf("%s failed: %s: %w", operation, err, InvalidTokenErr)
+}
+
+func tokenParams(subject string) usecase.VerifyTokenParams {
+	return usecase.VerifyTokenParams{
+		Audience: TOKEN_AUDIENCE,
+		Issuer:   TOKEN_ISSUER,
+		Subject:  subject,
+	}
 }
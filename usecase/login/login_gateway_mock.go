@@ -3,168 +3,283 @@ package login
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 
 	loginEntity "github.com/kukinsula/boxy/entity/login"
 )
 
-type loginGatewayMock struct {
-	users map[string]*loginEntity.User
-	mutex *sync.RWMutex
+// LoginGatewayMock is an in-memory LoginGateway honouring projections, fields
+// being named after their bson tags. It is the SessionGateway as well.
+type LoginGatewayMock struct {
+	users       map[string]*loginEntity.User
+	sessions    map[string]*loginEntity.Session
+	mutex       *sync.RWMutex
+	transaction *sync.Mutex
 }
 
-func newUserDatabaseMock() *loginGatewayMock {
-	return &loginGatewayMock{
-		users: map[string]*loginEntity.User{},
-		mutex: &sync.RWMutex{},
+type mockTransactionKey struct{}
+
+func NewLoginGatewayMock() *LoginGatewayMock {
+	return &LoginGatewayMock{
+		users:       map[string]*loginEntity.User{},
+		sessions:    map[string]*loginEntity.Session{},
+		mutex:       &sync.RWMutex{},
+		transaction: &sync.Mutex{},
 	}
 }
 
-func (database *loginGatewayMock) Create(
+// Transaction runs the transactions one at a time, and restores the Users
+// and Sessions as they were when operation fails.
+func (database *LoginGatewayMock) Transaction(
+	uuid string,
 	ctx context.Context,
-	user *loginEntity.User) (*loginEntity.User, error) {
+	operation func(ctx context.Context) error) error {
 
-	database.mutex.Lock()
-	defer database.mutex.Unlock()
+	if ctx.Value(mockTransactionKey{}) != nil {
+		return operation(ctx)
+	}
 
-	result, err := database.findByEmail(user.Email)
-	if err != nil {
-		return nil, err
+	database.transaction.Lock()
+	defer database.transaction.Unlock()
+
+	database.mutex.RLock()
+	users := make(map[string]*loginEntity.User, len(database.users))
+	for key, user := range database.users {
+		copied := *user
+		users[key] = &copied
 	}
 
-	if result != nil {
-		return nil, fmt.Errorf("Create failed: email %s is already used", user.Email)
+	sessions := make(map[string]*loginEntity.Session, len(database.sessions))
+	for key, session := range database.sessions {
+		copied := *session
+		sessions[key] = &copied
 	}
+	database.mutex.RUnlock()
 
-	database.users[user.UUID] = user
+	err := operation(context.WithValue(ctx, mockTransactionKey{}, true))
+	if err != nil {
+		database.mutex.Lock()
+		database.users = users
+		database.sessions = sessions
+		database.mutex.Unlock()
+	}
 
-	return user, nil
+	return err
 }
 
-func (database *loginGatewayMock) findUserBy(
-	comparator func(user *loginEntity.User) bool) (*loginEntity.User, error) {
+func (database *LoginGatewayMock) Create(
+	uuid string,
+	ctx context.Context,
+	user *loginEntity.User) (*loginEntity.User, error) {
 
-	for _, user := range database.users {
-		if comparator(user) {
-			return user, nil
-		}
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	if database.findByEmail(user.Email) != nil {
+		return nil, fmt.Errorf("LoginGatewayMock.Create failed: email %s: %w",
+			user.Email, EmailTakenErr)
 	}
 
-	return nil, nil
+	if _, ok := database.users[user.UUID]; ok {
+		return nil, fmt.Errorf("LoginGatewayMock.Create failed: UUID %s already exists",
+			user.UUID)
+	}
+
+	stored := *user
+	database.users[user.UUID] = &stored
+
+	return user, nil
 }
 
-func (database *loginGatewayMock) findByEmailAndActivationToken(email, token string) (*loginEntity.User, error) {
-	return database.findUserBy(func(user *loginEntity.User) bool {
+func (database *LoginGatewayMock) FindByEmailAndActivationToken(
+	uuid string,
+	ctx context.Context,
+	email, token string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return database.find(projection, func(user *loginEntity.User) bool {
 		return user.Email == email && user.ActivationToken == token
 	})
 }
 
-func (database *loginGatewayMock) findByEmailAndInitializationToken(email, token string) (*loginEntity.User, error) {
-	return database.findUserBy(func(user *loginEntity.User) bool {
+func (database *LoginGatewayMock) FindByEmailAndInitializationToken(
+	uuid string,
+	ctx context.Context,
+	email, token string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return database.find(projection, func(user *loginEntity.User) bool {
 		return user.Email == email && user.InitializationToken == token
 	})
 }
 
-func (database *loginGatewayMock) findByEmail(email string) (*loginEntity.User, error) {
-	return database.findUserBy(func(user *loginEntity.User) bool {
+func (database *LoginGatewayMock) FindByEmail(
+	uuid string,
+	ctx context.Context,
+	email string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return database.find(projection, func(user *loginEntity.User) bool {
 		return user.Email == email
 	})
 }
 
-func (database *loginGatewayMock) findByAccessToken(token string) (*loginEntity.User, error) {
-	return database.findUserBy(func(user *loginEntity.User) bool {
-		return user.AccessToken == token
+func (database *LoginGatewayMock) FindByUUID(
+	uuid string,
+	ctx context.Context,
+	userUUID string,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
+
+	return database.find(projection, func(user *loginEntity.User) bool {
+		return user.UUID == userUUID
 	})
 }
 
-func (database *loginGatewayMock) FindByEmailAndActivationToken(
+func (database *LoginGatewayMock) Update(
+	uuid string,
 	ctx context.Context,
-	email, token string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
+	userUUID string,
+	patch *UserPatch) (*loginEntity.User, error) {
 
-	database.mutex.RLock()
-	defer database.mutex.RUnlock()
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	user, ok := database.users[userUUID]
+	if !ok {
+		return nil, nil
+	}
 
-	return database.findByEmailAndActivationToken(email, token)
+	updated := *user
+
+	for name, value := range patch.Sets() {
+		field, err := userField(&updated, string(name))
+		if err != nil {
+			return nil, err
+		}
+
+		err = assign(field, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range patch.Unsets() {
+		field, err := userField(&updated, string(name))
+		if err != nil {
+			return nil, err
+		}
+
+		field.Set(reflect.Zero(field.Type()))
+	}
+
+	*user = updated
+	result := updated
+
+	return &result, nil
 }
 
-func (database *loginGatewayMock) FindByEmailAndInitializationToken(
-	ctx context.Context,
-	email, token string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
+func (database *LoginGatewayMock) find(
+	projection loginEntity.UserProjection,
+	comparator func(user *loginEntity.User) bool) (*loginEntity.User, error) {
 
 	database.mutex.RLock()
 	defer database.mutex.RUnlock()
 
-	return database.findByEmailAndInitializationToken(email, token)
+	user := database.findUserBy(comparator)
+	if user == nil {
+		return nil, nil
+	}
+
+	return project(user, projection)
 }
 
-func (database *loginGatewayMock) FindByEmail(
-	ctx context.Context,
-	email string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
+func (database *LoginGatewayMock) findUserBy(
+	comparator func(user *loginEntity.User) bool) *loginEntity.User {
 
-	database.mutex.RLock()
-	defer database.mutex.RUnlock()
+	for _, user := range database.users {
+		if comparator(user) {
+			return user
+		}
+	}
 
-	return database.findByEmail(email)
+	return nil
 }
 
-func (database *loginGatewayMock) FindByAccessToken(
-	ctx context.Context,
-	token string,
-	projection map[string]interface{}) (*loginEntity.User, error) {
+func (database *LoginGatewayMock) findByEmail(email string) *loginEntity.User {
+	return database.findUserBy(func(user *loginEntity.User) bool {
+		return user.Email == email
+	})
+}
 
+func (database *LoginGatewayMock) String() string {
 	database.mutex.RLock()
 	defer database.mutex.RUnlock()
 
-	return database.findByAccessToken(token)
-}
-
-func (database *loginGatewayMock) update(
-	uuid string,
-	conditions map[string]interface{}) error {
+	str := ""
 
-	user, ok := database.users[uuid]
-	if !ok {
-		return fmt.Errorf("Upate failed: cannot find User with UUID %s", user.UUID)
+	for _, user := range database.users {
+		str = fmt.Sprintf("%s%#v\n", str, user)
 	}
 
-	database.users[user.UUID] = user
+	for _, session := range database.sessions {
+		str = fmt.Sprintf("%s%#v\n", str, session)
+	}
 
-	return nil
+	return str
 }
 
-func (database *loginGatewayMock) Update(
-	ctx context.Context,
-	conditions map[string]interface{},
-	update map[string]interface{}) error {
+// project copies the fields of user selected by projection, every field if
+// it is empty.
+func project(
+	user *loginEntity.User,
+	projection loginEntity.UserProjection) (*loginEntity.User, error) {
 
-	database.mutex.Lock()
-	defer database.mutex.Unlock()
-
-	rawUUID, ok := conditions["uuid"]
-	if !ok {
-		return fmt.Errorf("Update failed: cnnot find UUID in conditions %v", conditions)
+	result := *user
+	if len(projection) == 0 {
+		return &result, nil
 	}
 
-	uuid, ok := rawUUID.(string)
-	if !ok {
-		return fmt.Errorf("Update failed: %v is not a string", rawUUID)
+	result = loginEntity.User{}
+
+	for _, name := range projection {
+		source, err := userField(user, string(name))
+		if err != nil {
+			return nil, err
+		}
+
+		target, _ := userField(&result, string(name))
+		target.Set(source)
 	}
 
-	return database.update(uuid, update)
+	return &result, nil
 }
 
-func (database *loginGatewayMock) String() string {
-	database.mutex.RLock()
-	defer database.mutex.RUnlock()
+// userField returns the field of user named name in bson.
+func userField(user *loginEntity.User, name string) (reflect.Value, error) {
+	value := reflect.ValueOf(user).Elem()
+	kind := value.Type()
 
-	str := ""
+	for index := 0; index < kind.NumField(); index++ {
+		tag := strings.Split(kind.Field(index).Tag.Get("bson"), ",")[0]
 
-	for _, user := range database.users {
-		str = fmt.Sprintf("%s%#v\n", str, user)
+		if tag == name {
+			return value.Field(index), nil
+		}
 	}
 
-	return str
+	return reflect.Value{}, fmt.Errorf("LoginGatewayMock: User has no field %s", name)
+}
+
+func assign(field reflect.Value, value interface{}) error {
+	source := reflect.ValueOf(value)
+
+	if !source.IsValid() || !source.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("LoginGatewayMock: cannot assign %v to a %s", value, field.Type())
+	}
+
+	field.Set(source.Convert(field.Type()))
+
+	return nil
 }
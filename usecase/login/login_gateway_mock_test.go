@@ -0,0 +1,23 @@
+package login_test
+
+import (
+	"testing"
+
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+	"github.com/kukinsula/boxy/usecase/login/logintest"
+)
+
+func TestLoginGatewayMock(t *testing.T) {
+	logintest.LoginGatewayContract(t, func(t *testing.T) loginUsecase.LoginGateway {
+		return loginUsecase.NewLoginGatewayMock()
+	})
+}
+
+func TestSessionGatewayMock(t *testing.T) {
+	logintest.SessionGatewayContract(t,
+		func(t *testing.T) (loginUsecase.LoginGateway, loginUsecase.SessionGateway) {
+			gateway := loginUsecase.NewLoginGatewayMock()
+
+			return gateway, gateway
+		})
+}
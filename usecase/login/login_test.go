@@ -2,274 +2,794 @@ package login
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kukinsula/boxy/entity"
 	loginEntity "github.com/kukinsula/boxy/entity/login"
-	mongo "github.com/kukinsula/boxy/framework/mongo"
 	"github.com/kukinsula/boxy/usecase"
 )
 
-// func TestMockLogin(t *testing.T) {
-// 	database := newUserDatabaseMock()
+const (
+	EMAIL     = "titi@mail.io"
+	BAD_EMAIL = "toto@mail.io"
+	PASSWORD  = "Azerty1234."
+)
 
-// 	testLogin(t, database)
-// }
+// fixture is a Login backed by a LoginGatewayMock, with tokens signed by
+// another secret (invalid) and already expired. Tokens signed by the right
+// secret but unknown to the gateway are absent.
+type fixture struct {
+	t           *testing.T
+	uuid        string
+	ctx         context.Context
+	login       *Login
+	tokener     *usecase.Tokener
+	gateway     *LoginGatewayMock
+	revocations *RevocationGatewayMock
+	invalid     string
+	expired     string
+}
 
-func TestMongoLogin(t *testing.T) {
-	ctx := context.Background()
-	database, err := mongo.NewDatabase(mongo.NewDatabaseParams{
-		Context:  ctx,
-		URI:      "mongodb://localhost:27017",
-		Database: "boxy_test",
-		Logger: func(uuid interface{},
-			collection, operation string,
-			datas ...interface{}) error {
+func newFixture(t *testing.T) *fixture {
+	tokener := usecase.NewTokener("TopSecret")
+	gateway := NewLoginGatewayMock()
+	revocations := NewRevocationGatewayMock()
+	uuid := entity.NewUUID()
 
-			_, err := fmt.Printf("[%s] %s.%s(%v)\n",
-				[]interface{}{uuid, collection, operation, datas}...)
+	return &fixture{
+		t:           t,
+		uuid:        uuid,
+		ctx:         context.Background(),
+		login:       NewLogin(gateway, gateway, revocations, tokener, usecase.NewPassworder(4)),
+		tokener:     tokener,
+		gateway:     gateway,
+		revocations: revocations,
+		invalid:     generate(t, usecase.NewTokener("WrongSecret"), ACCESS_TOKEN_SUBJECT, time.Hour),
+		expired:     generate(t, tokener, ACCESS_TOKEN_SUBJECT, -time.Hour),
+	}
+}
 
-			return err
-		},
+func generate(
+	t *testing.T,
+	tokener *usecase.Tokener,
+	subject string,
+	expiresIn time.Duration) string {
+
+	token, err := tokener.Generate(usecase.GenerateTokenParams{
+		Audience:  TOKEN_AUDIENCE,
+		ExpiresIn: expiresIn,
+		Issuer:    TOKEN_ISSUER,
+		Subject:   subject,
+		Email:     EMAIL,
 	})
 
 	if err != nil {
-		t.Errorf("NewDatabase failed: %s", err)
+		t.Errorf("Tokener.Generate should not fail: %s", err)
 		t.FailNow()
 	}
 
-	err = database.Drop(ctx)
+	return token
+}
 
-	if err != nil {
-		t.Errorf("Drop failed: %s", err)
-		t.FailNow()
-	}
+// absent returns a token for subject unknown to the gateway.
+func (fixture *fixture) absent(subject string) string {
+	return generate(fixture.t, fixture.tokener, subject, time.Hour)
+}
 
-	err = database.Init(ctx)
+func (fixture *fixture) signup() *loginEntity.User {
+	user, err := fixture.login.Signup(fixture.uuid, fixture.ctx, &CreateUserParams{
+		Email:     EMAIL,
+		Password:  PASSWORD,
+		FirstName: "Ti",
+		LastName:  "Ti",
+	})
 
-	if err != nil {
-		t.Errorf("Drop failed: %s", err)
-		t.FailNow()
-	}
+	fixture.must("Signup", err)
+
+	return user
+}
 
-	testLogin(t, database.User)
+func (fixture *fixture) activate() {
+	user := fixture.signup()
 
-	// err = database.Drop(ctx)
-	// if err != nil {
-	// 	t.Errorf("Drop failed: %s", err)
-	// 	t.FailNow()
-	// }
+	fixture.must("Activate", fixture.login.Activate(fixture.uuid, fixture.ctx,
+		&EmailAndTokenParams{Email: EMAIL, Token: user.ActivationToken}))
 }
 
-func testLogin(t *testing.T, database UserDatabase) {
-	tokener := usecase.NewTokener("TopSecret")
-	fakeTokener := usecase.NewTokener("WrongSecret")
-	passworder := usecase.NewPassworder(10)
-	login := NewLoginUseCase(database, tokener, passworder)
+func (fixture *fixture) signin() *SigninResult {
+	fixture.activate()
 
-	uuid := entity.NewUUID()
-	ctx := context.WithValue(context.Background(), "id", uuid)
-
-	builder := loginEntity.NewUserBuilder()
-	builder.Email("titi@mail.io").Password("Azerty1234.")
-	builder.FirstName("Ti").LastName("Ti")
-	user := builder.Build()
-
-	badEmail := "toto@mail.io"
-
-	absentToken, err := tokener.Generate(usecase.GenerateTokenParams{
-		Audience:  "Users",
-		ExpiresIn: time.Hour * 24,
-		Issuer:    "Login",
-		Subject:   "Signup",
-		Email:     user.Email,
-		UUID:      uuid,
-	})
+	return fixture.open("laptop")
+}
 
-	if err != nil {
-		t.Errorf("Tokener (absent token) should not fail: %s", err)
-		t.FailNow()
-	}
+// open signs the activated User in from another device.
+func (fixture *fixture) open(device string) *SigninResult {
+	result, err := fixture.login.Signin(fixture.uuid, fixture.ctx,
+		&SigninParams{Email: EMAIL, Password: PASSWORD, Device: device, IP: "127.0.0.1"})
 
-	invalidToken, err := fakeTokener.Generate(usecase.GenerateTokenParams{
-		Audience:  "Users",
-		ExpiresIn: time.Hour * 24,
-		Issuer:    "Login",
-		Subject:   "Signup",
-		Email:     user.Email,
-		UUID:      uuid,
-	})
+	fixture.must("Signin", err)
 
-	if err != nil {
-		t.Errorf("Tokener (invalid token) should not fail: %s", err)
-		t.FailNow()
-	}
+	return result
+}
 
-	// Signup
+func (fixture *fixture) refresh(token string) *SigninResult {
+	result, err := fixture.login.Refresh(fixture.uuid, fixture.ctx,
+		&RefreshParams{Token: token, IP: "127.0.0.2"})
 
-	result, err := login.Signup(ctx, CreateUserParams{
-		Email:     user.Email,
-		Password:  string(user.Password),
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-	})
+	fixture.must("Refresh", err)
 
-	if err != nil {
-		t.Errorf("Signup should not fail: %s", err)
-		t.FailNow()
-	}
+	return result
+}
 
-	if result == nil {
-		t.Error("Signup should return a valid User")
-		t.FailNow()
-	}
+func (fixture *fixture) me(token string) error {
+	_, err := fixture.login.Me(fixture.uuid, fixture.ctx, &AccessTokenParams{Token: token})
+
+	return err
+}
 
-	tmp, err := login.Signup(ctx, CreateUserParams{
-		Email:     user.Email,
-		Password:  string(user.Password),
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
+func (fixture *fixture) create() *loginEntity.User {
+	user, err := fixture.login.Create(fixture.uuid, fixture.ctx, CreateUserParams{
+		Email:    EMAIL,
+		Password: PASSWORD,
 	})
 
-	if err == nil || tmp != nil {
-		t.Errorf("Signup should fail because User %s should already exist", user.Email)
-		t.FailNow()
-	}
+	fixture.must("Create", err)
 
-	// CheckActivation
+	return user
+}
 
-	err = login.CheckActivation(ctx, user.Email, result.ActivationToken)
-	if err != nil {
-		t.Errorf("CheckActivation should not fail: %s", err)
-		t.FailNow()
-	}
+func (fixture *fixture) user() *loginEntity.User {
+	user, err := fixture.gateway.FindByEmail(fixture.uuid, fixture.ctx, EMAIL,
+		loginEntity.UserFullProjection)
 
-	err = login.CheckActivation(ctx, user.Email, absentToken)
-	if err == nil {
-		t.Errorf("CheckActivation should fail: %s token should not exist", absentToken)
-		t.FailNow()
-	}
+	fixture.must("FindByEmail", err)
 
-	err = login.CheckActivation(ctx, user.Email, invalidToken)
-	if err == nil {
-		t.Errorf("CheckActivation should fail: %s token should not be valid", invalidToken)
-		t.FailNow()
+	if user == nil {
+		fixture.t.Errorf("User %s should exist", EMAIL)
+		fixture.t.FailNow()
 	}
 
-	err = login.CheckActivation(ctx, badEmail, result.ActivationToken)
-	if err == nil {
-		t.Errorf("CheckActivation should fail: user with email %s should not be found", badEmail)
-		t.FailNow()
-	}
+	return user
+}
+
+// revoked tells whether the ID of token is revoked.
+func (fixture *fixture) revoked(token string) bool {
+	claims, err := fixture.tokener.Verify(token, tokenParams(ACCESS_TOKEN_SUBJECT))
+	fixture.must("Verify", err)
 
-	// Activate
+	revoked, err := fixture.revocations.IsRevoked(fixture.uuid, fixture.ctx, claims.ID)
+	fixture.must("IsRevoked", err)
 
-	token := result.ActivationToken
+	return revoked
+}
 
-	err = login.Activate(ctx, user.Email, token)
+func (fixture *fixture) must(operation string, err error) {
 	if err != nil {
-		t.Errorf("Activate should not fail: %s", err)
-		t.FailNow()
+		fixture.t.Errorf("%s should not fail: %s", operation, err)
+		fixture.t.FailNow()
 	}
+}
 
-	err = login.Activate(ctx, user.Email, token)
-	if err == nil {
-		t.Errorf("Activate should fail: user with email %s already activated", user.Email)
-		t.FailNow()
-	}
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(fixture *fixture) error
+		err  error
+	}{
+		{
+			name: "Signup",
+			run: func(fixture *fixture) error {
+				user := fixture.signup()
+				stored := fixture.user()
+
+				if stored.State != loginEntity.ACTIVATING || stored.ActivationToken != user.ActivationToken {
+					fixture.t.Errorf("Signup stored %s", stored)
+				}
+
+				if stored.Password == PASSWORD {
+					fixture.t.Error("Signup should not store the password in clear")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "Signup with a taken email",
+			run: func(fixture *fixture) error {
+				fixture.signup()
 
-	err = login.Activate(ctx, user.Email, absentToken)
-	if err == nil {
-		t.Errorf("Activate should fail: %s should not be found", absentToken)
-		t.FailNow()
-	}
+				_, err := fixture.login.Signup(fixture.uuid, fixture.ctx,
+					&CreateUserParams{Email: EMAIL, Password: PASSWORD})
 
-	err = login.Activate(ctx, user.Email, invalidToken)
-	if err == nil {
-		t.Errorf("Activate should fail: %s should not be valid", invalidToken)
-		t.FailNow()
-	}
+				return err
+			},
+			err: EmailTakenErr,
+		},
+		{
+			name: "Signup with a weak password",
+			run: func(fixture *fixture) error {
+				_, err := fixture.login.Signup(fixture.uuid, fixture.ctx,
+					&CreateUserParams{Email: EMAIL, Password: "Titi123456"})
+
+				var failure *Error
+				if errors.As(err, &failure) && len(failure.Details) == 0 {
+					fixture.t.Error("Signup should detail the violations")
+				}
+
+				stored, _ := fixture.gateway.FindByEmail(fixture.uuid, fixture.ctx, EMAIL, nil)
+				if stored != nil {
+					fixture.t.Error("Signup should not store the User")
+				}
+
+				return err
+			},
+			err: WeakPasswordErr,
+		},
+		{
+			name: "CheckActivate",
+			run: func(fixture *fixture) error {
+				user := fixture.signup()
+
+				return fixture.login.CheckActivate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: user.ActivationToken})
+			},
+		},
+		{
+			name: "CheckActivate with an absent token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.CheckActivate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.absent(ACTIVATION_TOKEN_SUBJECT)})
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "CheckActivate with an access token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.CheckActivate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.absent(ACCESS_TOKEN_SUBJECT)})
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "CheckActivate with an invalid token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.CheckActivate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.invalid})
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "CheckActivate with an expired token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.CheckActivate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.expired})
+			},
+			err: TokenExpiredErr,
+		},
+		{
+			name: "CheckActivate with a bad email",
+			run: func(fixture *fixture) error {
+				user := fixture.signup()
+
+				return fixture.login.CheckActivate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: BAD_EMAIL, Token: user.ActivationToken})
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "Activate",
+			run: func(fixture *fixture) error {
+				fixture.activate()
+				stored := fixture.user()
+
+				if stored.State != loginEntity.VALID || stored.ActivationToken != "" {
+					fixture.t.Errorf("Activate stored %s", stored)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "Activate twice",
+			run: func(fixture *fixture) error {
+				user := fixture.signup()
+				params := &EmailAndTokenParams{Email: EMAIL, Token: user.ActivationToken}
 
-	// Signin
+				fixture.must("Activate", fixture.login.Activate(fixture.uuid, fixture.ctx, params))
 
-	result, err = login.Signin(ctx, SigninParams{
-		Email:    user.Email,
-		Password: string(user.Password),
-	})
-	if err != nil {
-		t.Errorf("Signin should not fail: %s", err)
-		t.FailNow()
-	}
+				return fixture.login.Activate(fixture.uuid, fixture.ctx, params)
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "Activate with an absent token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.Activate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.absent(ACTIVATION_TOKEN_SUBJECT)})
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "Activate with an invalid token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.Activate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.invalid})
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Activate with an expired token",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				return fixture.login.Activate(fixture.uuid, fixture.ctx,
+					&EmailAndTokenParams{Email: EMAIL, Token: fixture.expired})
+			},
+			err: TokenExpiredErr,
+		},
+		{
+			name: "Signin",
+			run: func(fixture *fixture) error {
+				result := fixture.signin()
 
-	if result == nil {
-		t.Error("Signin should return a valid User")
-		t.FailNow()
-	}
+				if result.Email != EMAIL || result.AccessToken == "" || result.RefreshToken == "" {
+					fixture.t.Errorf("Signin returned %s", result)
+				}
 
-	badPassword := "BadPassword"
-	tmp, err = login.Signin(ctx, SigninParams{
-		Email:    user.Email,
-		Password: badPassword,
-	})
-	if err == nil || result == nil {
-		t.Errorf("Signin should fail: password %s is incorrect", badPassword)
-		t.FailNow()
-	}
+				session, err := fixture.gateway.FindSessionByAccessToken(fixture.uuid, fixture.ctx,
+					result.AccessToken)
 
-	tmp, err = login.Signin(ctx, SigninParams{
-		Email:    badEmail,
-		Password: user.Password,
-	})
-	if err == nil || result == nil {
-		t.Errorf("Signin should fail: user %s doesn't exist", badEmail)
-		t.FailNow()
-	}
+				fixture.must("FindSessionByAccessToken", err)
 
-	// Me
+				if session == nil || session.UUID != result.Session ||
+					session.RefreshToken != hashToken(result.RefreshToken) ||
+					session.Device != "laptop" || session.IP != "127.0.0.1" {
 
-	result, err = login.Me(ctx, result.AccessToken)
-	if err != nil {
-		t.Errorf("Me should not fail: %s", err)
-		t.FailNow()
-	}
+					fixture.t.Errorf("Signin stored session %v", session)
+				}
 
-	if result == nil {
-		t.Error("Me should return a valid User")
-		t.FailNow()
-	}
+				return nil
+			},
+		},
+		{
+			name: "Signin from several devices",
+			run: func(fixture *fixture) error {
+				laptop := fixture.signin()
+				phone := fixture.open("phone")
 
-	tmp, err = login.Me(ctx, absentToken)
-	if err == nil || result == nil {
-		t.Errorf("Me should fail: access token %s is absent", absentToken)
-		t.FailNow()
-	}
+				if laptop.Session == phone.Session || laptop.AccessToken == phone.AccessToken {
+					fixture.t.Errorf("Signin should open another session, got %s", phone)
+				}
 
-	tmp, err = login.Me(ctx, invalidToken)
-	if err == nil || result == nil {
-		t.Errorf("Me should fail: access token %s is invalid", invalidToken)
-		t.FailNow()
-	}
+				fixture.must("Logout", fixture.login.Logout(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: phone.AccessToken}))
 
-	// Logout
+				return fixture.me(laptop.AccessToken)
+			},
+		},
+		{
+			name: "Signin before activation",
+			run: func(fixture *fixture) error {
+				fixture.signup()
 
-	token = result.AccessToken
+				_, err := fixture.login.Signin(fixture.uuid, fixture.ctx,
+					&SigninParams{Email: EMAIL, Password: PASSWORD})
 
-	result, err = login.Logout(ctx, token)
-	if err != nil {
-		t.Errorf("Logout should not fail: %s", err)
-		t.FailNow()
-	}
+				return err
+			},
+			err: WrongStateErr,
+		},
+		{
+			name: "Signin with a bad password",
+			run: func(fixture *fixture) error {
+				fixture.activate()
 
-	if result == nil {
-		t.Errorf("Logout should return a valid User")
-		t.FailNow()
+				_, err := fixture.login.Signin(fixture.uuid, fixture.ctx,
+					&SigninParams{Email: EMAIL, Password: "BadPassword"})
+
+				return err
+			},
+			err: InvalidCredentialsErr,
+		},
+		{
+			name: "Signin with a bad email",
+			run: func(fixture *fixture) error {
+				fixture.activate()
+
+				_, err := fixture.login.Signin(fixture.uuid, fixture.ctx,
+					&SigninParams{Email: BAD_EMAIL, Password: PASSWORD})
+
+				return err
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "Me",
+			run: func(fixture *fixture) error {
+				signin := fixture.signin()
+
+				result, err := fixture.login.Me(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: signin.AccessToken})
+
+				if err == nil && (result.UUID != signin.UUID || result.Email != EMAIL) {
+					fixture.t.Errorf("Me returned %s", result)
+				}
+
+				return err
+			},
+		},
+		{
+			name: "Me with an absent token",
+			run: func(fixture *fixture) error {
+				fixture.signin()
+
+				_, err := fixture.login.Me(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: fixture.absent(ACCESS_TOKEN_SUBJECT)})
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Me with an invalid token",
+			run: func(fixture *fixture) error {
+				fixture.signin()
+
+				_, err := fixture.login.Me(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: fixture.invalid})
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Me with an expired token",
+			run: func(fixture *fixture) error {
+				fixture.signin()
+
+				_, err := fixture.login.Me(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: fixture.expired})
+
+				return err
+			},
+			err: TokenExpiredErr,
+		},
+		{
+			name: "Logout",
+			run: func(fixture *fixture) error {
+				params := &AccessTokenParams{Token: fixture.signin().AccessToken}
+
+				fixture.must("Logout", fixture.login.Logout(fixture.uuid, fixture.ctx, params))
+
+				_, err := fixture.login.Me(fixture.uuid, fixture.ctx, params)
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Logout revokes the access token",
+			run: func(fixture *fixture) error {
+				laptop := fixture.signin()
+				phone := fixture.open("phone")
+
+				fixture.must("Logout", fixture.login.Logout(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: laptop.AccessToken}))
+
+				if !fixture.revoked(laptop.AccessToken) || fixture.revoked(phone.AccessToken) {
+					fixture.t.Error("Logout should revoke the access token of its session only")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "Logout twice",
+			run: func(fixture *fixture) error {
+				params := &AccessTokenParams{Token: fixture.signin().AccessToken}
+
+				fixture.must("Logout", fixture.login.Logout(fixture.uuid, fixture.ctx, params))
+
+				return fixture.login.Logout(fixture.uuid, fixture.ctx, params)
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Refresh",
+			run: func(fixture *fixture) error {
+				signin := fixture.signin()
+				refresh := fixture.refresh(signin.RefreshToken)
+
+				if refresh.Session != signin.Session ||
+					refresh.AccessToken == signin.AccessToken ||
+					refresh.RefreshToken == signin.RefreshToken {
+
+					fixture.t.Errorf("Refresh returned %s", refresh)
+				}
+
+				fixture.must("Me", fixture.me(refresh.AccessToken))
+
+				if !fixture.revoked(signin.AccessToken) || fixture.revoked(refresh.AccessToken) {
+					fixture.t.Error("Refresh should revoke the previous access token only")
+				}
+
+				return fixture.me(signin.AccessToken)
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Refresh with a reused token",
+			run: func(fixture *fixture) error {
+				signin := fixture.signin()
+				refresh := fixture.refresh(signin.RefreshToken)
+
+				_, err := fixture.login.Refresh(fixture.uuid, fixture.ctx,
+					&RefreshParams{Token: signin.RefreshToken})
+
+				if !errors.Is(err, InvalidTokenErr) {
+					fixture.t.Errorf("Refresh should fail with %s, got %v", InvalidTokenErr, err)
+				}
+
+				// Errors reach the logs and the clients
+				if err != nil && strings.Contains(err.Error(), signin.RefreshToken) {
+					fixture.t.Errorf("Refresh error should not contain the token: %s", err)
+				}
+
+				// The session is revoked
+				return fixture.me(refresh.AccessToken)
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Refresh with an access token",
+			run: func(fixture *fixture) error {
+				_, err := fixture.login.Refresh(fixture.uuid, fixture.ctx,
+					&RefreshParams{Token: fixture.signin().AccessToken})
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Refresh with an expired token",
+			run: func(fixture *fixture) error {
+				fixture.signin()
+
+				_, err := fixture.login.Refresh(fixture.uuid, fixture.ctx,
+					&RefreshParams{Token: fixture.expired})
+
+				return err
+			},
+			err: TokenExpiredErr,
+		},
+		{
+			name: "Sessions",
+			run: func(fixture *fixture) error {
+				laptop := fixture.signin()
+				phone := fixture.open("phone")
+
+				sessions, err := fixture.login.Sessions(fixture.uuid, fixture.ctx,
+					&AccessTokenParams{Token: laptop.AccessToken})
+
+				if err != nil {
+					return err
+				}
+
+				if len(sessions) != 2 ||
+					sessions[0].UUID != laptop.Session || sessions[0].Device != "laptop" ||
+					sessions[1].UUID != phone.Session || sessions[1].Device != "phone" {
+
+					fixture.t.Errorf("Sessions returned %v", sessions)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "RevokeSession",
+			run: func(fixture *fixture) error {
+				laptop := fixture.signin()
+				phone := fixture.open("phone")
+
+				fixture.must("RevokeSession", fixture.login.RevokeSession(fixture.uuid, fixture.ctx,
+					&RevokeSessionParams{Token: laptop.AccessToken, Session: phone.Session}))
+
+				fixture.must("Me", fixture.me(laptop.AccessToken))
+
+				if !fixture.revoked(phone.AccessToken) {
+					fixture.t.Error("RevokeSession should revoke the access token of the session")
+				}
+
+				_, err := fixture.login.Refresh(fixture.uuid, fixture.ctx,
+					&RefreshParams{Token: phone.RefreshToken})
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "RevokeSession without match",
+			run: func(fixture *fixture) error {
+				return fixture.login.RevokeSession(fixture.uuid, fixture.ctx,
+					&RevokeSessionParams{Token: fixture.signin().AccessToken, Session: "absent"})
+			},
+			err: SessionNotFoundErr,
+		},
+		{
+			name: "Create",
+			run: func(fixture *fixture) error {
+				user := fixture.create()
+				stored := fixture.user()
+
+				if stored.State != loginEntity.INITIALIZING ||
+					stored.InitializationToken != user.InitializationToken {
+
+					fixture.t.Errorf("Create stored %s", stored)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "Create with a taken email",
+			run: func(fixture *fixture) error {
+				fixture.signup()
+
+				_, err := fixture.login.Create(fixture.uuid, fixture.ctx,
+					CreateUserParams{Email: EMAIL, Password: PASSWORD})
+
+				return err
+			},
+			err: EmailTakenErr,
+		},
+		{
+			name: "CheckInitialization",
+			run: func(fixture *fixture) error {
+				user := fixture.create()
+
+				return fixture.login.CheckInitialization(fixture.uuid, fixture.ctx,
+					EMAIL, user.InitializationToken)
+			},
+		},
+		{
+			name: "CheckInitialization with an absent token",
+			run: func(fixture *fixture) error {
+				fixture.create()
+
+				return fixture.login.CheckInitialization(fixture.uuid, fixture.ctx,
+					EMAIL, fixture.absent(INITIALIZATION_TOKEN_SUBJECT))
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "CheckInitialization with an expired token",
+			run: func(fixture *fixture) error {
+				fixture.create()
+
+				return fixture.login.CheckInitialization(fixture.uuid, fixture.ctx,
+					EMAIL, fixture.expired)
+			},
+			err: TokenExpiredErr,
+		},
+		{
+			name: "Initialize",
+			run: func(fixture *fixture) error {
+				user := fixture.create()
+
+				initialized, err := fixture.login.Initialize(fixture.uuid, fixture.ctx,
+					InitializeParams{
+						Email:    EMAIL,
+						Token:    user.InitializationToken,
+						Password: "NewPassword1",
+					})
+
+				fixture.must("Initialize", err)
+
+				if initialized.State != loginEntity.VALID || initialized.InitializationToken != "" {
+					fixture.t.Errorf("Initialize returned %s", initialized)
+				}
+
+				_, err = fixture.login.Signin(fixture.uuid, fixture.ctx,
+					&SigninParams{Email: EMAIL, Password: "NewPassword1"})
+
+				return err
+			},
+		},
+		{
+			name: "Initialize twice",
+			run: func(fixture *fixture) error {
+				user := fixture.create()
+				params := InitializeParams{
+					Email:    EMAIL,
+					Token:    user.InitializationToken,
+					Password: "NewPassword1",
+				}
+
+				_, err := fixture.login.Initialize(fixture.uuid, fixture.ctx, params)
+				fixture.must("Initialize", err)
+
+				_, err = fixture.login.Initialize(fixture.uuid, fixture.ctx, params)
+
+				return err
+			},
+			err: UserNotFoundErr,
+		},
+		{
+			name: "Initialize with an invalid token",
+			run: func(fixture *fixture) error {
+				fixture.create()
+
+				_, err := fixture.login.Initialize(fixture.uuid, fixture.ctx,
+					InitializeParams{Email: EMAIL, Token: fixture.invalid, Password: PASSWORD})
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Initialize with a weak password",
+			run: func(fixture *fixture) error {
+				user := fixture.create()
+
+				_, err := fixture.login.Initialize(fixture.uuid, fixture.ctx,
+					InitializeParams{Email: EMAIL, Token: user.InitializationToken, Password: "short"})
+
+				return err
+			},
+			err: WeakPasswordErr,
+		},
+		{
+			name: "Initialize with an activation token",
+			run: func(fixture *fixture) error {
+				user := fixture.signup()
+
+				_, err := fixture.login.Initialize(fixture.uuid, fixture.ctx,
+					InitializeParams{Email: EMAIL, Token: user.ActivationToken, Password: PASSWORD})
+
+				return err
+			},
+			err: InvalidTokenErr,
+		},
+		{
+			name: "Signin before initialization",
+			run: func(fixture *fixture) error {
+				fixture.create()
+
+				_, err := fixture.login.Signin(fixture.uuid, fixture.ctx,
+					&SigninParams{Email: EMAIL, Password: PASSWORD})
+
+				return err
+			},
+			err: WrongStateErr,
+		},
 	}
 
-	tmp, err = login.Me(ctx, token)
-	if err == nil || tmp != nil {
-		t.Errorf("Me should fail: access token %s should not be valid anymore", result.AccessToken)
-		t.FailNow()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.run(newFixture(t))
+
+			switch {
+			case test.err == nil && err != nil:
+				t.Errorf("%s should not fail: %s", test.name, err)
+
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Errorf("%s should fail with %s, got %v", test.name, test.err, err)
+			}
+		})
 	}
 }
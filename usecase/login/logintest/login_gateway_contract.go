@@ -0,0 +1,321 @@
+// Package logintest holds the contracts every Login gateway must fulfil. It
+// imports testing and must only be imported by tests.
+package logintest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kukinsula/boxy/entity"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+)
+
+const (
+	contractEmail      = "contract@mail.io"
+	contractOtherEmail = "other@mail.io"
+)
+
+// LoginGatewayContract checks that gateway behaves as Login expects from any
+// loginUsecase.LoginGateway. newGateway returns an empty gateway for every subtest.
+func LoginGatewayContract(t *testing.T, newGateway func(t *testing.T) loginUsecase.LoginGateway) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, contract *contract)
+	}{
+		{"Create and find", testCreateAndFind},
+		{"Create with a taken email", testCreateTakenEmail},
+		{"Create with a taken UUID", testCreateTakenUUID},
+		{"Create without tokens", testCreateWithoutTokens},
+		{"Not found", testNotFound},
+		{"Projection", testProjection},
+		{"Update", testUpdate},
+		{"Update without match", testUpdateWithoutMatch},
+		{"Transaction", testTransaction},
+		{"Transaction rollback", testTransactionRollback},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, &contract{
+				t:       t,
+				uuid:    entity.NewUUID(),
+				ctx:     context.Background(),
+				gateway: newGateway(t),
+			})
+		})
+	}
+}
+
+type contract struct {
+	t       *testing.T
+	uuid    string
+	ctx     context.Context
+	gateway loginUsecase.LoginGateway
+}
+
+func (contract *contract) create(email string) *loginEntity.User {
+	user := loginEntity.NewUserBuilder().
+		UUID(entity.NewUUID()).
+		Email(email).
+		FirstName("Ti").
+		LastName("Ti").
+		Password("encrypted").
+		ActivationToken("activation-" + email).
+		InitializationToken("initialization-" + email).
+		State(loginEntity.ACTIVATING).
+		Build()
+
+	created, err := contract.gateway.Create(contract.uuid, contract.ctx, user)
+	contract.must("Create", err)
+
+	if created == nil || *created != *user {
+		contract.t.Fatalf("Create should return %s, got %v", user, created)
+	}
+
+	return user
+}
+
+func (contract *contract) find(email string) *loginEntity.User {
+	user, err := contract.gateway.FindByEmail(contract.uuid, contract.ctx, email,
+		loginEntity.UserFullProjection)
+
+	contract.must("FindByEmail", err)
+
+	return user
+}
+
+func (contract *contract) must(operation string, err error) {
+	if err != nil {
+		contract.t.Fatalf("%s should not fail: %s", operation, err)
+	}
+}
+
+func (contract *contract) expect(operation string, expected, actual *loginEntity.User) {
+	switch {
+	case expected == nil && actual != nil:
+		contract.t.Errorf("%s should find nothing, got %s", operation, actual)
+
+	case expected != nil && (actual == nil || *expected != *actual):
+		contract.t.Errorf("%s should return %s, got %v", operation, expected, actual)
+	}
+}
+
+func testCreateAndFind(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+	contract.create(contractOtherEmail)
+
+	found, err := contract.gateway.FindByEmail(contract.uuid, contract.ctx,
+		user.Email, loginEntity.UserFullProjection)
+
+	contract.must("FindByEmail", err)
+	contract.expect("FindByEmail", user, found)
+
+	found, err = contract.gateway.FindByEmailAndActivationToken(contract.uuid, contract.ctx,
+		user.Email, user.ActivationToken, loginEntity.UserFullProjection)
+
+	contract.must("FindByEmailAndActivationToken", err)
+	contract.expect("FindByEmailAndActivationToken", user, found)
+
+	found, err = contract.gateway.FindByEmailAndInitializationToken(contract.uuid, contract.ctx,
+		user.Email, user.InitializationToken, loginEntity.UserFullProjection)
+
+	contract.must("FindByEmailAndInitializationToken", err)
+	contract.expect("FindByEmailAndInitializationToken", user, found)
+
+	found, err = contract.gateway.FindByUUID(contract.uuid, contract.ctx,
+		user.UUID, loginEntity.UserFullProjection)
+
+	contract.must("FindByUUID", err)
+	contract.expect("FindByUUID", user, found)
+}
+
+func testCreateTakenEmail(t *testing.T, contract *contract) {
+	contract.create(contractEmail)
+
+	user := loginEntity.NewUserBuilder().
+		UUID(entity.NewUUID()).
+		Email(contractEmail).
+		State(loginEntity.ACTIVATING).
+		Build()
+
+	_, err := contract.gateway.Create(contract.uuid, contract.ctx, user)
+	if !errors.Is(err, loginUsecase.EmailTakenErr) {
+		t.Errorf("Create should fail with %s, got %v", loginUsecase.EmailTakenErr, err)
+	}
+}
+
+func testCreateTakenUUID(t *testing.T, contract *contract) {
+	taken := contract.create(contractEmail)
+
+	user := loginEntity.NewUserBuilder().
+		UUID(taken.UUID).
+		Email(contractOtherEmail).
+		State(loginEntity.ACTIVATING).
+		Build()
+
+	_, err := contract.gateway.Create(contract.uuid, contract.ctx, user)
+	if err == nil || errors.Is(err, loginUsecase.EmailTakenErr) {
+		t.Errorf("Create should fail with another error than %s, got %v", loginUsecase.EmailTakenErr, err)
+	}
+
+	contract.expect("FindByEmail", nil, contract.find(contractOtherEmail))
+}
+
+// Users without a token must not conflict on it.
+func testCreateWithoutTokens(t *testing.T, contract *contract) {
+	for _, email := range []string{contractEmail, contractOtherEmail} {
+		user := loginEntity.NewUserBuilder().
+			UUID(entity.NewUUID()).
+			Email(email).
+			State(loginEntity.VALID).
+			Build()
+
+		_, err := contract.gateway.Create(contract.uuid, contract.ctx, user)
+		contract.must("Create", err)
+
+		contract.expect("FindByEmail", user, contract.find(email))
+	}
+}
+
+func testNotFound(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+
+	found, err := contract.gateway.FindByEmail(contract.uuid, contract.ctx,
+		contractOtherEmail, loginEntity.UserFullProjection)
+
+	contract.must("FindByEmail", err)
+	contract.expect("FindByEmail", nil, found)
+
+	found, err = contract.gateway.FindByEmailAndActivationToken(contract.uuid, contract.ctx,
+		user.Email, user.InitializationToken, loginEntity.UserFullProjection)
+
+	contract.must("FindByEmailAndActivationToken", err)
+	contract.expect("FindByEmailAndActivationToken", nil, found)
+
+	found, err = contract.gateway.FindByEmailAndInitializationToken(contract.uuid, contract.ctx,
+		user.Email, user.ActivationToken, loginEntity.UserFullProjection)
+
+	contract.must("FindByEmailAndInitializationToken", err)
+	contract.expect("FindByEmailAndInitializationToken", nil, found)
+
+	found, err = contract.gateway.FindByUUID(contract.uuid, contract.ctx,
+		"absent", loginEntity.UserFullProjection)
+
+	contract.must("FindByUUID", err)
+	contract.expect("FindByUUID", nil, found)
+}
+
+func testProjection(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+
+	found, err := contract.gateway.FindByEmail(contract.uuid, contract.ctx, user.Email,
+		loginEntity.UserProjection{
+			loginEntity.USER_UUID,
+			loginEntity.USER_EMAIL,
+			loginEntity.USER_STATE,
+		})
+
+	contract.must("FindByEmail", err)
+	contract.expect("FindByEmail", &loginEntity.User{
+		UUID:  user.UUID,
+		Email: user.Email,
+		State: user.State,
+	}, found)
+}
+
+func testUpdate(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+
+	updated, err := contract.gateway.Update(contract.uuid, contract.ctx, user.UUID,
+		loginUsecase.NewUserPatch().
+			State(loginEntity.VALID).
+			Password("changed").
+			Unset(loginEntity.USER_ACTIVATION_TOKEN))
+
+	contract.must("Update", err)
+
+	user.State = loginEntity.VALID
+	user.Password = "changed"
+	user.ActivationToken = ""
+
+	contract.expect("Update", user, updated)
+	contract.expect("FindByEmail", user, contract.find(contractEmail))
+}
+
+func testUpdateWithoutMatch(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+
+	updated, err := contract.gateway.Update(contract.uuid, contract.ctx, "absent",
+		loginUsecase.NewUserPatch().FirstName("Changed"))
+
+	contract.must("Update", err)
+	contract.expect("Update", nil, updated)
+	contract.expect("FindByEmail", user, contract.find(contractEmail))
+}
+
+func testTransaction(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+
+	err := contract.gateway.Transaction(contract.uuid, contract.ctx,
+		func(ctx context.Context) error {
+			_, err := contract.gateway.Update(contract.uuid, ctx, user.UUID,
+				loginUsecase.NewUserPatch().FirstName("Changed"))
+
+			if err != nil {
+				return err
+			}
+
+			// Nested transactions join the current one
+			return contract.gateway.Transaction(contract.uuid, ctx,
+				func(ctx context.Context) error {
+					_, err := contract.gateway.Update(contract.uuid, ctx, user.UUID,
+						loginUsecase.NewUserPatch().LastName("Changed"))
+
+					return err
+				})
+		})
+
+	contract.must("Transaction", err)
+
+	user.FirstName = "Changed"
+	user.LastName = "Changed"
+
+	contract.expect("FindByEmail", user, contract.find(contractEmail))
+}
+
+func testTransactionRollback(t *testing.T, contract *contract) {
+	user := contract.create(contractEmail)
+	failure := errors.New("failure")
+
+	err := contract.gateway.Transaction(contract.uuid, contract.ctx,
+		func(ctx context.Context) error {
+			_, err := contract.gateway.Update(contract.uuid, ctx, user.UUID,
+				loginUsecase.NewUserPatch().FirstName("Changed"))
+
+			if err != nil {
+				return err
+			}
+
+			_, err = contract.gateway.Create(contract.uuid, ctx,
+				loginEntity.NewUserBuilder().
+					UUID(entity.NewUUID()).
+					Email(contractOtherEmail).
+					State(loginEntity.VALID).
+					Build())
+
+			if err != nil {
+				return err
+			}
+
+			return failure
+		})
+
+	if !errors.Is(err, failure) {
+		t.Errorf("Transaction should fail with %s, got %v", failure, err)
+	}
+
+	contract.expect("FindByEmail", user, contract.find(contractEmail))
+	contract.expect("FindByEmail", nil, contract.find(contractOtherEmail))
+}
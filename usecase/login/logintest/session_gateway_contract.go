@@ -0,0 +1,306 @@
+package logintest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kukinsula/boxy/entity"
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	loginUsecase "github.com/kukinsula/boxy/usecase/login"
+)
+
+// SessionGatewayContract checks that gateway behaves as Login expects from
+// any loginUsecase.SessionGateway. newGateways returns empty gateways for every subtest,
+// the loginUsecase.SessionGateway joining the transactions of the loginUsecase.LoginGateway.
+func SessionGatewayContract(
+	t *testing.T,
+	newGateways func(t *testing.T) (loginUsecase.LoginGateway, loginUsecase.SessionGateway)) {
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, contract *sessionContract)
+	}{
+		{"Create and find", testCreateAndFindSession},
+		{"Find by previous refresh token", testFindSessionByPreviousRefreshToken},
+		{"Not found", testSessionNotFound},
+		{"Create with a taken access token", testCreateSessionTakenAccessToken},
+		{"Update", testUpdateSession},
+		{"Update without match", testUpdateSessionWithoutMatch},
+		{"Delete", testDeleteSession},
+		{"Transaction rollback", testSessionTransactionRollback},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loginGateway, sessionGateway := newGateways(t)
+
+			test.run(t, &sessionContract{
+				t:              t,
+				uuid:           entity.NewUUID(),
+				ctx:            context.Background(),
+				loginGateway:   loginGateway,
+				sessionGateway: sessionGateway,
+			})
+		})
+	}
+}
+
+type sessionContract struct {
+	t              *testing.T
+	uuid           string
+	ctx            context.Context
+	loginGateway   loginUsecase.LoginGateway
+	sessionGateway loginUsecase.SessionGateway
+}
+
+func newContractSession(userUUID string, createdAt time.Time) *loginEntity.Session {
+	uuid := entity.NewUUID()
+
+	return &loginEntity.Session{
+		UUID:         uuid,
+		UserUUID:     userUUID,
+		Device:       "device",
+		IP:           "127.0.0.1",
+		AccessToken:  "access-" + uuid,
+		RefreshToken: "refresh-" + uuid,
+		CreatedAt:    createdAt,
+		LastUsedAt:   createdAt,
+	}
+}
+
+func (contract *sessionContract) create(userUUID string, createdAt time.Time) *loginEntity.Session {
+	session := newContractSession(userUUID, createdAt)
+
+	created, err := contract.sessionGateway.CreateSession(contract.uuid, contract.ctx, session)
+	contract.must("CreateSession", err)
+	contract.expect("CreateSession", session, created)
+
+	return session
+}
+
+func (contract *sessionContract) must(operation string, err error) {
+	if err != nil {
+		contract.t.Fatalf("%s should not fail: %s", operation, err)
+	}
+}
+
+func (contract *sessionContract) expect(
+	operation string,
+	expected, actual *loginEntity.Session) {
+
+	switch {
+	case expected == nil && actual != nil:
+		contract.t.Errorf("%s should find nothing, got %s", operation, actual)
+
+	case expected != nil && (actual == nil || !sameSession(expected, actual)):
+		contract.t.Errorf("%s should return %s, got %v", operation, expected, actual)
+	}
+}
+
+// sameSession compares sessions, their times being equal whatever their
+// location.
+func sameSession(session, other *loginEntity.Session) bool {
+	return session.UUID == other.UUID &&
+		session.UserUUID == other.UserUUID &&
+		session.Device == other.Device &&
+		session.IP == other.IP &&
+		session.AccessToken == other.AccessToken &&
+		session.RefreshToken == other.RefreshToken &&
+		session.PreviousRefreshToken == other.PreviousRefreshToken &&
+		session.CreatedAt.Equal(other.CreatedAt) &&
+		session.LastUsedAt.Equal(other.LastUsedAt)
+}
+
+func contractTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func testCreateAndFindSession(t *testing.T, contract *sessionContract) {
+	userUUID := entity.NewUUID()
+	createdAt := contractTime()
+
+	// Created out of order
+	newest := contract.create(userUUID, createdAt)
+	oldest := contract.create(userUUID, createdAt.Add(-time.Hour))
+	contract.create(entity.NewUUID(), createdAt)
+
+	found, err := contract.sessionGateway.FindSessionByAccessToken(contract.uuid, contract.ctx,
+		newest.AccessToken)
+
+	contract.must("FindSessionByAccessToken", err)
+	contract.expect("FindSessionByAccessToken", newest, found)
+
+	found, err = contract.sessionGateway.FindSessionByRefreshToken(contract.uuid, contract.ctx,
+		newest.RefreshToken)
+
+	contract.must("FindSessionByRefreshToken", err)
+	contract.expect("FindSessionByRefreshToken", newest, found)
+
+	sessions, err := contract.sessionGateway.FindSessions(contract.uuid, contract.ctx, userUUID)
+	contract.must("FindSessions", err)
+
+	if len(sessions) != 2 {
+		t.Fatalf("FindSessions should return 2 sessions, got %d", len(sessions))
+	}
+
+	contract.expect("FindSessions", oldest, sessions[0])
+	contract.expect("FindSessions", newest, sessions[1])
+}
+
+func testFindSessionByPreviousRefreshToken(t *testing.T, contract *sessionContract) {
+	session := newContractSession(entity.NewUUID(), contractTime())
+	session.PreviousRefreshToken = "previous-" + session.UUID
+
+	_, err := contract.sessionGateway.CreateSession(contract.uuid, contract.ctx, session)
+	contract.must("CreateSession", err)
+
+	found, err := contract.sessionGateway.FindSessionByRefreshToken(contract.uuid, contract.ctx,
+		session.PreviousRefreshToken)
+
+	contract.must("FindSessionByRefreshToken", err)
+	contract.expect("FindSessionByRefreshToken", session, found)
+}
+
+func testSessionNotFound(t *testing.T, contract *sessionContract) {
+	// Sessions without a previous refresh token must not match an empty one
+	contract.create(entity.NewUUID(), contractTime())
+
+	found, err := contract.sessionGateway.FindSessionByAccessToken(contract.uuid, contract.ctx,
+		"absent")
+
+	contract.must("FindSessionByAccessToken", err)
+	contract.expect("FindSessionByAccessToken", nil, found)
+
+	for _, token := range []string{"absent", ""} {
+		found, err = contract.sessionGateway.FindSessionByRefreshToken(
+			contract.uuid, contract.ctx, token)
+
+		contract.must("FindSessionByRefreshToken", err)
+		contract.expect("FindSessionByRefreshToken", nil, found)
+	}
+
+	sessions, err := contract.sessionGateway.FindSessions(contract.uuid, contract.ctx, "absent")
+	contract.must("FindSessions", err)
+
+	if len(sessions) != 0 {
+		t.Errorf("FindSessions should return no session, got %d", len(sessions))
+	}
+}
+
+func testCreateSessionTakenAccessToken(t *testing.T, contract *sessionContract) {
+	taken := contract.create(entity.NewUUID(), contractTime())
+
+	session := newContractSession(taken.UserUUID, contractTime())
+	session.AccessToken = taken.AccessToken
+
+	_, err := contract.sessionGateway.CreateSession(contract.uuid, contract.ctx, session)
+	if err == nil {
+		t.Errorf("CreateSession should fail: access token of %s is taken", taken.UUID)
+	}
+
+	found, err := contract.sessionGateway.FindSessionByRefreshToken(contract.uuid, contract.ctx,
+		session.RefreshToken)
+
+	contract.must("FindSessionByRefreshToken", err)
+	contract.expect("FindSessionByRefreshToken", nil, found)
+}
+
+func testUpdateSession(t *testing.T, contract *sessionContract) {
+	session := contract.create(entity.NewUUID(), contractTime())
+	accessToken := session.AccessToken
+
+	session.PreviousRefreshToken = session.RefreshToken
+	session.AccessToken = "access-updated"
+	session.RefreshToken = "refresh-updated"
+	session.IP = "10.0.0.1"
+	session.LastUsedAt = session.LastUsedAt.Add(time.Minute)
+
+	updated, err := contract.sessionGateway.UpdateSession(contract.uuid, contract.ctx, session)
+	contract.must("UpdateSession", err)
+	contract.expect("UpdateSession", session, updated)
+
+	found, err := contract.sessionGateway.FindSessionByAccessToken(contract.uuid, contract.ctx,
+		session.AccessToken)
+
+	contract.must("FindSessionByAccessToken", err)
+	contract.expect("FindSessionByAccessToken", session, found)
+
+	found, err = contract.sessionGateway.FindSessionByAccessToken(contract.uuid, contract.ctx,
+		accessToken)
+
+	contract.must("FindSessionByAccessToken", err)
+	contract.expect("FindSessionByAccessToken", nil, found)
+}
+
+func testUpdateSessionWithoutMatch(t *testing.T, contract *sessionContract) {
+	updated, err := contract.sessionGateway.UpdateSession(contract.uuid, contract.ctx,
+		newContractSession(entity.NewUUID(), contractTime()))
+
+	contract.must("UpdateSession", err)
+	contract.expect("UpdateSession", nil, updated)
+}
+
+func testDeleteSession(t *testing.T, contract *sessionContract) {
+	session := contract.create(entity.NewUUID(), contractTime())
+
+	// Sessions of other Users are left untouched
+	deleted, err := contract.sessionGateway.DeleteSession(contract.uuid, contract.ctx,
+		entity.NewUUID(), session.UUID)
+
+	contract.must("DeleteSession", err)
+
+	if deleted {
+		t.Errorf("DeleteSession should not delete the session of another user")
+	}
+
+	deleted, err = contract.sessionGateway.DeleteSession(contract.uuid, contract.ctx,
+		session.UserUUID, session.UUID)
+
+	contract.must("DeleteSession", err)
+
+	if !deleted {
+		t.Errorf("DeleteSession should delete session %s", session.UUID)
+	}
+
+	found, err := contract.sessionGateway.FindSessionByAccessToken(contract.uuid, contract.ctx,
+		session.AccessToken)
+
+	contract.must("FindSessionByAccessToken", err)
+	contract.expect("FindSessionByAccessToken", nil, found)
+
+	deleted, err = contract.sessionGateway.DeleteSession(contract.uuid, contract.ctx,
+		session.UserUUID, session.UUID)
+
+	contract.must("DeleteSession", err)
+
+	if deleted {
+		t.Errorf("DeleteSession should not delete session %s twice", session.UUID)
+	}
+}
+
+func testSessionTransactionRollback(t *testing.T, contract *sessionContract) {
+	session := newContractSession(entity.NewUUID(), contractTime())
+	failure := errors.New("failure")
+
+	err := contract.loginGateway.Transaction(contract.uuid, contract.ctx,
+		func(ctx context.Context) error {
+			_, err := contract.sessionGateway.CreateSession(contract.uuid, ctx, session)
+			if err != nil {
+				return err
+			}
+
+			return failure
+		})
+
+	if !errors.Is(err, failure) {
+		t.Errorf("Transaction should fail with %s, got %v", failure, err)
+	}
+
+	found, err := contract.sessionGateway.FindSessionByAccessToken(contract.uuid, contract.ctx,
+		session.AccessToken)
+
+	contract.must("FindSessionByAccessToken", err)
+	contract.expect("FindSessionByAccessToken", nil, found)
+}
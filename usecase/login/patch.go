@@ -0,0 +1,57 @@
+package login
+
+import (
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+)
+
+// UserPatch describes the changes LoginGateway.Update applies to a User:
+// fields to set to a new value and fields to clear.
+type UserPatch struct {
+	set   map[loginEntity.UserField]interface{}
+	unset []loginEntity.UserField
+}
+
+func NewUserPatch() *UserPatch {
+	return &UserPatch{
+		set: map[loginEntity.UserField]interface{}{},
+	}
+}
+
+func (patch *UserPatch) FirstName(firstName string) *UserPatch {
+	return patch.with(loginEntity.USER_FIRST_NAME, firstName)
+}
+
+func (patch *UserPatch) LastName(lastName string) *UserPatch {
+	return patch.with(loginEntity.USER_LAST_NAME, lastName)
+}
+
+func (patch *UserPatch) Password(password string) *UserPatch {
+	return patch.with(loginEntity.USER_PASSWORD, password)
+}
+
+func (patch *UserPatch) State(state loginEntity.UserState) *UserPatch {
+	return patch.with(loginEntity.USER_STATE, state)
+}
+
+// Unset clears fields, such as a token once used.
+func (patch *UserPatch) Unset(fields ...loginEntity.UserField) *UserPatch {
+	patch.unset = append(patch.unset, fields...)
+
+	return patch
+}
+
+// Sets returns the fields to set with their new value, a string or a
+// UserState.
+func (patch *UserPatch) Sets() map[loginEntity.UserField]interface{} {
+	return patch.set
+}
+
+func (patch *UserPatch) Unsets() []loginEntity.UserField {
+	return patch.unset
+}
+
+func (patch *UserPatch) with(field loginEntity.UserField, value interface{}) *UserPatch {
+	patch.set[field] = value
+
+	return patch
+}
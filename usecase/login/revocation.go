@@ -0,0 +1,34 @@
+package login
+
+import (
+	"context"
+	"time"
+)
+
+// RevocationGateway keeps the IDs of the revoked tokens until they expire,
+// for the transports verifying tokens by themselves.
+type RevocationGateway interface {
+	Revoke(uuid string, ctx context.Context, id string, expiresAt time.Time) error
+
+	IsRevoked(uuid string, ctx context.Context, id string) (bool, error)
+}
+
+// revoke revokes the access tokens of closed or renewed Sessions. Tokens
+// that no longer verify need not be, the others are until verifiers stop
+// accepting them.
+func (login *Login) revoke(uuid string, ctx context.Context, tokens ...string) error {
+	for _, token := range tokens {
+		claims, err := login.tokener.Verify(token, tokenParams(ACCESS_TOKEN_SUBJECT))
+		if err != nil || claims.ID == "" {
+			continue
+		}
+
+		err = login.revocationGateway.Revoke(uuid, ctx, claims.ID,
+			claims.ExpiresAt.Add(login.tokener.Leeway()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package login
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevocationGatewayMock is an in-memory RevocationGateway.
+type RevocationGatewayMock struct {
+	revoked map[string]time.Time
+	mutex   *sync.RWMutex
+}
+
+func NewRevocationGatewayMock() *RevocationGatewayMock {
+	return &RevocationGatewayMock{
+		revoked: map[string]time.Time{},
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+func (revocations *RevocationGatewayMock) Revoke(
+	uuid string,
+	ctx context.Context,
+	id string,
+	expiresAt time.Time) error {
+
+	revocations.mutex.Lock()
+	defer revocations.mutex.Unlock()
+
+	revocations.revoked[id] = expiresAt
+
+	return nil
+}
+
+func (revocations *RevocationGatewayMock) IsRevoked(
+	uuid string,
+	ctx context.Context,
+	id string) (bool, error) {
+
+	revocations.mutex.RLock()
+	defer revocations.mutex.RUnlock()
+
+	expiresAt, ok := revocations.revoked[id]
+
+	return ok && time.Now().Before(expiresAt), nil
+}
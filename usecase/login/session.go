@@ -0,0 +1,343 @@
+package login
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+	"github.com/kukinsula/boxy/usecase"
+)
+
+const (
+	ACCESS_TOKEN_SUBJECT     = "Access"
+	ACCESS_TOKEN_EXPIRES_IN  = 15 * time.Minute
+	REFRESH_TOKEN_SUBJECT    = "Refresh"
+	REFRESH_TOKEN_EXPIRES_IN = 30 * 24 * time.Hour
+)
+
+// SessionGateway stores the Sessions of the Users. Its calls made in a
+// transaction of the LoginGateway belong to it, both gateways sharing their
+// storage.
+type SessionGateway interface {
+	CreateSession(
+		uuid string,
+		ctx context.Context,
+		session *loginEntity.Session) (*loginEntity.Session, error)
+
+	FindSessionByAccessToken(
+		uuid string,
+		ctx context.Context,
+		token string) (*loginEntity.Session, error)
+
+	// FindSessionByRefreshToken returns the Session whose current or
+	// previous refresh token digest is token, nil if there is none.
+	FindSessionByRefreshToken(
+		uuid string,
+		ctx context.Context,
+		token string) (*loginEntity.Session, error)
+
+	// FindSessions returns the Sessions of the User userUUID, oldest first.
+	FindSessions(
+		uuid string,
+		ctx context.Context,
+		userUUID string) ([]*loginEntity.Session, error)
+
+	// UpdateSession replaces the Session with the UUID of session and
+	// returns it, nil if there is none.
+	UpdateSession(
+		uuid string,
+		ctx context.Context,
+		session *loginEntity.Session) (*loginEntity.Session, error)
+
+	// DeleteSession removes the Session sessionUUID of the User userUUID and
+	// tells whether there was one.
+	DeleteSession(
+		uuid string,
+		ctx context.Context,
+		userUUID, sessionUUID string) (bool, error)
+}
+
+// RefreshParams carry a refresh token. IP is set by the transport, not by the
+// client.
+type RefreshParams struct {
+	Token string `json:"token"`
+	IP    string `json:"ip"`
+}
+
+func (params *RefreshParams) String() string {
+	return fmt.Sprintf("Token: ******, IP: %s", params.IP)
+}
+
+type RevokeSessionParams struct {
+	Token   string `json:"token"`
+	Session string `json:"session"`
+}
+
+func (params *RevokeSessionParams) String() string {
+	return fmt.Sprintf("Token: ******, Session: %s", params.Session)
+}
+
+// Refresh renews the tokens of the Session of the refresh token, revoking its
+// previous access token. A refresh token already renewed has been stolen, or
+// its renewal lost: its Session is revoked either way.
+func (login *Login) Refresh(
+	uuid string,
+	ctx context.Context,
+	params *RefreshParams) (*SigninResult, error) {
+
+	err := login.verify("Refresh", REFRESH_TOKEN_SUBJECT, params.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *SigninResult
+	var revoked, sessionUUID string
+	reused := false
+
+	err = login.loginGateway.Transaction(uuid, ctx, func(ctx context.Context) error {
+		digest := hashToken(params.Token)
+
+		session, err := login.sessionGateway.FindSessionByRefreshToken(uuid, ctx, digest)
+		if err != nil {
+			return err
+		}
+
+		if session == nil {
+			return fmt.Errorf("Refresh failed: cannot find session of the refresh token: %w",
+				InvalidTokenErr)
+		}
+
+		revoked, sessionUUID = session.AccessToken, session.UUID
+
+		reused = session.RefreshToken != digest
+		if reused {
+			_, err = login.sessionGateway.DeleteSession(uuid, ctx, session.UserUUID, session.UUID)
+			return err
+		}
+
+		user, err := login.loginGateway.FindByUUID(uuid, ctx, session.UserUUID,
+			loginEntity.UserProjection{
+				loginEntity.USER_UUID,
+				loginEntity.USER_EMAIL,
+				loginEntity.USER_FIRST_NAME,
+				loginEntity.USER_LAST_NAME,
+				loginEntity.USER_STATE,
+			})
+
+		if err != nil {
+			return err
+		}
+
+		if user == nil {
+			return fmt.Errorf("Refresh failed: cannot find user of session %s: %w",
+				session.UUID, InvalidTokenErr)
+		}
+
+		if user.State != loginEntity.VALID {
+			return fmt.Errorf("Refresh failed: User with email %s is in state %d: %w",
+				user.Email, user.State, WrongStateErr)
+		}
+
+		if params.IP != "" {
+			session.IP = params.IP
+		}
+
+		refreshToken, err := login.rotate(user, session)
+		if err != nil {
+			return err
+		}
+
+		updated, err := login.sessionGateway.UpdateSession(uuid, ctx, session)
+		if err != nil {
+			return err
+		}
+
+		if updated == nil {
+			return fmt.Errorf("Refresh failed: session %s was revoked: %w",
+				session.UUID, InvalidTokenErr)
+		}
+
+		result = signinResult(user, session, refreshToken)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = login.revoke(uuid, ctx, revoked)
+	if err != nil {
+		return nil, err
+	}
+
+	// The revocation is committed before failing
+	if reused {
+		return nil, fmt.Errorf("Refresh failed: refresh token reused, session %s revoked: %w",
+			sessionUUID, InvalidTokenErr)
+	}
+
+	return result, nil
+}
+
+// Sessions returns the Sessions of the User of the access token that have
+// not expired, oldest first.
+func (login *Login) Sessions(
+	uuid string,
+	ctx context.Context,
+	params *AccessTokenParams) ([]*loginEntity.Session, error) {
+
+	current, err := login.session("Sessions", uuid, ctx, params.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := login.sessionGateway.FindSessions(uuid, ctx, current.UserUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*loginEntity.Session{}
+	expiredAt := now().Add(-REFRESH_TOKEN_EXPIRES_IN)
+
+	for _, session := range sessions {
+		if session.LastUsedAt.After(expiredAt) {
+			result = append(result, session)
+		}
+	}
+
+	return result, nil
+}
+
+// RevokeSession closes one of the Sessions of the User of the access token,
+// possibly the current one.
+func (login *Login) RevokeSession(
+	uuid string,
+	ctx context.Context,
+	params *RevokeSessionParams) error {
+
+	current, err := login.session("RevokeSession", uuid, ctx, params.Token)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := login.sessionGateway.FindSessions(uuid, ctx, current.UserUUID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.UUID != params.Session {
+			continue
+		}
+
+		deleted, err := login.sessionGateway.DeleteSession(
+			uuid, ctx, current.UserUUID, session.UUID)
+
+		if err != nil {
+			return err
+		}
+
+		if deleted {
+			return login.revoke(uuid, ctx, session.AccessToken)
+		}
+	}
+
+	return fmt.Errorf("RevokeSession failed: cannot find session %s: %w",
+		params.Session, SessionNotFoundErr)
+}
+
+// session returns the Session of a valid access token.
+func (login *Login) session(
+	operation, uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	err := login.verify(operation, ACCESS_TOKEN_SUBJECT, token)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := login.sessionGateway.FindSessionByAccessToken(uuid, ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if session == nil {
+		return nil, fmt.Errorf("%s failed: cannot find session of the access token: %w",
+			operation, InvalidTokenErr)
+	}
+
+	return session, nil
+}
+
+// rotate gives session new tokens, its refresh token becoming the previous
+// one.
+func (login *Login) rotate(user *loginEntity.User, session *loginEntity.Session) (string, error) {
+	accessToken, err := login.tokener.Generate(usecase.GenerateTokenParams{
+		Audience:  TOKEN_AUDIENCE,
+		ExpiresIn: ACCESS_TOKEN_EXPIRES_IN,
+		Issuer:    TOKEN_ISSUER,
+		Subject:   ACCESS_TOKEN_SUBJECT,
+		Email:     user.Email,
+		UUID:      user.UUID,
+		Session:   session.UUID,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken, err := login.tokener.Generate(usecase.GenerateTokenParams{
+		Audience:  TOKEN_AUDIENCE,
+		ExpiresIn: REFRESH_TOKEN_EXPIRES_IN,
+		Issuer:    TOKEN_ISSUER,
+		Subject:   REFRESH_TOKEN_SUBJECT,
+		Email:     user.Email,
+		UUID:      user.UUID,
+		Session:   session.UUID,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	session.PreviousRefreshToken = session.RefreshToken
+	session.AccessToken = accessToken
+	session.RefreshToken = hashToken(refreshToken)
+	session.LastUsedAt = now()
+
+	return refreshToken, nil
+}
+
+func signinResult(
+	user *loginEntity.User,
+	session *loginEntity.Session,
+	refreshToken string) *SigninResult {
+
+	return &SigninResult{
+		UUID:         user.UUID,
+		Email:        user.Email,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Session:      session.UUID,
+		AccessToken:  session.AccessToken,
+		RefreshToken: refreshToken,
+	}
+}
+
+// hashToken returns the SHA-256 digest of token, refresh tokens being stored
+// as such so that a read of the sessions cannot refresh them.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// now is the current time as gateways store it, to the millisecond.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
@@ -0,0 +1,152 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	loginEntity "github.com/kukinsula/boxy/entity/login"
+)
+
+func (database *LoginGatewayMock) CreateSession(
+	uuid string,
+	ctx context.Context,
+	session *loginEntity.Session) (*loginEntity.Session, error) {
+
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	if _, ok := database.sessions[session.UUID]; ok {
+		return nil, fmt.Errorf("LoginGatewayMock.CreateSession failed: UUID %s already exists",
+			session.UUID)
+	}
+
+	if database.tokensTaken(session) {
+		return nil, fmt.Errorf("LoginGatewayMock.CreateSession failed: token already exists")
+	}
+
+	stored := *session
+	database.sessions[session.UUID] = &stored
+
+	return session, nil
+}
+
+func (database *LoginGatewayMock) FindSessionByAccessToken(
+	uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	return database.findSession(func(session *loginEntity.Session) bool {
+		return session.AccessToken == token
+	}), nil
+}
+
+func (database *LoginGatewayMock) FindSessionByRefreshToken(
+	uuid string,
+	ctx context.Context,
+	token string) (*loginEntity.Session, error) {
+
+	if token == "" {
+		return nil, nil
+	}
+
+	return database.findSession(func(session *loginEntity.Session) bool {
+		return session.RefreshToken == token || session.PreviousRefreshToken == token
+	}), nil
+}
+
+func (database *LoginGatewayMock) FindSessions(
+	uuid string,
+	ctx context.Context,
+	userUUID string) ([]*loginEntity.Session, error) {
+
+	database.mutex.RLock()
+	defer database.mutex.RUnlock()
+
+	sessions := []*loginEntity.Session{}
+
+	for _, session := range database.sessions {
+		if session.UserUUID == userUUID {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (database *LoginGatewayMock) UpdateSession(
+	uuid string,
+	ctx context.Context,
+	session *loginEntity.Session) (*loginEntity.Session, error) {
+
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	stored, ok := database.sessions[session.UUID]
+	if !ok {
+		return nil, nil
+	}
+
+	if database.tokensTaken(session) {
+		return nil, fmt.Errorf("LoginGatewayMock.UpdateSession failed: token already exists")
+	}
+
+	*stored = *session
+	result := *session
+
+	return &result, nil
+}
+
+func (database *LoginGatewayMock) DeleteSession(
+	uuid string,
+	ctx context.Context,
+	userUUID, sessionUUID string) (bool, error) {
+
+	database.mutex.Lock()
+	defer database.mutex.Unlock()
+
+	session, ok := database.sessions[sessionUUID]
+	if !ok || session.UserUUID != userUUID {
+		return false, nil
+	}
+
+	delete(database.sessions, sessionUUID)
+
+	return true, nil
+}
+
+func (database *LoginGatewayMock) findSession(
+	comparator func(session *loginEntity.Session) bool) *loginEntity.Session {
+
+	database.mutex.RLock()
+	defer database.mutex.RUnlock()
+
+	for _, session := range database.sessions {
+		if comparator(session) {
+			copied := *session
+			return &copied
+		}
+	}
+
+	return nil
+}
+
+// tokensTaken tells whether another Session than session has one of its
+// access or refresh tokens, which are unique.
+func (database *LoginGatewayMock) tokensTaken(session *loginEntity.Session) bool {
+	for _, other := range database.sessions {
+		if other.UUID != session.UUID &&
+			(other.AccessToken == session.AccessToken ||
+				other.RefreshToken == session.RefreshToken) {
+
+			return true
+		}
+	}
+
+	return false
+}
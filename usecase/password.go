@@ -1,19 +1,220 @@
 package usecase
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Rules a password can break.
+const (
+	PASSWORD_TOO_SHORT     = "TOO_SHORT"
+	PASSWORD_TOO_LONG      = "TOO_LONG"
+	PASSWORD_NO_LOWER      = "NO_LOWER"
+	PASSWORD_NO_UPPER      = "NO_UPPER"
+	PASSWORD_NO_DIGIT      = "NO_DIGIT"
+	PASSWORD_NO_SYMBOL     = "NO_SYMBOL"
+	PASSWORD_COMMON        = "COMMON"
+	PASSWORD_PERSONAL_INFO = "PERSONAL_INFO"
+)
+
+// bcrypt ignores what follows the 72nd byte
+const passwordMaxBytes = 72
+
+// Words of personal info shorter than this may appear in passwords
+const personalInfoMinLength = 3
+
 type Passworder struct {
-	Cost int
+	Cost   int
+	Policy *PasswordPolicy
+}
+
+// PasswordPolicy is what a password must satisfy. Lengths count characters,
+// the Denylist holds lower case passwords too common to be accepted.
+type PasswordPolicy struct {
+	MinLength       int
+	MaxLength       int
+	RequireLower    bool
+	RequireUpper    bool
+	RequireDigit    bool
+	RequireSymbol   bool
+	Denylist        map[string]bool
+	NoPersonalInfos bool
+}
+
+// PasswordViolation is a rule a password breaks.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordError lists every rule a password breaks, so that all of them can
+// be fixed at once.
+type PasswordError struct {
+	Violations []PasswordViolation
+}
+
+func (err *PasswordError) Error() string {
+	messages := make([]string, len(err.Violations))
+	for index, violation := range err.Violations {
+		messages[index] = violation.Message
+	}
+
+	return fmt.Sprintf("Password rejected: %s", strings.Join(messages, ", "))
 }
 
 func NewPassworder(cost int) *Passworder {
 	return &Passworder{
-		Cost: cost,
+		Cost:   cost,
+		Policy: NewPasswordPolicy(),
 	}
 }
 
+// NewPasswordPolicy returns the default policy, without denylist.
+func NewPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:       10,
+		MaxLength:       64,
+		RequireLower:    true,
+		RequireUpper:    true,
+		RequireDigit:    true,
+		Denylist:        map[string]bool{},
+		NoPersonalInfos: true,
+	}
+}
+
+// LoadDenylist adds the passwords of the file at path, one per line, to the
+// denylist of policy. Empty lines and those starting with # are skipped.
+func (policy *PasswordPolicy) LoadDenylist(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			policy.Denylist[strings.ToLower(line)] = true
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Check returns a *PasswordError if password breaks the policy of
+// passworder. Personal infos, such as the email or names of its User, must
+// not appear in it.
+func (passworder *Passworder) Check(password string, personalInfos ...string) error {
+	if passworder.Policy == nil {
+		return nil
+	}
+
+	violations := passworder.Policy.violations(password, personalInfos)
+	if len(violations) != 0 {
+		return &PasswordError{Violations: violations}
+	}
+
+	return nil
+}
+
+func (policy *PasswordPolicy) violations(
+	password string,
+	personalInfos []string) []PasswordViolation {
+
+	violations := []PasswordViolation{}
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	length := utf8.RuneCountInString(password)
+
+	if length < policy.MinLength {
+		violate(PASSWORD_TOO_SHORT, "must have at least %d characters", policy.MinLength)
+	}
+
+	if policy.MaxLength != 0 && length > policy.MaxLength {
+		violate(PASSWORD_TOO_LONG, "must have at most %d characters", policy.MaxLength)
+	} else if len(password) > passwordMaxBytes {
+		violate(PASSWORD_TOO_LONG, "must fit in %d bytes", passwordMaxBytes)
+	}
+
+	classes := []struct {
+		required bool
+		rule     string
+		name     string
+		in       func(r rune) bool
+	}{
+		{policy.RequireLower, PASSWORD_NO_LOWER, "a lower case letter", unicode.IsLower},
+		{policy.RequireUpper, PASSWORD_NO_UPPER, "an upper case letter", unicode.IsUpper},
+		{policy.RequireDigit, PASSWORD_NO_DIGIT, "a digit", unicode.IsDigit},
+		{policy.RequireSymbol, PASSWORD_NO_SYMBOL, "a symbol", isSymbol},
+	}
+
+	for _, class := range classes {
+		if class.required && strings.IndexFunc(password, class.in) == -1 {
+			violate(class.rule, "must contain %s", class.name)
+		}
+	}
+
+	lower := strings.ToLower(password)
+
+	if policy.Denylist[lower] {
+		violate(PASSWORD_COMMON, "is too common")
+	}
+
+	if policy.NoPersonalInfos {
+		for _, word := range personalWords(personalInfos) {
+			if strings.Contains(lower, word) {
+				violate(PASSWORD_PERSONAL_INFO, "must not contain your email or name")
+				break
+			}
+		}
+	}
+
+	return violations
+}
+
+func isSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+// personalWords splits personal infos into the lower case words a password
+// must not contain. Only the local part of emails is personal.
+func personalWords(personalInfos []string) []string {
+	words := []string{}
+
+	for _, info := range personalInfos {
+		at := strings.LastIndex(info, "@")
+		if at != -1 {
+			info = info[:at]
+		}
+
+		fields := strings.FieldsFunc(strings.ToLower(info), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+
+		for _, field := range fields {
+			if utf8.RuneCountInString(field) >= personalInfoMinLength {
+				words = append(words, field)
+			}
+		}
+	}
+
+	return words
+}
+
 func (passworder *Passworder) Hash(password string) ([]byte, error) {
 	b, err := bcrypt.GenerateFromPassword([]byte(password), passworder.Cost)
 	if err != nil {
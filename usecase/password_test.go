@@ -1,6 +1,11 @@
 package usecase
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -14,9 +19,84 @@ func TestPassworder(t *testing.T) {
 		t.FailNow()
 	}
 
-	err = passworder.Compare(password, b)
+	err = passworder.Compare(b, []byte(password))
 	if err != nil {
 		t.Errorf("Passworder.Compare should not fail %s", err)
 		t.FailNow()
 	}
 }
+
+func TestPasswordPolicy(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boxy-denylist")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir should not fail: %s", err)
+	}
+
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "denylist.txt")
+
+	err = ioutil.WriteFile(path, []byte("# Common passwords\n\nPassword123\nQwerty12345\n"), 0600)
+	if err != nil {
+		t.Fatalf("ioutil.WriteFile should not fail: %s", err)
+	}
+
+	passworder := NewPassworder(4)
+
+	err = passworder.Policy.LoadDenylist(path)
+	if err != nil {
+		t.Fatalf("LoadDenylist should not fail: %s", err)
+	}
+
+	symbols := NewPassworder(4)
+	symbols.Policy.RequireSymbol = true
+
+	tests := []struct {
+		name       string
+		passworder *Passworder
+		password   string
+		rules      []string
+	}{
+		{"Valid", passworder, "Azerty1234.", nil},
+		{"Too short", passworder, "Az1", []string{PASSWORD_TOO_SHORT}},
+		{"Too long", passworder, "Az1" + strings.Repeat("a", 70), []string{PASSWORD_TOO_LONG}},
+		{"Too many bytes", passworder, "Az1" + strings.Repeat("é", 40), []string{PASSWORD_TOO_LONG}},
+		{"No lower", passworder, "AZERTY1234", []string{PASSWORD_NO_LOWER}},
+		{"No upper", passworder, "azerty1234", []string{PASSWORD_NO_UPPER}},
+		{"No digit", passworder, "Azertyuiop", []string{PASSWORD_NO_DIGIT}},
+		{"No symbol", symbols, "Azerty1234", []string{PASSWORD_NO_SYMBOL}},
+		{"Common", passworder, "password123", []string{PASSWORD_NO_UPPER, PASSWORD_COMMON}},
+		{"Email", passworder, "Titi.Mail1", []string{PASSWORD_PERSONAL_INFO}},
+		{"Last name", passworder, "1234Dupond", []string{PASSWORD_PERSONAL_INFO}},
+		{"Several", passworder, "azerty", []string{
+			PASSWORD_TOO_SHORT, PASSWORD_NO_UPPER, PASSWORD_NO_DIGIT}},
+		{"No policy", &Passworder{Cost: 4}, "a", nil},
+	}
+
+	for _, test := range tests {
+		err := test.passworder.Check(test.password, "titi.mail@mail.io", "Ti", "Dupond")
+
+		if test.rules == nil {
+			if err != nil {
+				t.Errorf("%s: Check should not fail: %s", test.name, err)
+			}
+
+			continue
+		}
+
+		var failure *PasswordError
+		if !errors.As(err, &failure) {
+			t.Errorf("%s: Check should fail with a PasswordError, not %v", test.name, err)
+			continue
+		}
+
+		rules := []string{}
+		for _, violation := range failure.Violations {
+			rules = append(rules, violation.Rule)
+		}
+
+		if strings.Join(rules, ",") != strings.Join(test.rules, ",") {
+			t.Errorf("%s: Check should break %v, not %v", test.name, test.rules, rules)
+		}
+	}
+}
@@ -1,72 +1,309 @@
 package usecase
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/kukinsula/boxy/entity"
+
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+var (
+	ExpiredTokenErr      = errors.New("Tokener: token expired")
+	NoSigningKeyErr      = errors.New("Tokener: no key signs now")
+	UnknownKeyErr        = errors.New("Tokener: unknown key")
+	DuplicateKeyErr      = errors.New("Tokener: duplicate key")
+	AlgorithmMismatchErr = errors.New("Tokener: algorithm mismatch")
+	NotYetValidTokenErr  = errors.New("Tokener: token not valid yet")
+	InvalidClaimErr      = errors.New("Tokener: invalid claim")
+)
+
+// DEFAULT_LEEWAY tolerates the clock skew between the services issuing and
+// verifying tokens.
+const DEFAULT_LEEWAY = 30 * time.Second
+
+// Tokener signs tokens with its newest signing Key and verifies them with the
+// Key their kid header names.
 type Tokener struct {
-	secret []byte
+	mutex  sync.RWMutex
+	keys   []*Key
+	leeway time.Duration
 }
 
+// GenerateTokenParams are the claims of a token. ID, its jti, defaults to a
+// new UUID so that two tokens are never equal.
 type GenerateTokenParams struct {
+	ID        string
 	Audience  string
 	ExpiresIn time.Duration
 	NotBefore time.Duration
 	Issuer    string
 	Subject   string
 
-	Email string `json:"email"`
-	UUID  string `json:"uuid"`
+	Email   string `json:"email"`
+	UUID    string `json:"uuid"`
+	Session string `json:"session"`
 }
 
+// Claims are the claims of a verified token.
+type Claims struct {
+	ID        string
+	Audience  string
+	Issuer    string
+	Subject   string
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time // Zero if the token has no nbf
+
+	Email   string
+	UUID    string
+	Session string
+}
+
+type tokenClaims struct {
+	jwt.StandardClaims
+
+	Email   string `json:"email"`
+	UUID    string `json:"uuid"`
+	Session string `json:"session,omitempty"`
+}
+
+// NewTokener returns a Tokener signing with an HS256 secret, without kid.
 func NewTokener(secret string) *Tokener {
 	return &Tokener{
-		secret: []byte(secret),
+		keys:   []*Key{NewSecretKey("", secret)},
+		leeway: DEFAULT_LEEWAY,
+	}
+}
+
+// NewKeyTokener returns a Tokener with keys, which must have distinct IDs.
+func NewKeyTokener(keys ...*Key) (*Tokener, error) {
+	tokener := &Tokener{leeway: DEFAULT_LEEWAY}
+
+	err := tokener.SetKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokener, nil
+}
+
+// SetKeys replaces the keys of tokener, to rotate them while it runs.
+func (tokener *Tokener) SetKeys(keys []*Key) error {
+	ids := map[string]bool{}
+
+	for _, key := range keys {
+		if ids[key.ID] {
+			return fmt.Errorf("%w: %s", DuplicateKeyErr, key.ID)
+		}
+
+		ids[key.ID] = true
+	}
+
+	tokener.mutex.Lock()
+	tokener.keys = keys
+	tokener.mutex.Unlock()
+
+	return nil
+}
+
+// SetLeeway sets how far the clock of tokener may be from that of the issuer
+// of the tokens it verifies.
+func (tokener *Tokener) SetLeeway(leeway time.Duration) {
+	tokener.mutex.Lock()
+	tokener.leeway = leeway
+	tokener.mutex.Unlock()
+}
+
+// Leeway returns how long after their expiration tokens are still accepted.
+func (tokener *Tokener) Leeway() time.Duration {
+	tokener.mutex.RLock()
+	defer tokener.mutex.RUnlock()
+
+	return tokener.leeway
+}
+
+// signingKey returns the key that started signing last.
+func (tokener *Tokener) signingKey(now time.Time) (*Key, error) {
+	tokener.mutex.RLock()
+	defer tokener.mutex.RUnlock()
+
+	var result *Key
+
+	for _, key := range tokener.keys {
+		if key.signs(now) && (result == nil || key.SignFrom.After(result.SignFrom)) {
+			result = key
+		}
+	}
+
+	if result == nil {
+		return nil, NoSigningKeyErr
+	}
+
+	return result, nil
+}
+
+func (tokener *Tokener) verifyingKey(id string, now time.Time) (*Key, error) {
+	tokener.mutex.RLock()
+	defer tokener.mutex.RUnlock()
+
+	for _, key := range tokener.keys {
+		if key.ID == id && key.verifies(now) {
+			return key, nil
+		}
 	}
+
+	return nil, fmt.Errorf("%w: %q", UnknownKeyErr, id)
 }
 
 func (tokener *Tokener) Generate(params GenerateTokenParams) (string, error) {
 	now := time.Now()
 
-	claims := struct {
-		jwt.StandardClaims
+	key, err := tokener.signingKey(now)
+	if err != nil {
+		return "", err
+	}
+
+	id := params.ID
+	if id == "" {
+		id = entity.NewUUID()
+	}
 
-		Email string `json:"email"`
-		UUID  string `json:"uuid"`
-	}{
+	claims := tokenClaims{
 		StandardClaims: jwt.StandardClaims{
 			Audience:  params.Audience,
 			ExpiresAt: now.Add(params.ExpiresIn).Unix(),
+			Id:        id,
 			IssuedAt:  now.Unix(),
 			Issuer:    params.Issuer,
-			// NotBefore: params.NotBefore,
-			Subject: params.Subject,
+			Subject:   params.Subject,
 		},
 
-		Email: params.Email,
-		UUID:  params.UUID,
+		Email:   params.Email,
+		UUID:    params.UUID,
+		Session: params.Session,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if params.NotBefore != 0 {
+		claims.NotBefore = now.Add(params.NotBefore).Unix()
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
 
-	return token.SignedString(tokener.secret)
+	return token.SignedString(key.private)
 }
 
-func (tokener *Tokener) Verify(str string) (interface{}, error) {
-	token, err := jwt.Parse(str, func(token *jwt.Token) (interface{}, error) {
-		return tokener.secret, nil
-	})
+// VerifyTokenParams are the claims a token must have. They are compared
+// exactly, an empty one requiring the token to lack it.
+type VerifyTokenParams struct {
+	Audience string
+	Issuer   string
+	Subject  string
+}
 
-	if token.Valid {
-		return token, nil
-	}
+// Verify checks the signature of str with the key its kid names, using the
+// algorithm of that key, then its time claims, with the leeway of tokener,
+// and finally the claims of params.
+func (tokener *Tokener) Verify(str string, params VerifyTokenParams) (*Claims, error) {
+	claims := &tokenClaims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(str, claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+
+		key, err := tokener.verifyingKey(id, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		// The key, not the token, decides the algorithm
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("%w: %s instead of %s",
+				AlgorithmMismatchErr, token.Method.Alg(), key.Algorithm)
+		}
+
+		return key.public, nil
+	})
 
 	if err, ok := err.(*jwt.ValidationError); ok {
+		// Unknown key or algorithm
+		if err.Errors&jwt.ValidationErrorUnverifiable != 0 && err.Inner != nil {
+			return nil, err.Inner
+		}
+
 		return nil, err
-	} else {
+	} else if err != nil {
 		return nil, fmt.Errorf("Tokener: couldn't handle token: %s", err)
 	}
+
+	err = tokener.validate(claims, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Claims{
+		ID:        claims.Id,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		Email:     claims.Email,
+		UUID:      claims.UUID,
+		Session:   claims.Session,
+	}
+
+	if claims.NotBefore != 0 {
+		result.NotBefore = time.Unix(claims.NotBefore, 0)
+	}
+
+	return result, nil
+}
+
+// validate checks the time claims of a token before the others, an expired
+// token being reported as such whatever it was issued for.
+func (tokener *Tokener) validate(claims *tokenClaims, params VerifyTokenParams) error {
+	tokener.mutex.RLock()
+	leeway := tokener.leeway
+	tokener.mutex.RUnlock()
+
+	now := time.Now()
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: exp is missing", InvalidClaimErr)
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if now.After(expiresAt.Add(leeway)) {
+		return fmt.Errorf("%w: since %s", ExpiredTokenErr, expiresAt)
+	}
+
+	notBefore := time.Unix(claims.NotBefore, 0)
+	if claims.NotBefore != 0 && now.Add(leeway).Before(notBefore) {
+		return fmt.Errorf("%w: until %s", NotYetValidTokenErr, notBefore)
+	}
+
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if claims.IssuedAt != 0 && now.Add(leeway).Before(issuedAt) {
+		return fmt.Errorf("%w: iat %s is in the future", InvalidClaimErr, issuedAt)
+	}
+
+	for _, claim := range []struct{ name, expected, actual string }{
+		{"aud", params.Audience, claims.Audience},
+		{"iss", params.Issuer, claims.Issuer},
+		{"sub", params.Subject, claims.Subject},
+	} {
+		if claim.actual != claim.expected {
+			return fmt.Errorf("%w: %s %q instead of %q",
+				InvalidClaimErr, claim.name, claim.actual, claim.expected)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,360 @@
+package usecase
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func newTestKeys(t *testing.T) map[string]*Key {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey should not fail: %s", err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey should not fail: %s", err)
+	}
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey should not fail: %s", err)
+	}
+
+	keys := map[string]*Key{HS256: NewSecretKey("hs", "secret")}
+
+	for id, private := range map[string]interface{}{
+		"rs": rsaKey,
+		"es": ecdsaKey,
+		"ed": ed25519Key,
+	} {
+		key, err := NewKey(id, private)
+		if err != nil {
+			t.Fatalf("NewKey %s should not fail: %s", id, err)
+		}
+
+		keys[key.Algorithm] = key
+	}
+
+	return keys
+}
+
+func TestTokenerAlgorithms(t *testing.T) {
+	for algorithm, key := range newTestKeys(t) {
+		tokener, err := NewKeyTokener(key)
+		if err != nil {
+			t.Fatalf("%s: NewKeyTokener should not fail: %s", algorithm, err)
+		}
+
+		token, err := tokener.Generate(GenerateTokenParams{
+			ExpiresIn: time.Hour,
+			UUID:      "uuid",
+		})
+
+		if err != nil {
+			t.Fatalf("%s: Generate should not fail: %s", algorithm, err)
+		}
+
+		claims, err := tokener.Verify(token, VerifyTokenParams{})
+		if err != nil {
+			t.Fatalf("%s: Verify should not fail: %s", algorithm, err)
+		}
+
+		if claims.UUID != "uuid" {
+			t.Errorf("%s: Verify should return UUID uuid, not %s", algorithm, claims.UUID)
+		}
+	}
+}
+
+func TestTokenerRotation(t *testing.T) {
+	keys := newTestKeys(t)
+	previous, next := keys[RS256], keys[EDDSA]
+
+	previous.VerifyUntil = time.Now().Add(time.Hour)
+	next.SignFrom = time.Now().Add(time.Hour)
+
+	tokener, err := NewKeyTokener(previous, next)
+	if err != nil {
+		t.Fatalf("NewKeyTokener should not fail: %s", err)
+	}
+
+	token, err := tokener.Generate(GenerateTokenParams{ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("Generate should not fail: %s", err)
+	}
+
+	if len(tokener.JWKS().Keys) != 2 {
+		t.Errorf("JWKS should publish the scheduled key")
+	}
+
+	// The next key starts signing, the previous one still verifies
+	next.SignFrom = time.Now().Add(-time.Minute)
+
+	rotated, err := tokener.Generate(GenerateTokenParams{ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("Generate should not fail: %s", err)
+	}
+
+	for _, token := range []string{token, rotated} {
+		_, err = tokener.Verify(token, VerifyTokenParams{})
+		if err != nil {
+			t.Errorf("Verify should not fail: %s", err)
+		}
+	}
+
+	// The previous key retires
+	previous.VerifyUntil = time.Now().Add(-time.Minute)
+
+	_, err = tokener.Verify(token, VerifyTokenParams{})
+	if !errors.Is(err, UnknownKeyErr) {
+		t.Errorf("Verify should fail with UnknownKeyErr, not %v", err)
+	}
+
+	_, err = NewKeyTokener(previous, previous)
+	if !errors.Is(err, DuplicateKeyErr) {
+		t.Errorf("NewKeyTokener should fail with DuplicateKeyErr, not %v", err)
+	}
+}
+
+func TestTokenerAlgorithmMismatch(t *testing.T) {
+	keys := newTestKeys(t)
+
+	signer, err := NewKeyTokener(NewSecretKey(keys[RS256].ID, "secret"))
+	if err != nil {
+		t.Fatalf("NewKeyTokener should not fail: %s", err)
+	}
+
+	token, err := signer.Generate(GenerateTokenParams{ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("Generate should not fail: %s", err)
+	}
+
+	verifier, err := NewKeyTokener(keys[RS256])
+	if err != nil {
+		t.Fatalf("NewKeyTokener should not fail: %s", err)
+	}
+
+	_, err = verifier.Verify(token, VerifyTokenParams{})
+	if !errors.Is(err, AlgorithmMismatchErr) {
+		t.Errorf("Verify should fail with AlgorithmMismatchErr, not %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+
+	signer, err := NewKeyTokener(keys[HS256], keys[RS256], keys[ES256], keys[EDDSA])
+	if err != nil {
+		t.Fatalf("NewKeyTokener should not fail: %s", err)
+	}
+
+	data, err := json.Marshal(signer.JWKS())
+	if err != nil {
+		t.Fatalf("json.Marshal should not fail: %s", err)
+	}
+
+	jwks := &JWKS{}
+
+	err = json.Unmarshal(data, jwks)
+	if err != nil {
+		t.Fatalf("json.Unmarshal should not fail: %s", err)
+	}
+
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("JWKS should publish 3 asymmetric keys, not %d", len(jwks.Keys))
+	}
+
+	published, err := KeysFromJWKS(jwks)
+	if err != nil {
+		t.Fatalf("KeysFromJWKS should not fail: %s", err)
+	}
+
+	verifier, err := NewKeyTokener(published...)
+	if err != nil {
+		t.Fatalf("NewKeyTokener should not fail: %s", err)
+	}
+
+	for _, algorithm := range []string{RS256, ES256, EDDSA} {
+		tokener, _ := NewKeyTokener(keys[algorithm])
+
+		token, err := tokener.Generate(GenerateTokenParams{ExpiresIn: time.Hour})
+		if err != nil {
+			t.Fatalf("%s: Generate should not fail: %s", algorithm, err)
+		}
+
+		_, err = verifier.Verify(token, VerifyTokenParams{})
+		if err != nil {
+			t.Errorf("%s: Verify with the published keys should not fail: %s", algorithm, err)
+		}
+	}
+
+	_, err = verifier.Generate(GenerateTokenParams{ExpiresIn: time.Hour})
+	if !errors.Is(err, NoSigningKeyErr) {
+		t.Errorf("Generate should fail with NoSigningKeyErr, not %v", err)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boxy-keys")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir should not fail: %s", err)
+	}
+
+	defer os.RemoveAll(directory)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+
+	ecdsaDER, _ := x509.MarshalECPrivateKey(ecdsaKey)
+	ed25519DER, _ := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	files := map[string]*pem.Block{
+		"rs.pem":     {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"es.pem":     {Type: "EC PRIVATE KEY", Bytes: ecdsaDER},
+		"ed.pem":     {Type: "PRIVATE KEY", Bytes: ed25519DER},
+		"public.pem": {Type: "PUBLIC KEY", Bytes: publicDER},
+	}
+
+	for name, block := range files {
+		err = ioutil.WriteFile(filepath.Join(directory, name), pem.EncodeToMemory(block), 0600)
+		if err != nil {
+			t.Fatalf("ioutil.WriteFile should not fail: %s", err)
+		}
+	}
+
+	path := filepath.Join(directory, "keys.json")
+
+	err = ioutil.WriteFile(path, []byte(`{"keys": [
+		{"kid": "rs", "path": "rs.pem", "verifyUntil": "2100-01-01T00:00:00Z"},
+		{"kid": "es", "path": "es.pem", "signFrom": "2020-01-01T00:00:00Z"},
+		{"kid": "ed", "path": "ed.pem", "signFrom": "2100-01-01T00:00:00Z"},
+		{"kid": "public", "path": "public.pem"}
+	]}`), 0600)
+
+	if err != nil {
+		t.Fatalf("ioutil.WriteFile should not fail: %s", err)
+	}
+
+	keys, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatalf("LoadKeySet should not fail: %s", err)
+	}
+
+	algorithms := map[string]string{"rs": RS256, "es": ES256, "ed": EDDSA, "public": RS256}
+
+	for _, key := range keys {
+		if key.Algorithm != algorithms[key.ID] {
+			t.Errorf("Key %s should be %s, not %s", key.ID, algorithms[key.ID], key.Algorithm)
+		}
+	}
+
+	if keys[0].VerifyUntil.Year() != 2100 || keys[2].SignFrom.Year() != 2100 {
+		t.Errorf("LoadKeySet should schedule the keys")
+	}
+
+	tokener, err := NewKeyTokener(keys...)
+	if err != nil {
+		t.Fatalf("NewKeyTokener should not fail: %s", err)
+	}
+
+	// es replaced rs, ed is scheduled and public only verifies
+	key, err := tokener.signingKey(time.Now())
+	if err != nil || key.ID != "es" {
+		t.Errorf("es should sign, not %v (%v)", key, err)
+	}
+
+	_, err = LoadKey("missing", filepath.Join(directory, "keys.json"))
+	if !errors.Is(err, UnsupportedKeyErr) {
+		t.Errorf("LoadKey should fail with UnsupportedKeyErr, not %v", err)
+	}
+}
+
+func TestTokenerClaims(t *testing.T) {
+	tokener := NewTokener("secret")
+	tokener.SetLeeway(time.Minute)
+
+	expected := VerifyTokenParams{Audience: "Users", Issuer: "Login", Subject: "Access"}
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		notBefore time.Duration
+		params    VerifyTokenParams
+		err       error
+	}{
+		{"Valid", time.Hour, 0, expected, nil},
+		{"Expired within leeway", -30 * time.Second, 0, expected, nil},
+		{"Expired", -2 * time.Minute, 0, expected, ExpiredTokenErr},
+		{"Not before within leeway", time.Hour, 30 * time.Second, expected, nil},
+		{"Not yet valid", time.Hour, 2 * time.Minute, expected, NotYetValidTokenErr},
+		{"Bad audience", time.Hour, 0,
+			VerifyTokenParams{Audience: "Boxes", Issuer: "Login", Subject: "Access"}, InvalidClaimErr},
+		{"Bad issuer", time.Hour, 0,
+			VerifyTokenParams{Audience: "Users", Issuer: "Box", Subject: "Access"}, InvalidClaimErr},
+		{"Bad subject", time.Hour, 0,
+			VerifyTokenParams{Audience: "Users", Issuer: "Login", Subject: "Refresh"}, InvalidClaimErr},
+		{"Missing subject", time.Hour, 0,
+			VerifyTokenParams{Audience: "Users", Issuer: "Login"}, InvalidClaimErr},
+	}
+
+	for _, test := range tests {
+		token, err := tokener.Generate(GenerateTokenParams{
+			Audience:  "Users",
+			ExpiresIn: test.expiresIn,
+			NotBefore: test.notBefore,
+			Issuer:    "Login",
+			Subject:   "Access",
+		})
+
+		if err != nil {
+			t.Fatalf("%s: Generate should not fail: %s", test.name, err)
+		}
+
+		claims, err := tokener.Verify(token, test.params)
+
+		if test.err == nil && err != nil {
+			t.Errorf("%s: Verify should not fail: %s", test.name, err)
+		}
+
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: Verify should fail with %s, not %v", test.name, test.err, err)
+		}
+
+		if err == nil && test.notBefore != 0 && claims.NotBefore.IsZero() {
+			t.Errorf("%s: Verify should return NotBefore", test.name)
+		}
+	}
+}
+
+func TestTokenerAlgorithmNone(t *testing.T) {
+	tokener := NewTokener("secret")
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	if err != nil {
+		t.Fatalf("SignedString should not fail: %s", err)
+	}
+
+	_, err = tokener.Verify(token, VerifyTokenParams{})
+	if !errors.Is(err, AlgorithmMismatchErr) {
+		t.Errorf("Verify should fail with AlgorithmMismatchErr, not %v", err)
+	}
+}